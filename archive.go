@@ -0,0 +1,392 @@
+package lacodex
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/asdine/storm"
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/imagedb"
+	"github.com/konkers/lacodex/model"
+	"github.com/konkers/lacodex/search"
+)
+
+// Files in a codex archive.  Images are stored as PNGs named by their hash
+// under archiveImageDir.
+const (
+	archiveRecords     = "records.json"
+	archiveCorrections = "corrections.json"
+	archiveImages      = "images.json"
+	archiveFailures    = "failures.json"
+	archiveImageDir    = "images/"
+)
+
+// ArchiveReport is the result of ImportArchive.
+type ArchiveReport struct {
+	// Records is the number of records added and MergedRecords the number
+	// that were duplicates of existing records.
+	Records       int `json:"records"`
+	MergedRecords int `json:"merged_records"`
+
+	// Images is the number of images added.  SkippedImages were already in
+	// the database and RenamedImages had the file name of a different
+	// image so were added under a new one.
+	Images        int `json:"images"`
+	SkippedImages int `json:"skipped_images"`
+	RenamedImages int `json:"renamed_images"`
+
+	// Blobs is the number of images whose contents weren't already stored.
+	Blobs int `json:"blobs"`
+}
+
+func writeArchiveJson(zw *zip.Writer, name string, v interface{}) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(v)
+}
+
+// ExportArchive writes all records, corrections, images and ingestion
+// failures to w as a zip archive that ImportArchive can read.
+func (l *LaCodex) ExportArchive(w io.Writer) error {
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	records := []*model.Record{}
+	err := l.records.All(&records)
+	if err != nil {
+		return err
+	}
+	corrections := []*model.RecordCorrection{}
+	err = l.corrections.All(&corrections)
+	if err != nil {
+		return err
+	}
+	failures := []*model.IngestFailure{}
+	err = l.failures.All(&failures)
+	if err != nil {
+		return err
+	}
+	metas, err := l.idb.ListImages()
+	if err != nil {
+		return err
+	}
+	if metas == nil {
+		metas = []*model.ImageMetadata{}
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{archiveRecords, records},
+		{archiveCorrections, corrections},
+		{archiveImages, metas},
+		{archiveFailures, failures},
+	}
+	for _, f := range files {
+		err = writeArchiveJson(zw, f.name, f.v)
+		if err != nil {
+			return err
+		}
+	}
+
+	written := map[string]bool{}
+	for _, meta := range metas {
+		if written[meta.Hash] {
+			continue
+		}
+		written[meta.Hash] = true
+
+		data, err := l.idb.GetImageData(meta.Hash)
+		if err != nil {
+			return fmt.Errorf("Can't load image %s: %v", meta.Hash, err)
+		}
+		// PNGs are already compressed.
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   archiveImageDir + meta.Hash + ".png",
+			Method: zip.Store,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fw.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// codexArchive is an archive opened for import.
+type codexArchive struct {
+	files       map[string]*zip.File
+	records     []*model.Record
+	corrections []*model.RecordCorrection
+	images      []*model.ImageMetadata
+	failures    []*model.IngestFailure
+}
+
+func (a *codexArchive) readFile(name string) ([]byte, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("Archive has no %s", name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (a *codexArchive) readJson(name string, v interface{}) error {
+	data, err := a.readFile(name)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("Can't decode %s: %v", name, err)
+	}
+	return nil
+}
+
+func (a *codexArchive) imageData(hash string) ([]byte, error) {
+	return a.readFile(archiveImageDir + hash + ".png")
+}
+
+func openArchive(r io.ReaderAt, size int64) (*codexArchive, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &codexArchive{files: map[string]*zip.File{}}
+	for _, f := range zr.File {
+		a.files[f.Name] = f
+	}
+
+	for name, v := range map[string]interface{}{
+		archiveRecords:     &a.records,
+		archiveCorrections: &a.corrections,
+		archiveImages:      &a.images,
+		archiveFailures:    &a.failures,
+	} {
+		err = a.readJson(name, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Check every image before anything is imported.
+	checked := map[string]bool{}
+	for _, meta := range a.images {
+		if checked[meta.Hash] {
+			continue
+		}
+		checked[meta.Hash] = true
+		data, err := a.imageData(meta.Hash)
+		if err != nil {
+			return nil, err
+		}
+		_, err = imagedb.VerifyImageData(meta.Hash, data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(a.records, func(i, j int) bool { return a.records[i].Id < a.records[j].Id })
+	return a, nil
+}
+
+// importRecords adds the archived records that aren't duplicates of existing
+// ones.  Returns a map from archived record Ids to Ids in the database,
+// which the caller brings the search index up to date for.  Must be called
+// with recordMutex held.
+func (l *LaCodex) importRecords(a *codexArchive, report *ArchiveReport) (map[int]int, error) {
+	ids := map[int]int{}
+	for _, archived := range a.records {
+		record := *archived
+		record.Id = 0
+		record.Translations = nil
+
+		dup, err := l.findDuplicate(&record)
+		if err != nil {
+			return nil, err
+		}
+		if dup != nil {
+			ids[archived.Id] = dup.Id
+			report.MergedRecords++
+			continue
+		}
+
+		err = l.records.Save(&record)
+		if err != nil {
+			return nil, err
+		}
+		ids[archived.Id] = record.Id
+		report.Records++
+	}
+
+	// Corrections of merged records are kept if the existing record
+	// doesn't have its own, like mergeRecord.
+	for _, archived := range a.corrections {
+		id, ok := ids[archived.Id]
+		if !ok {
+			continue
+		}
+		var existing model.RecordCorrection
+		err := l.corrections.One("Id", id, &existing)
+		if err == nil {
+			continue
+		}
+		if err != storm.ErrNotFound {
+			return nil, err
+		}
+		c := *archived
+		c.Id = id
+		err = l.corrections.Save(&c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, archived := range a.records {
+		for _, t := range archived.Translations {
+			from, to := ids[archived.Id], ids[t]
+			if from == 0 || to == 0 || from == to {
+				continue
+			}
+			err := l.linkTranslations(from, to)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ids, nil
+}
+
+// uniqueFileName returns fileName with a number added so that it doesn't
+// clash with an existing image.
+func (l *LaCodex) uniqueFileName(fileName string) string {
+	ext := path.Ext(fileName)
+	stem := strings.TrimSuffix(fileName, ext)
+	for i := 1; ; i++ {
+		name := fmt.Sprintf("%s-%d%s", stem, i, ext)
+		if meta, _ := l.idb.LookupFile(name); meta == nil {
+			return name
+		}
+	}
+}
+
+// importImages adds the archived images that aren't already in the
+// database and points them at their records.  Must be called with
+// recordMutex held.
+func (l *LaCodex) importImages(a *codexArchive, recordIds map[int]int, report *ArchiveReport) error {
+	failures := map[int]*model.IngestFailure{}
+	for _, f := range a.failures {
+		failures[f.ImageId] = f
+	}
+
+	for _, archived := range a.images {
+		meta := *archived
+		meta.Record = recordIds[archived.Record]
+
+		if existing, _ := l.idb.LookupFile(meta.FileName); existing != nil {
+			if existing.Hash == meta.Hash {
+				report.SkippedImages++
+				continue
+			}
+			meta.FileName = l.uniqueFileName(meta.FileName)
+			report.RenamedImages++
+		}
+
+		data, err := a.imageData(meta.Hash)
+		if err != nil {
+			return err
+		}
+		stored, err := l.idb.ImportImage(&meta, data)
+		if err != nil {
+			return err
+		}
+		report.Images++
+		if stored {
+			report.Blobs++
+		}
+
+		if f, ok := failures[archived.Id]; ok {
+			failure := *f
+			failure.ImageId = meta.Id
+			failure.FileName = meta.FileName
+			err = l.failures.Save(&failure)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ImportArchive merges an archive written by ExportArchive into the
+// database.  Records that are duplicates of existing ones are merged and
+// images that are already stored are skipped.  The import is done in a
+// single transaction so nothing is changed if it fails.
+func (l *LaCodex) ImportArchive(r io.ReaderAt, size int64) (*ArchiveReport, error) {
+	a, err := openArchive(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	tx, err := l.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The search index isn't part of the transaction so the import updates
+	// a scratch one and the real one is brought up to date after it is
+	// committed.
+	c := l.withTx(tx)
+	c.search = search.NewIndex()
+
+	report := &ArchiveReport{}
+	ids, err := c.importRecords(a, report)
+	if err != nil {
+		return nil, err
+	}
+	err = c.importImages(a, ids, report)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]int, 0, len(ids))
+	for _, id := range ids {
+		changed = append(changed, id)
+	}
+	err = l.syncSearch(changed)
+	if err != nil {
+		return report, err
+	}
+	glog.Infof("imported %d records (%d merged) and %d images (%d skipped)",
+		report.Records, report.MergedRecords, report.Images, report.SkippedImages)
+
+	l.publishUpdate()
+	return report, nil
+}
@@ -0,0 +1,131 @@
+package lacodex
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// newArchiveTestLC returns a codex with an ingested record, a translation
+// of it, a correction and a failed image.
+func newArchiveTestLC(t *testing.T) *testLC {
+	tlc := newTestLC(t)
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134145_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+
+	ja := &model.Record{
+		Type:       model.RecordTypeScanner,
+		Text:       "天に3つの光を捧げよ",
+		Language:   model.LanguageJapanese,
+		Keyphrases: map[model.KeyphraseType][]string{},
+	}
+	err := tlc.l.records.Save(ja)
+	assert.NoError(t, err)
+	err = tlc.l.linkTranslations(1, ja.Id)
+	assert.NoError(t, err)
+
+	subject := "Offering"
+	err = tlc.l.corrections.Save(&model.RecordCorrection{Id: 1, Subject: &subject})
+	assert.NoError(t, err)
+	return tlc
+}
+
+func exportTestArchive(t *testing.T, tlc *testLC) *bytes.Reader {
+	var buf bytes.Buffer
+	err := tlc.l.ExportArchive(&buf)
+	assert.NoError(t, err)
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := newArchiveTestLC(t)
+	defer src.Shutdown()
+	archive := exportTestArchive(t, src)
+
+	dst := newTestLC(t)
+	defer dst.Shutdown()
+	report, err := dst.l.ImportArchive(archive, archive.Size())
+	assert.NoError(t, err)
+	assert.Equal(t, &ArchiveReport{Records: 2, Images: 3, Blobs: 2}, report)
+
+	assert.Equal(t, src.GetRecords(t), dst.GetRecords(t))
+	assert.Equal(t, src.GetImages(t), dst.GetImages(t))
+	assert.Equal(t, src.GetFailures(t), dst.GetFailures(t))
+	record, err := dst.l.getRecord(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "Offering", record.Subject)
+	assert.Len(t, dst.Search(t, "q=offering"), 1)
+
+	// Importing again changes nothing.
+	report, err = dst.l.ImportArchive(archive, archive.Size())
+	assert.NoError(t, err)
+	assert.Equal(t, &ArchiveReport{MergedRecords: 2, SkippedImages: 3}, report)
+	assert.Equal(t, src.GetRecords(t), dst.GetRecords(t))
+	assert.Equal(t, src.GetImages(t), dst.GetImages(t))
+}
+
+func TestArchiveMerge(t *testing.T) {
+	src := newArchiveTestLC(t)
+	defer src.Shutdown()
+	archive := exportTestArchive(t, src)
+
+	// A different image with the name of one in the archive.  It fails
+	// ingestion so the archived records get new Ids.
+	dst := newTestLC(t)
+	defer dst.Shutdown()
+	dst.PutImageAs(t, "testdata/screenshots/230700_20190517185334_1.png", "230700_20190519134140_1.png", nil)
+	dst.l.records.Save(&model.Record{Type: model.RecordTypeTent, Text: "Welcome",
+		Keyphrases: map[model.KeyphraseType][]string{}})
+
+	report, err := dst.l.ImportArchive(archive, archive.Size())
+	assert.NoError(t, err)
+	assert.Equal(t, &ArchiveReport{Records: 2, Images: 3, RenamedImages: 1, Blobs: 1}, report)
+
+	imgs := dst.GetImages(t)
+	if assert.Len(t, imgs, 4) {
+		assert.Equal(t, "230700_20190519134140_1-1.png", imgs[1].FileName)
+		assert.Equal(t, 2, imgs[1].Record)
+		assert.Equal(t, 2, imgs[2].Record)
+		assert.Equal(t, 0, imgs[3].Record)
+	}
+	record, err := dst.l.getRecord(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "Offering", record.Subject)
+	assert.Equal(t, []int{3}, record.Translations)
+
+	failures := dst.GetFailures(t)
+	if assert.Len(t, failures, 2) {
+		assert.Equal(t, 4, failures[1].ImageId)
+		assert.Equal(t, "230700_20190517185334_1.png", failures[1].FileName)
+	}
+}
+
+func TestImportBadArchive(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	_, err := tlc.l.ImportArchive(bytes.NewReader([]byte("not a zip")), 9)
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{archiveRecords, archiveCorrections, archiveFailures} {
+		w, _ := zw.Create(name)
+		w.Write([]byte("[]"))
+	}
+	w, _ := zw.Create(archiveImages)
+	json.NewEncoder(w).Encode([]*model.ImageMetadata{{Hash: testImageHash, FileName: "a.png"}})
+	w, _ = zw.Create(archiveImageDir + testImageHash + ".png")
+	w.Write([]byte("not a png"))
+	zw.Close()
+
+	archive := bytes.NewReader(buf.Bytes())
+	_, err = tlc.l.ImportArchive(archive, archive.Size())
+	assert.EqualError(t, err, "Can't decode image "+testImageHash+": image: unknown format")
+	assert.Empty(t, tlc.GetImages(t))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/konkers/lacodex"
+)
+
+type exportArchiveCmd struct {
+	dbPath  string
+	outPath string
+}
+
+func (*exportArchiveCmd) Name() string     { return "export-archive" }
+func (*exportArchiveCmd) Synopsis() string { return "Write a codex database to a portable archive." }
+func (*exportArchiveCmd) Usage() string {
+	return `export-archive --db <path> --out <archive.zip>:
+	Write all records, images and their metadata to a zip archive that
+	import-archive can read.
+  `
+}
+func (p *exportArchiveCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dbPath, "db", "", "Path to the codex database.")
+	f.StringVar(&p.outPath, "out", "", "Archive to write.")
+}
+
+func (p *exportArchiveCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.dbPath == "" || p.outPath == "" {
+		fmt.Printf("--db and --out are required.\n")
+		return subcommands.ExitUsageError
+	}
+
+	l, err := lacodex.NewLaCodex(&lacodex.Config{DbPath: p.dbPath})
+	if err != nil {
+		fmt.Printf("Can't open %s: %v\n", p.dbPath, err)
+		return subcommands.ExitFailure
+	}
+	defer l.Close()
+
+	out, err := os.Create(p.outPath)
+	if err != nil {
+		fmt.Printf("Can't create %s: %v\n", p.outPath, err)
+		return subcommands.ExitFailure
+	}
+	err = l.ExportArchive(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("Export error: %v\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
+
+type importArchiveCmd struct {
+	dbPath string
+	inPath string
+}
+
+func (*importArchiveCmd) Name() string     { return "import-archive" }
+func (*importArchiveCmd) Synopsis() string { return "Merge a codex archive into a database." }
+func (*importArchiveCmd) Usage() string {
+	return `import-archive --db <path> --in <archive.zip>:
+	Merge an archive written by export-archive into a codex database.
+	Duplicate records are merged and images that are already in the
+	database are skipped.
+  `
+}
+func (p *importArchiveCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dbPath, "db", "", "Path to the codex database.")
+	f.StringVar(&p.inPath, "in", "", "Archive to read.")
+}
+
+func (p *importArchiveCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.dbPath == "" || p.inPath == "" {
+		fmt.Printf("--db and --in are required.\n")
+		return subcommands.ExitUsageError
+	}
+
+	in, err := os.Open(p.inPath)
+	if err != nil {
+		fmt.Printf("Can't open %s: %v\n", p.inPath, err)
+		return subcommands.ExitFailure
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		fmt.Printf("Can't stat %s: %v\n", p.inPath, err)
+		return subcommands.ExitFailure
+	}
+
+	l, err := lacodex.NewLaCodex(&lacodex.Config{DbPath: p.dbPath})
+	if err != nil {
+		fmt.Printf("Can't open %s: %v\n", p.dbPath, err)
+		return subcommands.ExitFailure
+	}
+	defer l.Close()
+
+	report, err := l.ImportArchive(in, info.Size())
+	if err != nil {
+		fmt.Printf("Import error: %v\n", err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("%d records added, %d merged into existing records\n",
+		report.Records, report.MergedRecords)
+	fmt.Printf("%d images added (%d renamed, %d new image files), %d already present\n",
+		report.Images, report.RenamedImages, report.Blobs, report.SkippedImages)
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/subcommands"
+	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/model"
+)
+
+type atlasCmd struct {
+	layoutFile string
+	cell       string
+	out        string
+	language   string
+}
+
+func (*atlasCmd) Name() string     { return "atlas" }
+func (*atlasCmd) Synopsis() string { return "Build a glyph atlas from labelled screenshots." }
+func (*atlasCmd) Usage() string {
+	return `atlas [--layout <file>] [--language en|ja] --cell <w>x<h> --out <atlas> <file>...:
+	Build a glyph atlas from screenshots.  Each screenshot is labelled by a
+	.txt file next to it holding its text exactly as laid out on the
+	character grid.
+  `
+}
+func (p *atlasCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.layoutFile, "layout", "", "Layout file to load over the built in layouts.")
+	f.StringVar(&p.cell, "cell", "", "Size of a character cell in native pixels, e.g. 10x20.")
+	f.StringVar(&p.out, "out", "atlas.json", "File to write the atlas to.")
+	f.StringVar(&p.language, "language", "", "Language of the game build the screenshots are from.")
+}
+
+func (p *atlasCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	var width, height int
+	_, err := fmt.Sscanf(p.cell, "%dx%d", &width, &height)
+	if err != nil {
+		fmt.Printf("Bad cell size %q: %v\n", p.cell, err)
+		return subcommands.ExitUsageError
+	}
+
+	var lang model.Language
+	err = lang.UnmarshalText([]byte(p.language))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return subcommands.ExitUsageError
+	}
+
+	if p.layoutFile != "" {
+		err := ingest.LoadLayoutFile(p.layoutFile)
+		if err != nil {
+			fmt.Printf("Can't load layout: %v\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	var samples []*ingest.GlyphSample
+	for _, fileName := range f.Args() {
+		img, err := openImage(fileName)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return subcommands.ExitFailure
+		}
+
+		labelName := strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".txt"
+		label, err := ioutil.ReadFile(labelName)
+		if err != nil {
+			fmt.Printf("Can't read label: %v\n", err)
+			return subcommands.ExitFailure
+		}
+
+		sample, err := ingest.NewGlyphSample(fileName, img, string(label))
+		if err != nil {
+			fmt.Printf("Can't sample %s: %v\n", fileName, err)
+			return subcommands.ExitFailure
+		}
+		samples = append(samples, sample)
+	}
+
+	atlas, warnings, err := ingest.BuildGlyphAtlas(width, height, samples)
+	if err != nil {
+		fmt.Printf("Can't build atlas: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	atlas.Language = lang
+	for _, w := range warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+
+	b, err := json.MarshalIndent(atlas, "", "  ")
+	if err != nil {
+		fmt.Printf("Failed to encode atlas: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	err = ioutil.WriteFile(p.out, b, 0644)
+	if err != nil {
+		fmt.Printf("Can't write atlas: %v\n", err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("Wrote %d glyphs to %s.\n", len(atlas.Glyphs), p.out)
+	return subcommands.ExitSuccess
+}
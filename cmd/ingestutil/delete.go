@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+	"github.com/konkers/lacodex"
+)
+
+type deleteCmd struct {
+	dbPath string
+}
+
+func (*deleteCmd) Name() string     { return "delete" }
+func (*deleteCmd) Synopsis() string { return "Remove images from the database." }
+func (*deleteCmd) Usage() string {
+	return `delete --db <path> <file name>...:
+	Remove images by file name.  Their records are removed too if no other
+	image points to them.
+  `
+}
+func (p *deleteCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dbPath, "db", "", "Path to the codex database.")
+}
+
+func (p *deleteCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.dbPath == "" || f.NArg() == 0 {
+		fmt.Printf("--db and at least one file name are required.\n")
+		return subcommands.ExitUsageError
+	}
+
+	l, err := lacodex.NewLaCodex(&lacodex.Config{DbPath: p.dbPath})
+	if err != nil {
+		fmt.Printf("Can't open %s: %v\n", p.dbPath, err)
+		return subcommands.ExitFailure
+	}
+	defer l.Close()
+
+	total := &lacodex.GCReport{}
+	for _, fileName := range f.Args() {
+		report, err := l.DeleteImage(fileName)
+		if err != nil {
+			fmt.Printf("Can't delete %s: %v\n", fileName, err)
+			return subcommands.ExitFailure
+		}
+		total.Blobs += report.Blobs
+		total.Bytes += report.Bytes
+		total.Records += report.Records
+		total.Failures += report.Failures
+	}
+
+	fmt.Printf("Removed %d images (%d bytes reclaimed), %d records and %d failures\n",
+		total.Blobs, total.Bytes, total.Records, total.Failures)
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/konkers/lacodex"
+	"github.com/konkers/lacodex/export"
+)
+
+type exportCmd struct {
+	dbPath  string
+	format  string
+	outPath string
+	baseURL string
+}
+
+func (*exportCmd) Name() string     { return "export" }
+func (*exportCmd) Synopsis() string { return "Export the records in a codex database." }
+func (*exportCmd) Usage() string {
+	return `export --db <path> [--format md|html|csv|json] [--out <path>] [--base-url <url>]:
+	Export all records grouped by type.
+  `
+}
+func (p *exportCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dbPath, "db", "", "Path to the codex database.")
+	f.StringVar(&p.format, "format", "md", "Export format: md, html, csv or json.")
+	f.StringVar(&p.outPath, "out", "", "File to write.  Defaults to stdout.")
+	f.StringVar(&p.baseURL, "base-url", "", "Prefix of screenshot links, e.g. http://localhost:8080.")
+}
+
+func (p *exportCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.dbPath == "" {
+		fmt.Printf("--db is required.\n")
+		return subcommands.ExitUsageError
+	}
+	format, err := export.ParseFormat(p.format)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return subcommands.ExitUsageError
+	}
+
+	l, err := lacodex.NewLaCodex(&lacodex.Config{DbPath: p.dbPath})
+	if err != nil {
+		fmt.Printf("Can't open %s: %v\n", p.dbPath, err)
+		return subcommands.ExitFailure
+	}
+	defer l.Close()
+
+	out := os.Stdout
+	if p.outPath != "" {
+		out, err = os.Create(p.outPath)
+		if err != nil {
+			fmt.Printf("Can't create %s: %v\n", p.outPath, err)
+			return subcommands.ExitFailure
+		}
+		defer out.Close()
+	}
+
+	w := bufio.NewWriter(out)
+	err = l.Export(w, format, p.baseURL)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Printf("Export error: %v\n", err)
+		return subcommands.ExitFailure
+	}
+
+	return subcommands.ExitSuccess
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"image"
+	_ "image/jpeg" // Pull in jpeg decoder.
 	"image/png"
 	"os"
 	"path/filepath"
@@ -12,6 +13,8 @@ import (
 
 	"github.com/google/subcommands"
 	"github.com/konkers/lacodex/ingest"
+	_ "golang.org/x/image/bmp"  // Pull in bmp decoder.
+	_ "golang.org/x/image/webp" // Pull in webp decoder.
 )
 
 type gamecropCmd struct{}
@@ -66,7 +69,9 @@ func (p *gamecropCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface
 			return subcommands.ExitFailure
 		}
 
-		croppedImg := ingest.CropGameImage(img)
+		frame := ingest.FindGameFrame(img)
+		fmt.Printf("%s: %v\n", fileName, frame)
+		croppedImg := ingest.CropGameFrame(img, frame)
 
 		err = writeImage(fileName, "game", croppedImg)
 		if err != nil {
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+	"github.com/konkers/lacodex"
+)
+
+type gcCmd struct {
+	dbPath string
+}
+
+func (*gcCmd) Name() string     { return "gc" }
+func (*gcCmd) Synopsis() string { return "Remove unreferenced images and records." }
+func (*gcCmd) Usage() string {
+	return `gc --db <path>:
+	Remove stored images that no file refers to and records that no image
+	points to.
+  `
+}
+func (p *gcCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dbPath, "db", "", "Path to the codex database.")
+}
+
+func (p *gcCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.dbPath == "" {
+		fmt.Printf("--db is required.\n")
+		return subcommands.ExitUsageError
+	}
+
+	l, err := lacodex.NewLaCodex(&lacodex.Config{DbPath: p.dbPath})
+	if err != nil {
+		fmt.Printf("Can't open %s: %v\n", p.dbPath, err)
+		return subcommands.ExitFailure
+	}
+	defer l.Close()
+
+	report, err := l.GC()
+	if err != nil {
+		fmt.Printf("GC error: %v\n", err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("Removed %d images (%d bytes reclaimed), %d records and %d failures\n",
+		report.Blobs, report.Bytes, report.Records, report.Failures)
+
+	return subcommands.ExitSuccess
+}
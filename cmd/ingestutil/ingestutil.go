@@ -12,8 +12,16 @@ func main() {
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
+	subcommands.Register(&atlasCmd{}, "")
+	subcommands.Register(&deleteCmd{}, "")
+	subcommands.Register(&exportCmd{}, "")
+	subcommands.Register(&exportArchiveCmd{}, "")
 	subcommands.Register(&gamecropCmd{}, "")
+	subcommands.Register(&gcCmd{}, "")
+	subcommands.Register(&importArchiveCmd{}, "")
+	subcommands.Register(&overlayCmd{}, "")
 	subcommands.Register(&processCmd{}, "")
+	subcommands.Register(&reprocessCmd{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
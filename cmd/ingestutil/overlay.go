@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/google/subcommands"
+	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/model"
+)
+
+type overlayCmd struct {
+	layoutFile string
+	screenType string
+}
+
+func (*overlayCmd) Name() string     { return "overlay" }
+func (*overlayCmd) Synopsis() string { return "Draw OCR regions over screenshots." }
+func (*overlayCmd) Usage() string {
+	return `overlay [--layout <file>] [--type <type>] <file>...:
+	Crop file to game size and outline the classification and OCR regions of
+	its screen type.  The screen type is classified unless --type is given.
+  `
+}
+func (p *overlayCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.layoutFile, "layout", "", "Layout file to load over the built in layouts.")
+	f.StringVar(&p.screenType, "type", "", "Screen type to draw instead of classifying.")
+}
+
+func (p *overlayCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.layoutFile != "" {
+		err := ingest.LoadLayoutFile(p.layoutFile)
+		if err != nil {
+			fmt.Printf("Can't load layout: %v\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	var screen *ingest.Screen
+	if p.screenType != "" {
+		var t model.RecordType
+		err := t.UnmarshalText([]byte(p.screenType))
+		if err == nil {
+			screen = ingest.LookupScreen(t)
+		}
+		if screen == nil {
+			fmt.Printf("Unknown screen type %s.\n", p.screenType)
+			return subcommands.ExitUsageError
+		}
+	}
+
+	for _, fileName := range f.Args() {
+		img, err := openImage(fileName)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return subcommands.ExitFailure
+		}
+
+		s := screen
+		if s == nil {
+			c, err := ingest.Classify(img)
+			if err != nil {
+				fmt.Printf("Can't classify %s: %v\n", fileName, err)
+				return subcommands.ExitFailure
+			}
+			fmt.Printf("%s: %v\n", fileName, c)
+			if !c.Matched {
+				fmt.Printf("%s doesn't match any screen type.\n", fileName)
+				return subcommands.ExitFailure
+			}
+			s = ingest.LookupScreen(c.Type)
+		}
+
+		err = writeImage(fileName, "overlay", ingest.DrawLayout(ingest.CropGameImage(img), s))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+	return subcommands.ExitSuccess
+}
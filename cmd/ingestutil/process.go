@@ -8,20 +8,31 @@ import (
 	"os"
 
 	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/model"
 
 	"github.com/google/subcommands"
 )
 
-type processCmd struct{}
+type processCmd struct {
+	layoutFile string
+	atlasFile  string
+	recordOCR  string
+	language   string
+}
 
 func (*processCmd) Name() string     { return "process" }
 func (*processCmd) Synopsis() string { return "Process and image and output it's JSON record." }
 func (*processCmd) Usage() string {
-	return `process <imange>:
+	return `process [--layout <file>] [--atlas <file>] [--record-ocr <dir>] [--language en|ja] <imange>:
 	Process and image and output it's JSON record."
   `
 }
-func (p *processCmd) SetFlags(f *flag.FlagSet) {}
+func (p *processCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.layoutFile, "layout", "", "Layout file to load over the built in layouts.")
+	f.StringVar(&p.atlasFile, "atlas", "", "Glyph atlas to recognize text with instead of tesseract.")
+	f.StringVar(&p.recordOCR, "record-ocr", "", "Directory to save OCR results to as test fixtures.")
+	f.StringVar(&p.language, "language", "", "Language of the game build the screenshot is from.")
+}
 
 func (p *processCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	if len(f.Args()) != 1 {
@@ -29,13 +40,42 @@ func (p *processCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 		return subcommands.ExitFailure
 	}
 
+	var lang model.Language
+	err := lang.UnmarshalText([]byte(p.language))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return subcommands.ExitUsageError
+	}
+
+	if p.layoutFile != "" {
+		err := ingest.LoadLayoutFile(p.layoutFile)
+		if err != nil {
+			fmt.Printf("Can't load layout: %v\n", err)
+			return subcommands.ExitFailure
+		}
+	}
+
+	var engine ingest.OCREngine = ingest.TesseractEngine{}
+	if p.atlasFile != "" {
+		atlas, err := ingest.LoadGlyphAtlas(p.atlasFile)
+		if err != nil {
+			fmt.Printf("Can't load atlas: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		engine = ingest.NewGlyphEngine(atlas)
+	}
+	if p.recordOCR != "" {
+		engine = &ingest.RecordingOCREngine{Engine: engine, Dir: p.recordOCR}
+	}
+	ingest.SetOCREngine(engine)
+
 	img, err := openImage(f.Args()[0])
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		return subcommands.ExitFailure
 	}
 
-	record, err := ingest.IngestImage(img)
+	record, err := ingest.IngestImage(img, lang)
 	if err != nil {
 		fmt.Printf("Ingest error: %v\n", err)
 		return subcommands.ExitFailure
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/google/subcommands"
+	"github.com/konkers/lacodex"
+	"github.com/konkers/lacodex/model"
+)
+
+type reprocessCmd struct {
+	dbPath string
+	dryRun bool
+}
+
+func (*reprocessCmd) Name() string     { return "reprocess" }
+func (*reprocessCmd) Synopsis() string { return "Re-run ingestion on all images in a codex database." }
+func (*reprocessCmd) Usage() string {
+	return `reprocess --db <path> [--dry-run]:
+	Re-run ingestion on all images in a codex database and update their records.
+  `
+}
+func (p *reprocessCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dbPath, "db", "", "Path to the codex database.")
+	f.BoolVar(&p.dryRun, "dry-run", false, "Report changes without saving them.")
+}
+
+func printKeyphrases(prefix string, keyphrases map[model.KeyphraseType][]string) {
+	for t, phrases := range keyphrases {
+		name, _ := t.MarshalText()
+		fmt.Printf("  %s%s: %s\n", prefix, name, strings.Join(phrases, ", "))
+	}
+}
+
+func (p *reprocessCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.dbPath == "" {
+		fmt.Printf("--db is required.\n")
+		return subcommands.ExitUsageError
+	}
+
+	l, err := lacodex.NewLaCodex(&lacodex.Config{DbPath: p.dbPath})
+	if err != nil {
+		fmt.Printf("Can't open %s: %v\n", p.dbPath, err)
+		return subcommands.ExitFailure
+	}
+	defer l.Close()
+
+	report, err := l.Reprocess(p.dryRun)
+	if err != nil {
+		fmt.Printf("Reprocess error: %v\n", err)
+		return subcommands.ExitFailure
+	}
+
+	for _, change := range report.Changes {
+		fmt.Printf("%s (record %d): %s\n", change.FileName, change.RecordId, change.Status)
+		if change.Error != "" {
+			fmt.Printf("  %s\n", change.Error)
+		}
+		for _, line := range change.TextDiff {
+			fmt.Printf("  %s\n", line)
+		}
+		printKeyphrases("+", change.AddedKeyphrases)
+		printKeyphrases("-", change.RemovedKeyphrases)
+	}
+
+	fmt.Printf("%d images: %d added, %d changed, %d unchanged, %d skipped, %d failed, %d errors",
+		report.Images, report.Added, report.Changed, report.Unchanged, report.Skipped,
+		report.Failed, report.Errors)
+	if report.DryRun {
+		fmt.Printf(" (dry run, nothing saved)")
+	}
+	fmt.Printf("\n")
+
+	return subcommands.ExitSuccess
+}
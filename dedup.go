@@ -0,0 +1,224 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/asdine/storm"
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/model"
+)
+
+// Default similarity above which two records are considered to be the same
+// in-game text.  This absorbs small amounts of OCR noise.
+const defaultDedupThreshold = 0.9
+
+// normalizeText lower cases s and collapses everything that isn't a letter
+// or digit into single spaces.
+func normalizeText(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteRune(' ')
+			}
+			space = false
+			b.WriteRune(unicode.ToLower(r))
+		} else {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// textSimilarity returns how similar the normalized versions of a and b are
+// from 0.0 to 1.0.
+func textSimilarity(a, b string) float64 {
+	aRunes := []rune(normalizeText(a))
+	bRunes := []rune(normalizeText(b))
+
+	maxLen := len(aRunes)
+	if len(bRunes) > maxLen {
+		maxLen = len(bRunes)
+	}
+	if maxLen == 0 {
+		return 1.0
+	}
+
+	// The edit distance is at least the difference in length so skip the
+	// expensive part for records that can't match.
+	minLen := len(aRunes) + len(bRunes) - maxLen
+	if float64(minLen)/float64(maxLen) < 0.5 {
+		return float64(minLen) / float64(maxLen)
+	}
+
+	return 1.0 - float64(levenshtein(aRunes, bRunes))/float64(maxLen)
+}
+
+func (l *LaCodex) dedupThreshold() float64 {
+	if l.config.DedupThreshold == 0 {
+		return defaultDedupThreshold
+	}
+	return l.config.DedupThreshold
+}
+
+// isDuplicate returns true if a and b are the same in-game text.  The same
+// text in another language is a translation rather than a duplicate.
+func (l *LaCodex) isDuplicate(a, b *model.Record) bool {
+	if a.Type != b.Type || a.Language.OrDefault() != b.Language.OrDefault() {
+		return false
+	}
+	if (a.Index == nil) != (b.Index == nil) ||
+		(a.Index != nil && *a.Index != *b.Index) {
+		return false
+	}
+
+	threshold := l.dedupThreshold()
+	return textSimilarity(a.Subject, b.Subject) >= threshold &&
+		textSimilarity(a.Text, b.Text) >= threshold
+}
+
+// findDuplicate returns an existing record that is the same in-game text as
+// record or nil if there is none.
+func (l *LaCodex) findDuplicate(record *model.Record) (*model.Record, error) {
+	var candidates []*model.Record
+	err := l.records.Find("Type", record.Type, &candidates)
+	if err == storm.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range candidates {
+		if c.Id != record.Id && l.isDuplicate(c, record) {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+// mergeRecord points everything that refers to dup at record and deletes dup.
+func (l *LaCodex) mergeRecord(record *model.Record, dup *model.Record) error {
+	metas, err := l.idb.ImagesForRecord(dup.Id)
+	if err != nil {
+		return err
+	}
+	for _, meta := range metas {
+		err = l.idb.LinkRecord(meta.FileName, record.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Keep dup's corrections if record doesn't have any of its own.
+	var c model.RecordCorrection
+	err = l.corrections.One("Id", dup.Id, &c)
+	if err == nil {
+		var existing model.RecordCorrection
+		if l.corrections.One("Id", record.Id, &existing) == storm.ErrNotFound {
+			moved := c
+			moved.Id = record.Id
+			err = l.corrections.Save(&moved)
+			if err != nil {
+				return err
+			}
+			if corrected := moved.Apply(record); corrected != nil {
+				l.search.Update(corrected)
+			} else {
+				l.search.Remove(record.Id)
+			}
+		}
+		err = l.corrections.DeleteStruct(&c)
+	}
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	err = l.moveTranslations(dup, record)
+	if err != nil {
+		return err
+	}
+
+	l.search.Remove(dup.Id)
+	return l.records.DeleteStruct(dup)
+}
+
+// MergeDuplicates merges records that are the same in-game text.  The record
+// with the lowest Id is kept.  Returns the number of records removed.
+func (l *LaCodex) MergeDuplicates() (int, error) {
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	var records []*model.Record
+	err := l.records.All(&records)
+	if err != nil {
+		return 0, err
+	}
+
+	merged := 0
+	var kept []*model.Record
+L:
+	for _, record := range records {
+		for _, k := range kept {
+			if l.isDuplicate(k, record) {
+				glog.Infof("merging record %d into %d", record.Id, k.Id)
+				err = l.mergeRecord(k, record)
+				if err != nil {
+					return merged, err
+				}
+				merged++
+				continue L
+			}
+		}
+		kept = append(kept, record)
+	}
+
+	if merged > 0 {
+		l.publishUpdate()
+	}
+	return merged, nil
+}
+
+// dedupHandler serves POST /admin/dedup
+func (l *LaCodex) dedupHandler(w http.ResponseWriter, r *http.Request) {
+	merged, err := l.MergeDuplicates()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't merge records: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"merged": merged})
+}
@@ -0,0 +1,106 @@
+package lacodex
+
+import (
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeText(t *testing.T) {
+	assert.Equal(t, "offer 3 lights to the heavens ok i",
+		normalizeText("  Offer 3 lights to the heavens.\nOK\ni"))
+	assert.Equal(t, "", normalizeText(" .\n"))
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein([]rune("ankh"), []rune("ankh")))
+	assert.Equal(t, 3, levenshtein([]rune("kitten"), []rune("sitting")))
+	assert.Equal(t, 4, levenshtein([]rune(""), []rune("ankh")))
+	assert.Equal(t, 4, levenshtein([]rune("ankh"), []rune("")))
+}
+
+func TestTextSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, textSimilarity("", ""))
+	assert.Equal(t, 1.0, textSimilarity("Ankh Jewel.", "ankh\njewel"))
+	assert.InDelta(t, 0.9, textSimilarity("ankh jewel", "ankh jewe1"), 1e-9)
+	assert.InDelta(t, 4.0/15.0, textSimilarity("ankh", "ankh jewel ankh"), 1e-9)
+}
+
+func TestIsDuplicate(t *testing.T) {
+	l := &LaCodex{config: &Config{}}
+	one := 1
+	two := 2
+
+	base := &model.Record{
+		Type: model.RecordTypeMailer,
+		Text: "There are 8 Ankhs. 8 Ankhs that protect the great spirits.",
+	}
+	tests := []struct {
+		record *model.Record
+		dup    bool
+	}{
+		{&model.Record{Type: model.RecordTypeMailer, Text: "There are 8 Ankhs.\n8 Ankhs that protect the great spirits."}, true},
+		{&model.Record{Type: model.RecordTypeMailer, Text: "There are 8 Ankhs. 8 Ankhs that protcet the great spirlts."}, true},
+		{&model.Record{Type: model.RecordTypeScanner, Text: base.Text}, false},
+		{&model.Record{Type: model.RecordTypeMailer, Text: base.Text, Index: &one}, false},
+		{&model.Record{Type: model.RecordTypeMailer, Text: base.Text, Subject: "Ankhs"}, false},
+		{&model.Record{Type: model.RecordTypeMailer, Text: "There are 8 Ankhs."}, false},
+		{&model.Record{Type: model.RecordTypeMailer, Text: base.Text, Language: model.LanguageEnglish}, true},
+		{&model.Record{Type: model.RecordTypeMailer, Text: base.Text, Language: model.LanguageJapanese}, false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.dup, l.isDuplicate(base, test.record), test.record.Text)
+	}
+
+	indexed := *base
+	indexed.Index = &one
+	other := *base
+	other.Index = &two
+	assert.False(t, l.isDuplicate(&indexed, &other))
+	other.Index = &one
+	assert.True(t, l.isDuplicate(&indexed, &other))
+
+	// Thresholds above 1.0 disable fuzzy matching.
+	l.config.DedupThreshold = 1.01
+	assert.False(t, l.isDuplicate(base, tests[0].record))
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134145_1.png")
+
+	// Simulate a database from before records were deduplicated on upload.
+	dup := &model.Record{
+		Type:       model.RecordTypeScanner,
+		Text:       "Offer 3 1ights to the heavens.\nOK\ni",
+		Keyphrases: map[model.KeyphraseType][]string{},
+	}
+	err := tlc.l.records.Save(dup)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dup.Id)
+	err = tlc.l.idb.LinkRecord("230700_20190519134145_1.png", dup.Id)
+	assert.NoError(t, err)
+	err = tlc.l.corrections.Save(&model.RecordCorrection{Id: dup.Id, Subject: &dup.Text})
+	assert.NoError(t, err)
+
+	merged, err := tlc.l.MergeDuplicates()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, merged)
+
+	imgs := tlc.GetImages(t)
+	assert.Equal(t, 1, imgs[0].Record)
+	assert.Equal(t, 1, imgs[1].Record)
+
+	// The duplicate's correction moved to the kept record.
+	recs := tlc.GetRecords(t)
+	assert.Len(t, recs, 1)
+	assert.Equal(t, 1, recs[0].Id)
+	assert.Equal(t, dup.Text, recs[0].Subject)
+
+	merged, err = tlc.l.MergeDuplicates()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, merged)
+}
@@ -0,0 +1,64 @@
+package lacodex
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/konkers/lacodex/export"
+)
+
+// Export writes all records to w in format.  Image links are prefixed with
+// baseURL.
+func (l *LaCodex) Export(w io.Writer, format export.Format, baseURL string) error {
+	records, err := l.allRecords()
+	if err != nil {
+		return err
+	}
+	metas, err := l.idb.ListImages()
+	if err != nil {
+		return err
+	}
+
+	// Link each record to the first screenshot of it.
+	images := map[int]string{}
+	for _, meta := range metas {
+		if _, ok := images[meta.Record]; !ok {
+			images[meta.Record] = meta.Hash
+		}
+	}
+
+	entries := make([]*export.Entry, len(records))
+	for i, record := range records {
+		entries[i] = &export.Entry{
+			Record: withoutLines(record),
+			Image:  images[record.Id],
+		}
+	}
+	return export.Write(w, format, entries, &export.Options{BaseURL: baseURL})
+}
+
+// exportHandler serves GET /export[?format=md|html|csv|json]
+//
+// The format defaults to html.
+func (l *LaCodex) exportHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = string(export.FormatHTML)
+	}
+	format, err := export.ParseFormat(name)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	err = l.Export(&buf, format, "")
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't export records: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	buf.WriteTo(w)
+}
@@ -0,0 +1,48 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/konkers/lacodex/model"
+)
+
+var csvHeader = []string{
+	"id", "type", "language", "index", "subject", "text", "blue", "green", "image",
+}
+
+func writeCSV(w io.Writer, groups []*Group, opts *Options) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		for _, e := range g.Entries {
+			index := ""
+			if e.Index != nil {
+				index = strconv.Itoa(*e.Index)
+			}
+			err = cw.Write([]string{
+				strconv.Itoa(e.Id),
+				g.Name(),
+				string(e.Language.OrDefault()),
+				index,
+				e.Subject,
+				e.Text,
+				strings.Join(e.Keyphrases[model.KeyphraseTypeBlue], "; "),
+				strings.Join(e.Keyphrases[model.KeyphraseTypeGreen], "; "),
+				opts.imageURL(e.Image),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
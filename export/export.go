@@ -0,0 +1,294 @@
+// Package export renders records for sharing outside of the codex.
+package export
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// Format is an export file format.
+type Format string
+
+const (
+	FormatMarkdown Format = "md"
+	FormatHTML     Format = "html"
+	FormatCSV      Format = "csv"
+	FormatJSON     Format = "json"
+)
+
+// ParseFormat returns the Format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatMarkdown, FormatHTML, FormatCSV, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("Unknown export format %s", s)
+}
+
+// ContentType returns the MIME type of f.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// Entry is a record to export.
+type Entry struct {
+	*model.Record
+
+	// Image is the hash of a screenshot of the record.  Empty if there is
+	// none.
+	Image string
+}
+
+// Options control how records are exported.
+type Options struct {
+	// BaseURL is prepended to image links.  e.g. http://localhost:8080 to
+	// link to a running codex.  Links are relative to the root if empty.
+	BaseURL string
+}
+
+func (o *Options) imageURL(hash string) string {
+	if hash == "" {
+		return ""
+	}
+	return strings.TrimSuffix(o.BaseURL, "/") + "/image/" + hash
+}
+
+// thumbnailURL returns a link to the text area of an image, which is
+// smaller than the whole screenshot.
+func (o *Options) thumbnailURL(hash string) string {
+	if hash == "" {
+		return ""
+	}
+	return o.imageURL(hash) + "?crop=content"
+}
+
+// Group is the entries of a single RecordType.
+type Group struct {
+	Type    model.RecordType
+	Entries []*Entry
+}
+
+var groupTitles = map[model.RecordType]string{
+	model.RecordTypeScanner: "Tablets",
+	model.RecordTypeMailer:  "Mail",
+	model.RecordTypeTent:    "Tent",
+	model.RecordTypeUnknown: "Unknown",
+}
+
+// Name returns the name the group's RecordType is stored as.
+func (g *Group) Name() string {
+	name, err := g.Type.MarshalText()
+	if err != nil {
+		return fmt.Sprintf("%d", g.Type)
+	}
+	return string(name)
+}
+
+// Title returns a heading for the group.
+func (g *Group) Title() string {
+	if title, ok := groupTitles[g.Type]; ok {
+		return title
+	}
+	return strings.Title(g.Name())
+}
+
+// entryLess orders records with an index, like mail, by it and the rest in
+// the order they were ingested.
+func entryLess(a, b *Entry) bool {
+	if (a.Index == nil) != (b.Index == nil) {
+		return a.Index != nil
+	}
+	if a.Index != nil && *a.Index != *b.Index {
+		return *a.Index < *b.Index
+	}
+	if a.Language.OrDefault() != b.Language.OrDefault() {
+		return a.Language.OrDefault() < b.Language.OrDefault()
+	}
+	return a.Id < b.Id
+}
+
+// GroupEntries groups entries by RecordType.  Unknown records go last.
+func GroupEntries(entries []*Entry) []*Group {
+	groups := map[model.RecordType]*Group{}
+	for _, e := range entries {
+		g, ok := groups[e.Type]
+		if !ok {
+			g = &Group{Type: e.Type}
+			groups[e.Type] = g
+		}
+		g.Entries = append(g.Entries, e)
+	}
+
+	sorted := []*Group{}
+	for _, g := range groups {
+		sort.SliceStable(g.Entries, func(i, j int) bool {
+			return entryLess(g.Entries[i], g.Entries[j])
+		})
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].Type, sorted[j].Type
+		if (a == model.RecordTypeUnknown) != (b == model.RecordTypeUnknown) {
+			return b == model.RecordTypeUnknown
+		}
+		return a < b
+	})
+	return sorted
+}
+
+// Title returns a heading for the record.
+func (e *Entry) Title() string {
+	title := e.Subject
+	if e.Index != nil {
+		title = fmt.Sprintf("No. %d", *e.Index)
+		if e.Subject != "" {
+			title += ": " + e.Subject
+		}
+	}
+	if title == "" {
+		title = fmt.Sprintf("Record %d", e.Id)
+	}
+	if lang := e.Language.OrDefault(); lang != model.LanguageEnglish {
+		title += fmt.Sprintf(" [%s]", lang)
+	}
+	return title
+}
+
+// Segment is a run of record text that is either plain or a keyphrase.
+type Segment struct {
+	Text      string
+	Keyphrase model.KeyphraseType
+}
+
+type keyphrase struct {
+	re   *regexp.Regexp
+	t    model.KeyphraseType
+	text string
+}
+
+// isWordRune returns true if r is part of a word.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isWordBoundary returns true if a and b, next to each other in text, aren't
+// in the same word.  Scripts without spaces have no visible word boundaries
+// so any point in them counts.
+func isWordBoundary(a, b rune) bool {
+	return !isWordRune(a) || !isWordRune(b) ||
+		model.IsUnspacedScript(a) || model.IsUnspacedScript(b)
+}
+
+// Highlight splits text into plain text and keyphrases.  Keyphrases may be
+// split across lines in text.  Longer keyphrases win where they overlap.
+func Highlight(text string, keyphrases map[model.KeyphraseType][]string) []Segment {
+	var phrases []keyphrase
+	for t, ps := range keyphrases {
+		if t == model.KeyphraseTypeNone {
+			continue
+		}
+		for _, p := range ps {
+			fields := strings.Fields(p)
+			if len(fields) == 0 {
+				continue
+			}
+			for i, f := range fields {
+				fields[i] = regexp.QuoteMeta(f)
+			}
+			re := regexp.MustCompile(strings.Join(fields, `\s+`))
+			phrases = append(phrases, keyphrase{re, t, p})
+		}
+	}
+	sort.Slice(phrases, func(i, j int) bool {
+		a, b := phrases[i], phrases[j]
+		if len(a.text) != len(b.text) {
+			return len(a.text) > len(b.text)
+		}
+		if a.t != b.t {
+			return a.t < b.t
+		}
+		return a.text < b.text
+	})
+
+	marks := make([]model.KeyphraseType, len(text))
+	for _, p := range phrases {
+	L:
+		for _, loc := range p.re.FindAllStringIndex(text, -1) {
+			// Only whole words.
+			if loc[0] > 0 {
+				before, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+				first, _ := utf8.DecodeRuneInString(text[loc[0]:])
+				if !isWordBoundary(before, first) {
+					continue
+				}
+			}
+			if loc[1] < len(text) {
+				last, _ := utf8.DecodeLastRuneInString(text[:loc[1]])
+				after, _ := utf8.DecodeRuneInString(text[loc[1]:])
+				if !isWordBoundary(last, after) {
+					continue
+				}
+			}
+			for i := loc[0]; i < loc[1]; i++ {
+				if marks[i] != model.KeyphraseTypeNone {
+					continue L
+				}
+			}
+			for i := loc[0]; i < loc[1]; i++ {
+				marks[i] = p.t
+			}
+		}
+	}
+
+	var segments []Segment
+	start := 0
+	for i := 1; i <= len(text); i++ {
+		if i == len(text) || marks[i] != marks[start] {
+			segments = append(segments, Segment{Text: text[start:i], Keyphrase: marks[start]})
+			start = i
+		}
+	}
+	return segments
+}
+
+// cssColor returns the in-game color of keyphrases of type t as a CSS hex
+// color.
+func cssColor(t model.KeyphraseType) string {
+	c := t.Color()
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Write exports entries to w in format.
+func Write(w io.Writer, format Format, entries []*Entry, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	groups := GroupEntries(entries)
+
+	switch format {
+	case FormatMarkdown:
+		return writeMarkdown(w, groups, opts)
+	case FormatHTML:
+		return writeHTML(w, groups, opts)
+	case FormatCSV:
+		return writeCSV(w, groups, opts)
+	case FormatJSON:
+		return writeJSON(w, groups, opts)
+	}
+	return fmt.Errorf("Unknown export format %s", format)
+}
@@ -0,0 +1,160 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormat(t *testing.T) {
+	for _, name := range []string{"md", "html", "csv", "json"} {
+		f, err := ParseFormat(name)
+		assert.NoError(t, err)
+		assert.Equal(t, Format(name), f)
+	}
+	_, err := ParseFormat("pdf")
+	assert.EqualError(t, err, "Unknown export format pdf")
+}
+
+func TestHighlight(t *testing.T) {
+	keyphrases := map[model.KeyphraseType][]string{
+		model.KeyphraseTypeBlue:  {"Eye of Truth", "Eye"},
+		model.KeyphraseTypeGreen: {"heavens"},
+	}
+	assert.Equal(t, []Segment{
+		{"Use the ", model.KeyphraseTypeNone},
+		{"Eye of\nTruth", model.KeyphraseTypeBlue},
+		{" or an ", model.KeyphraseTypeNone},
+		{"Eye", model.KeyphraseTypeBlue},
+		{" to see the ", model.KeyphraseTypeNone},
+		{"heavens", model.KeyphraseTypeGreen},
+		{". Eyes", model.KeyphraseTypeNone},
+	}, Highlight("Use the Eye of\nTruth or an Eye to see the heavens. Eyes", keyphrases))
+
+	assert.Equal(t, []Segment{{"heavens", model.KeyphraseTypeGreen}},
+		Highlight("heavens", keyphrases))
+	assert.Empty(t, Highlight("", keyphrases))
+	assert.Equal(t, []Segment{{"Eye", model.KeyphraseTypeNone}}, Highlight("Eye", nil))
+
+	// Japanese has no spaces between words.
+	assert.Equal(t, []Segment{
+		{"赤い", model.KeyphraseTypeNone},
+		{"アンクジュエル", model.KeyphraseTypeGreen},
+		{"。", model.KeyphraseTypeNone},
+	}, Highlight("赤いアンクジュエル。", map[model.KeyphraseType][]string{
+		model.KeyphraseTypeGreen: {"アンクジュエル"},
+	}))
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func testEntries() []*Entry {
+	return []*Entry{
+		{Record: &model.Record{Id: 1, Type: model.RecordTypeUnknown, Text: "???"}},
+		{Record: &model.Record{Id: 2, Type: model.RecordTypeMailer, Index: intPtr(12),
+			Subject: "Sale", Text: "Buy *now*"}, Image: "sha256-2"},
+		{Record: &model.Record{Id: 3, Type: model.RecordTypeScanner,
+			Text:       "Offer 3 lights to the heavens.",
+			Keyphrases: map[model.KeyphraseType][]string{model.KeyphraseTypeBlue: {"heavens"}},
+		}, Image: "sha256-3"},
+		{Record: &model.Record{Id: 4, Type: model.RecordTypeMailer, Index: intPtr(3), Text: "Hello"}},
+		{Record: &model.Record{Id: 5, Type: model.RecordTypeMailer, Index: intPtr(3), Text: "こんにちは",
+			Language: model.LanguageJapanese}},
+	}
+}
+
+func TestGroupEntries(t *testing.T) {
+	groups := GroupEntries(testEntries())
+	var titles []string
+	var ids [][]int
+	for _, g := range groups {
+		titles = append(titles, g.Title())
+		var groupIds []int
+		for _, e := range g.Entries {
+			groupIds = append(groupIds, e.Id)
+		}
+		ids = append(ids, groupIds)
+	}
+	assert.Equal(t, []string{"Mail", "Tablets", "Unknown"}, titles)
+	assert.Equal(t, [][]int{{4, 5, 2}, {3}, {1}}, ids)
+
+	assert.Equal(t, "No. 12: Sale", groups[0].Entries[2].Title())
+	assert.Equal(t, "No. 3 [ja]", groups[0].Entries[1].Title())
+	assert.Equal(t, "Record 3", groups[1].Entries[0].Title())
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, FormatMarkdown, testEntries(), &Options{BaseURL: "http://codex/"})
+	assert.NoError(t, err)
+	md := buf.String()
+
+	assert.True(t, strings.HasPrefix(md, "# La-Mulana Codex\n\n## Mail\n\n### No. 3\n\nHello\n"), md)
+	assert.Contains(t, md, "### No. 12: Sale\n\nBuy \\*now\\*\n\n"+
+		"[![Screenshot](http://codex/image/sha256-2?crop=content)](http://codex/image/sha256-2)\n")
+	assert.Contains(t, md, `Offer 3 lights to the <span style="color: #64b6e3">**heavens**</span>.`)
+	assert.Contains(t, md, "## Unknown\n\n### Record 1\n\n???\n")
+}
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, FormatHTML, testEntries(), nil)
+	assert.NoError(t, err)
+	html := buf.String()
+
+	assert.Contains(t, html, ".blue { color: #64b6e3; }")
+	assert.Contains(t, html, ".green { color: #60e593; }")
+	assert.Contains(t, html, `<h2 id="mailer">Mail</h2>`)
+	assert.Contains(t, html, `<div class="record" id="record-5" lang="ja">`)
+	assert.Contains(t, html,
+		`<p class="text">Offer 3 lights to the <span class="blue">heavens</span>.</p>`)
+	assert.Contains(t, html, `<a class="screenshot" href="/image/sha256-3">`+
+		`<img src="/image/sha256-3?crop=content" alt="Screenshot"></a>`)
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, FormatCSV, testEntries(), nil)
+	assert.NoError(t, err)
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 6) {
+		assert.Equal(t, csvHeader, rows[0])
+		assert.Equal(t, []string{"2", "mailer", "en", "12", "Sale", "Buy *now*", "", "", "/image/sha256-2"}, rows[3])
+		assert.Equal(t, []string{"3", "scanner", "en", "", "", "Offer 3 lights to the heavens.", "heavens", "",
+			"/image/sha256-3"}, rows[4])
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, FormatJSON, testEntries(), nil)
+	assert.NoError(t, err)
+
+	var groups []struct {
+		Type    model.RecordType `json:"type"`
+		Title   string           `json:"title"`
+		Records []struct {
+			Id        int    `json:"id"`
+			Image     string `json:"image"`
+			Thumbnail string `json:"thumbnail"`
+		} `json:"records"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &groups)
+	assert.NoError(t, err)
+	if assert.Len(t, groups, 3) {
+		assert.Equal(t, model.RecordTypeMailer, groups[0].Type)
+		assert.Equal(t, "Tablets", groups[1].Title)
+		assert.Equal(t, 3, groups[1].Records[0].Id)
+		assert.Equal(t, "/image/sha256-3", groups[1].Records[0].Image)
+		assert.Equal(t, "/image/sha256-3?crop=content", groups[1].Records[0].Thumbnail)
+	}
+}
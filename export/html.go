@@ -0,0 +1,82 @@
+package export
+
+import (
+	"html/template"
+	"io"
+
+	"github.com/konkers/lacodex/model"
+)
+
+var htmlTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"highlight": Highlight,
+	"class": func(t model.KeyphraseType) string {
+		if t == model.KeyphraseTypeNone {
+			return ""
+		}
+		name, _ := t.MarshalText()
+		return string(name)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>La-Mulana Codex</title>
+<style>
+body { background: #000; color: {{.Normal}}; font-family: sans-serif; }
+a { color: inherit; }
+.text { white-space: pre-wrap; }
+.blue { color: {{.Blue}}; }
+.green { color: {{.Green}}; }
+.screenshot img { max-width: 302px; border: 1px solid {{.Normal}}; }
+</style>
+</head>
+<body>
+<h1>La-Mulana Codex</h1>
+{{- range .Groups}}
+<h2 id="{{.Name}}">{{.Title}}</h2>
+{{- range .Entries}}
+<div class="record" id="record-{{.Id}}" lang="{{.Language.OrDefault}}">
+<h3>{{.Title}}</h3>
+<p class="text">
+{{- range highlight .Text .Keyphrases}}
+{{- with class .Keyphrase}}<span class="{{.}}">{{end}}{{.Text}}{{if class .Keyphrase}}</span>{{end}}
+{{- end -}}
+</p>
+{{- if .Image}}
+<a class="screenshot" href="{{$.ImageURL .Image}}"><img src="{{$.ThumbnailURL .Image}}" alt="Screenshot"></a>
+{{- end}}
+</div>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+type htmlData struct {
+	Groups []*Group
+	opts   *Options
+}
+
+func (d *htmlData) Normal() template.CSS {
+	return template.CSS(cssColor(model.KeyphraseTypeNone))
+}
+
+func (d *htmlData) Blue() template.CSS {
+	return template.CSS(cssColor(model.KeyphraseTypeBlue))
+}
+
+func (d *htmlData) Green() template.CSS {
+	return template.CSS(cssColor(model.KeyphraseTypeGreen))
+}
+
+func (d *htmlData) ImageURL(hash string) string {
+	return d.opts.imageURL(hash)
+}
+
+func (d *htmlData) ThumbnailURL(hash string) string {
+	return d.opts.thumbnailURL(hash)
+}
+
+func writeHTML(w io.Writer, groups []*Group, opts *Options) error {
+	return htmlTemplate.Execute(w, &htmlData{Groups: groups, opts: opts})
+}
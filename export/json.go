@@ -0,0 +1,36 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/konkers/lacodex/model"
+)
+
+type jsonEntry struct {
+	*model.Record
+	Image     string `json:"image,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+type jsonGroup struct {
+	Type    model.RecordType `json:"type"`
+	Title   string           `json:"title"`
+	Records []*jsonEntry     `json:"records"`
+}
+
+func writeJSON(w io.Writer, groups []*Group, opts *Options) error {
+	out := []*jsonGroup{}
+	for _, g := range groups {
+		jg := &jsonGroup{Type: g.Type, Title: g.Title(), Records: []*jsonEntry{}}
+		for _, e := range g.Entries {
+			jg.Records = append(jg.Records, &jsonEntry{
+				Record:    e.Record,
+				Image:     opts.imageURL(e.Image),
+				Thumbnail: opts.thumbnailURL(e.Image),
+			})
+		}
+		out = append(out, jg)
+	}
+	return json.NewEncoder(w).Encode(out)
+}
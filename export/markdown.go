@@ -0,0 +1,66 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/konkers/lacodex/model"
+)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `#`, `\#`, `|`, `\|`,
+)
+
+// markdownEscape escapes s so that it isn't interpreted as markdown.
+func markdownEscape(s string) string {
+	s = markdownEscaper.Replace(s)
+	// List markers are only special at the start of a line.
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		s = `\` + s
+	}
+	return s
+}
+
+// markdownText renders record text with keyphrases in bold and in their
+// in-game colors.  Lines end with hard line breaks.
+func markdownText(text string, keyphrases map[model.KeyphraseType][]string) string {
+	var b strings.Builder
+	for _, seg := range Highlight(text, keyphrases) {
+		lines := strings.Split(seg.Text, "\n")
+		for i, line := range lines {
+			if i > 0 {
+				b.WriteString("  \n")
+			}
+			if line == "" {
+				continue
+			}
+			if seg.Keyphrase == model.KeyphraseTypeNone {
+				b.WriteString(markdownEscape(line))
+			} else {
+				fmt.Fprintf(&b, `<span style="color: %s">**%s**</span>`,
+					cssColor(seg.Keyphrase), markdownEscape(line))
+			}
+		}
+	}
+	return b.String()
+}
+
+func writeMarkdown(w io.Writer, groups []*Group, opts *Options) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# La-Mulana Codex\n")
+	for _, g := range groups {
+		fmt.Fprintf(bw, "\n## %s\n", markdownEscape(g.Title()))
+		for _, e := range g.Entries {
+			fmt.Fprintf(bw, "\n### %s\n\n", markdownEscape(e.Title()))
+			fmt.Fprintf(bw, "%s\n", markdownText(e.Text, e.Keyphrases))
+			if e.Image != "" {
+				fmt.Fprintf(bw, "\n[![Screenshot](%s)](%s)\n",
+					opts.thumbnailURL(e.Image), opts.imageURL(e.Image))
+			}
+		}
+	}
+	return bw.Flush()
+}
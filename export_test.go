@@ -0,0 +1,42 @@
+package lacodex
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportHandler(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	base := fmt.Sprintf("http://%s/export", tlc.l.config.ListenAddr)
+
+	resp, err := http.Get(base + "?format=csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+	rows, err := csv.NewReader(resp.Body).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, []string{"1", "scanner", "en"}, rows[1][:3])
+		assert.Equal(t, "/image/"+testImageHash, rows[1][8])
+	}
+
+	html := testGet(t, base)
+	assert.True(t, strings.HasPrefix(html, "<!DOCTYPE html>"))
+	assert.Contains(t, html, `<img src="/image/`+testImageHash+`?crop=content"`)
+
+	md := testGet(t, base+"?format=md")
+	assert.Contains(t, md, "## Tablets\n")
+
+	testBadGet(t, base+"?format=pdf")
+}
@@ -0,0 +1,190 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/asdine/storm"
+	"github.com/go-zoo/bone"
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/imageutil"
+	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/model"
+)
+
+// RetryResult is the result of retrying ingestion of an image.
+type RetryResult struct {
+	// Record is the record the image is now linked to.  0 if ingestion
+	// failed again.
+	Record int `json:"record"`
+
+	Failure *model.IngestFailure `json:"failure,omitempty"`
+}
+
+func newIngestFailure(meta *model.ImageMetadata, lang model.Language, err error) *model.IngestFailure {
+	f := &model.IngestFailure{
+		ImageId:  meta.Id,
+		FileName: meta.FileName,
+		Hash:     meta.Hash,
+		Language: lang,
+		Type:     model.RecordTypeUnknown,
+		Stage:    "ingest",
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+	}
+
+	if ingestErr, ok := err.(*ingest.IngestError); ok {
+		f.Stage = ingestErr.Stage
+		f.Error = ingestErr.Err.Error()
+		c := ingestErr.Classification
+		if c != nil && len(c.Scores) > 0 {
+			f.Type = c.Scores[0].Type
+			f.Confidence = c.Scores[0].Score
+		}
+	}
+	return f
+}
+
+// saveFailure records why the image meta couldn't be ingested.
+func (l *LaCodex) saveFailure(meta *model.ImageMetadata, lang model.Language, err error) error {
+	return l.failures.Save(newIngestFailure(meta, lang, err))
+}
+
+// failureLanguage returns the language an image was ingested as when it
+// failed.  Falls back to the configured language for failures recorded
+// before languages were.
+func (l *LaCodex) failureLanguage(imageId int) (model.Language, error) {
+	var f model.IngestFailure
+	err := l.failures.One("ImageId", imageId, &f)
+	if err != nil && err != storm.ErrNotFound {
+		return "", err
+	}
+	if f.Language == "" {
+		return l.config.Language.OrDefault(), nil
+	}
+	return f.Language, nil
+}
+
+// clearFailure removes the failure for an image if it has one.
+func (l *LaCodex) clearFailure(imageId int) error {
+	err := l.failures.DeleteStruct(&model.IngestFailure{ImageId: imageId})
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (l *LaCodex) listFailures(w io.Writer) error {
+	failures := []*model.IngestFailure{}
+	err := l.failures.All(&failures)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(failures)
+}
+
+// failedImages returns the images with hash that failed ingestion.
+func (l *LaCodex) failedImages(hash string) ([]*model.ImageMetadata, error) {
+	metas, err := l.idb.ImagesWithHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	var failed []*model.ImageMetadata
+	for _, meta := range metas {
+		if meta.Record == 0 {
+			failed = append(failed, meta)
+		}
+	}
+	return failed, nil
+}
+
+// Retry re-runs ingestion on the failed images with hash.  Returns
+// storm.ErrNotFound if there are no failed images with hash.
+func (l *LaCodex) Retry(hash string) (*RetryResult, error) {
+	failed, err := l.failedImages(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) == 0 {
+		return nil, storm.ErrNotFound
+	}
+
+	img, err := l.idb.GetImage(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	lang, err := l.failureLanguage(failed[0].Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// OCR is slow so it is done before taking recordMutex.
+	record, ingestErr := ingest.IngestGameImage(imageutil.AsRGBA(img), lang)
+
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	// The images may have been retried or removed while we were ingesting.
+	failed, err = l.failedImages(hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(failed) == 0 {
+		return nil, storm.ErrNotFound
+	}
+
+	if ingestErr != nil {
+		glog.Infof("retry of %s failed: %v", hash, ingestErr)
+		result := &RetryResult{}
+		for _, meta := range failed {
+			f := newIngestFailure(meta, lang, ingestErr)
+			err = l.failures.Save(f)
+			if err != nil {
+				return nil, err
+			}
+			if result.Failure == nil {
+				result.Failure = f
+			}
+		}
+		return result, nil
+	}
+
+	record, err = l.saveNewRecord(record, failed[0].FileName)
+	if err != nil {
+		return nil, err
+	}
+	for _, meta := range failed {
+		err = l.idb.LinkRecord(meta.FileName, record.Id)
+		if err != nil {
+			return nil, err
+		}
+		err = l.clearFailure(meta.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.publishUpdate()
+
+	return &RetryResult{Record: record.Id}, nil
+}
+
+// retryHandler serves POST /image/:hash/retry
+func (l *LaCodex) retryHandler(w http.ResponseWriter, r *http.Request) {
+	hash := bone.GetValue(r, "hash")
+
+	result, err := l.Retry(hash)
+	if err == storm.ErrNotFound {
+		httpError(w, http.StatusNotFound, "No failed images with hash %s", hash)
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't retry %s: %v", hash, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,131 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const mapImageHash = "sha256-c1d85db281056ffb2f43214219dafba0aba67032d7b05fae393d4c1d0f22fe59"
+
+func TestNewIngestFailure(t *testing.T) {
+	meta := &model.ImageMetadata{
+		Id:       3,
+		Hash:     "sha256-1234",
+		FileName: "230700_20190517185334_1.png",
+	}
+
+	f := newIngestFailure(meta, model.LanguageJapanese, &ingest.IngestError{
+		Stage: ingest.StageOCR,
+		Classification: &ingest.Classification{
+			Scores: []ingest.ClassifyScore{
+				{Type: model.RecordTypeMailer, Score: 0.95, Threshold: 0.9},
+				{Type: model.RecordTypeTent, Score: 0.5, Threshold: 0.9},
+			},
+		},
+		Err: errors.New("bad index"),
+	})
+	assert.Equal(t, 3, f.ImageId)
+	assert.Equal(t, "sha256-1234", f.Hash)
+	assert.Equal(t, "230700_20190517185334_1.png", f.FileName)
+	assert.Equal(t, ingest.StageOCR, f.Stage)
+	assert.Equal(t, "bad index", f.Error)
+	assert.Equal(t, model.RecordTypeMailer, f.Type)
+	assert.Equal(t, 0.95, f.Confidence)
+	assert.Equal(t, model.LanguageJapanese, f.Language)
+	assert.False(t, f.FailedAt.IsZero())
+
+	f = newIngestFailure(meta, "", errors.New("oops"))
+	assert.Equal(t, "ingest", f.Stage)
+	assert.Equal(t, "oops", f.Error)
+	assert.Equal(t, model.RecordTypeUnknown, f.Type)
+	assert.Equal(t, 0.0, f.Confidence)
+}
+
+func (tlc *testLC) GetFailures(t *testing.T) []*model.IngestFailure {
+	url := fmt.Sprintf("http://%s/image/failed", tlc.l.config.ListenAddr)
+	r := testGet(t, url)
+	var failures []*model.IngestFailure
+	err := json.Unmarshal([]byte(r), &failures)
+	assert.NoError(t, err, "Can't decode json: %s", r)
+	return failures
+}
+
+func (tlc *testLC) Retry(t *testing.T, hash string) (int, *RetryResult) {
+	url := fmt.Sprintf("http://%s/image/%s/retry", tlc.l.config.ListenAddr, hash)
+	resp, err := http.Post(url, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	var result RetryResult
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	assert.NoError(t, err)
+	return resp.StatusCode, &result
+}
+
+func TestFailures(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	assert.Empty(t, tlc.GetFailures(t))
+
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	failures := tlc.GetFailures(t)
+	if assert.Len(t, failures, 1) {
+		f := failures[0]
+		assert.Equal(t, 1, f.ImageId)
+		assert.Equal(t, "230700_20190517185334_1.png", f.FileName)
+		assert.Equal(t, mapImageHash, f.Hash)
+		assert.Equal(t, ingest.StageClassify, f.Stage)
+		assert.NotEmpty(t, f.Error)
+		assert.True(t, f.Confidence > 0.0 && f.Confidence < 0.9)
+	}
+
+	status, result := tlc.Retry(t, mapImageHash)
+	assert.Equal(t, http.StatusOK, status)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, 0, result.Record)
+		assert.NotNil(t, result.Failure)
+	}
+	assert.Len(t, tlc.GetFailures(t), 1)
+
+	// Images that were ingested can't be retried.
+	status, _ = tlc.Retry(t, "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = tlc.Retry(t, "sha256-0")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestRetry(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	// Simulate an image that failed with an older version of ingest.
+	err := tlc.l.idb.LinkRecord("230700_20190519134140_1.png", 0)
+	assert.NoError(t, err)
+	meta, err := tlc.l.idb.LookupFile("230700_20190519134140_1.png")
+	assert.NoError(t, err)
+	err = tlc.l.saveFailure(meta, "", errors.New("old failure"))
+	assert.NoError(t, err)
+	assert.Len(t, tlc.GetFailures(t), 1)
+
+	status, result := tlc.Retry(t, meta.Hash)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, &RetryResult{Record: 1}, result)
+
+	assert.Empty(t, tlc.GetFailures(t))
+	assert.Equal(t, 1, tlc.GetImages(t)[0].Record)
+}
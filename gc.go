@@ -0,0 +1,254 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/asdine/storm"
+	"github.com/go-zoo/bone"
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/model"
+	"github.com/konkers/lacodex/search"
+)
+
+// GCReport is the result of GC.
+type GCReport struct {
+	// Blobs and Bytes are the number and size of stored images removed.
+	Blobs int   `json:"blobs"`
+	Bytes int64 `json:"bytes"`
+
+	// Records is the number of records removed.
+	Records int `json:"records"`
+
+	// Failures is the number of ingestion failures removed.
+	Failures int `json:"failures"`
+}
+
+// syncSearch brings the search index up to date with the records ids after
+// they were changed in a transaction.
+func (l *LaCodex) syncSearch(ids []int) error {
+	for _, id := range ids {
+		record, err := l.getRecord(id)
+		if err == storm.ErrNotFound {
+			l.search.Remove(id)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		l.search.Update(record)
+	}
+	return nil
+}
+
+// deleteRecord removes a record along with its correction and translation
+// links.  Must be called with recordMutex held.
+func (l *LaCodex) deleteRecord(record *model.Record) error {
+	for _, id := range record.Translations {
+		var t model.Record
+		err := l.records.One("Id", id, &t)
+		if err == storm.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		t.Translations = removeId(t.Translations, record.Id)
+		err = l.saveIngestedRecord(&t)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := l.corrections.DeleteStruct(&model.RecordCorrection{Id: record.Id})
+	if err != nil && err != storm.ErrNotFound {
+		return err
+	}
+
+	l.search.Remove(record.Id)
+	return l.records.DeleteStruct(record)
+}
+
+// DeleteImage removes the image fileName along with its failure, if it
+// couldn't be ingested, and its record if no other image points to it.
+// Everything is removed in one transaction.  Returns storm.ErrNotFound if
+// there is no such image.
+func (l *LaCodex) DeleteImage(fileName string) (*GCReport, error) {
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	tx, err := l.db.Begin(true)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Like ImportArchive, the search index is brought up to date after
+	// the transaction is committed.
+	c := l.withTx(tx)
+	c.search = search.NewIndex()
+
+	report, changed, err := c.deleteImage(fileName)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	l.publishUpdate()
+	return report, l.syncSearch(changed)
+}
+
+// deleteImage does the work of DeleteImage.  Returns the Ids of the records
+// that were changed.
+func (l *LaCodex) deleteImage(fileName string) (*GCReport, []int, error) {
+	meta, err := l.idb.LookupFile(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats, err := l.idb.DeleteImage(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := &GCReport{Blobs: stats.Blobs, Bytes: stats.Bytes}
+
+	if meta.Record == 0 {
+		err = l.failures.DeleteStruct(&model.IngestFailure{ImageId: meta.Id})
+		if err == storm.ErrNotFound {
+			return report, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		report.Failures++
+		return report, nil, nil
+	}
+
+	others, err := l.idb.ImagesForRecord(meta.Record)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(others) > 0 {
+		return report, nil, nil
+	}
+	var record model.Record
+	err = l.records.One("Id", meta.Record, &record)
+	if err == storm.ErrNotFound {
+		return report, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	glog.Infof("removing record %d, its last image %s was deleted", record.Id, fileName)
+	err = l.deleteRecord(&record)
+	if err != nil {
+		return nil, nil, err
+	}
+	report.Records++
+	return report, append([]int{record.Id}, record.Translations...), nil
+}
+
+// GC removes records that no image points to, failures of images that no
+// longer exist and stored images that no metadata refers to.
+func (l *LaCodex) GC() (*GCReport, error) {
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	report := &GCReport{}
+
+	metas, err := l.idb.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	images := map[int]bool{}
+	linked := map[int]bool{}
+	for _, meta := range metas {
+		images[meta.Id] = true
+		linked[meta.Record] = true
+	}
+
+	var records []*model.Record
+	err = l.records.All(&records)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if linked[record.Id] {
+			continue
+		}
+		// Reload as removing an earlier record may have changed its
+		// translations.
+		err = l.records.One("Id", record.Id, record)
+		if err != nil {
+			return report, err
+		}
+		glog.Infof("removing record %d, no images point to it", record.Id)
+		err = l.deleteRecord(record)
+		if err != nil {
+			return report, err
+		}
+		report.Records++
+	}
+
+	var failures []*model.IngestFailure
+	err = l.failures.All(&failures)
+	if err != nil {
+		return report, err
+	}
+	for _, f := range failures {
+		if images[f.ImageId] {
+			continue
+		}
+		err = l.failures.DeleteStruct(f)
+		if err != nil {
+			return report, err
+		}
+		report.Failures++
+	}
+
+	stats, err := l.idb.GC()
+	if err != nil {
+		return report, err
+	}
+	report.Blobs = stats.Blobs
+	report.Bytes = stats.Bytes
+	glog.Infof("gc removed %d images (%d bytes)", stats.Blobs, stats.Bytes)
+
+	if report.Records > 0 || report.Failures > 0 {
+		l.publishUpdate()
+	}
+	return report, nil
+}
+
+// gcHandler serves POST /admin/gc
+func (l *LaCodex) gcHandler(w http.ResponseWriter, r *http.Request) {
+	report, err := l.GC()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't collect garbage: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// imageDeleteHandler serves DELETE /image/file/:name
+func (l *LaCodex) imageDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	name := bone.GetValue(r, "name")
+
+	report, err := l.DeleteImage(name)
+	if err == storm.ErrNotFound {
+		httpError(w, http.StatusNotFound, "No image %s", name)
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't delete %s: %v", name, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
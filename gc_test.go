@@ -0,0 +1,103 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func (tlc *testLC) GC(t *testing.T) *GCReport {
+	url := fmt.Sprintf("http://%s/admin/gc", tlc.l.config.ListenAddr)
+	resp, err := http.Post(url, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var report GCReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	assert.NoError(t, err)
+	return &report
+}
+
+func TestGC(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134145_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+	assert.Len(t, tlc.GetFailures(t), 1)
+
+	assert.Equal(t, &GCReport{}, tlc.GC(t))
+
+	// The other file has the same contents so nothing is reclaimed.
+	stats, err := tlc.l.idb.DeleteImage("230700_20190519134140_1.png")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, stats.Blobs)
+	assert.Equal(t, &GCReport{}, tlc.GC(t))
+
+	stats, err = tlc.l.idb.DeleteImage("230700_20190519134145_1.png")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Blobs)
+	assert.True(t, stats.Bytes > 0)
+	stats, err = tlc.l.idb.DeleteImage("230700_20190517185334_1.png")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Blobs)
+
+	assert.Equal(t, &GCReport{Records: 1, Failures: 1}, tlc.GC(t))
+	assert.Empty(t, tlc.GetRecords(t))
+	assert.Empty(t, tlc.GetFailures(t))
+	assert.Empty(t, tlc.Search(t, "q=heavens"))
+
+	assert.Equal(t, &GCReport{}, tlc.GC(t))
+}
+
+func (tlc *testLC) DeleteImage(t *testing.T, fileName string, status int) *GCReport {
+	url := fmt.Sprintf("http://%s/image/file/%s", tlc.l.config.ListenAddr, fileName)
+	req, err := http.NewRequest(http.MethodDelete, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, status, resp.StatusCode)
+	if status != http.StatusOK {
+		return nil
+	}
+
+	var report GCReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	assert.NoError(t, err)
+	return &report
+}
+
+func TestDeleteImage(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134145_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+
+	// The record and contents are shared with the other file.
+	assert.Equal(t, &GCReport{}, tlc.DeleteImage(t, "230700_20190519134140_1.png", http.StatusOK))
+	assert.Len(t, tlc.GetRecords(t), 1)
+
+	report := tlc.DeleteImage(t, "230700_20190519134145_1.png", http.StatusOK)
+	assert.Equal(t, 1, report.Blobs)
+	assert.Equal(t, 1, report.Records)
+	assert.Empty(t, tlc.GetRecords(t))
+
+	report = tlc.DeleteImage(t, "230700_20190517185334_1.png", http.StatusOK)
+	assert.Equal(t, &GCReport{Blobs: 1, Bytes: report.Bytes, Failures: 1}, report)
+	assert.Empty(t, tlc.GetFailures(t))
+
+	tlc.DeleteImage(t, "230700_20190517185334_1.png", http.StatusNotFound)
+	assert.Equal(t, &GCReport{}, tlc.GC(t))
+}
@@ -0,0 +1,50 @@
+package imagedb
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+
+	"github.com/konkers/lacodex/imageutil"
+	"github.com/konkers/lacodex/model"
+)
+
+// VerifyImageData checks that data is an image whose contents have hash.
+func VerifyImageData(hash string, data []byte) (*image.RGBA, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Can't decode image %s: %v", hash, err)
+	}
+	rgba := imageutil.AsRGBA(img)
+	if actual := calcImageHash(rgba); actual != hash {
+		return nil, fmt.Errorf("Image %s has hash %s", hash, actual)
+	}
+	return rgba, nil
+}
+
+// ImportImage stores an image exported from another database.  meta is
+// saved as a new image, its Id is ignored.  The image data is only stored
+// if there isn't already an image with the same contents.  Returns true if
+// it was.
+func (idb *ImageDB) ImportImage(meta *model.ImageMetadata, data []byte) (bool, error) {
+	exists, _ := idb.db.KeyExists(imagesBucket, meta.Hash)
+	if !exists || meta.PerceptualHash == "" {
+		img, err := VerifyImageData(meta.Hash, data)
+		if err != nil {
+			return false, err
+		}
+		if meta.PerceptualHash == "" {
+			meta.PerceptualHash = calcPerceptualHash(img)
+		}
+	}
+
+	if !exists {
+		err := idb.db.SetBytes(imagesBucket, meta.Hash, data)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	meta.Id = 0
+	return !exists, idb.db.Save(meta)
+}
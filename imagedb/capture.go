@@ -0,0 +1,359 @@
+package imagedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// DefaultFileNamePatterns are the file name patterns that capture times are
+// read from.  Patterns have year, month and day groups and optionally hour,
+// minute, second and ampm groups.
+var DefaultFileNamePatterns = []string{
+	// Steam: 230700_20190517183348_1.png or .jpg
+	`^\d+_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})` +
+		`(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})_\d+\.(?:png|jpg)$`,
+
+	// Windows Game Bar: LaMulana 5_17_2019 6_33_48 PM.png
+	`(?:^|\D)(?P<month>\d{1,2})_(?P<day>\d{1,2})_(?P<year>\d{4}) ` +
+		`(?P<hour>\d{1,2})_(?P<minute>\d{2})_(?P<second>\d{2}) (?P<ampm>[AP]M)`,
+
+	// OBS and most everything else: 2019-05-17 18-33-48.png,
+	// Screenshot_20190517_183348.png
+	`(?:^|\D)(?P<year>\d{4})-?(?P<month>\d{2})-?(?P<day>\d{2})[ _T-]?` +
+		`(?P<hour>\d{2})[-_.:]?(?P<minute>\d{2})[-_.:]?(?P<second>\d{2})(?:\D|$)`,
+}
+
+// CaptureInfo describes a screenshot whose capture time is wanted.  Only
+// FileName is required.
+type CaptureInfo struct {
+	FileName string
+
+	// Data is the screenshot file, for reading embedded timestamps.
+	Data []byte
+
+	// CapturedAt is a capture time given by the uploader.
+	CapturedAt time.Time
+
+	// ModTime is the file's modification time.
+	ModTime time.Time
+}
+
+// Capture is when a screenshot was captured and how that was worked out.
+type Capture struct {
+	Time   time.Time
+	Source model.CaptureSource
+}
+
+// CaptureTimeExtractor is one way of working out when a screenshot was
+// captured.
+type CaptureTimeExtractor interface {
+	// CaptureTime returns the capture time of the screenshot described by
+	// info or false if the extractor can't tell.
+	CaptureTime(info *CaptureInfo) (time.Time, bool)
+	Source() model.CaptureSource
+}
+
+// FileNameExtractor reads capture times from file names.
+type FileNameExtractor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewFileNameExtractor returns a FileNameExtractor that tries patterns
+// before DefaultFileNamePatterns.
+func NewFileNameExtractor(patterns []string) (*FileNameExtractor, error) {
+	e := &FileNameExtractor{}
+	for _, p := range append(append([]string{}, patterns...), DefaultFileNamePatterns...) {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Bad file name pattern %q: %v", p, err)
+		}
+		names := map[string]bool{}
+		for _, name := range re.SubexpNames() {
+			names[name] = true
+		}
+		if !names["year"] || !names["month"] || !names["day"] {
+			return nil, fmt.Errorf("File name pattern %q needs year, month and day groups", p)
+		}
+		e.patterns = append(e.patterns, re)
+	}
+	return e, nil
+}
+
+var defaultFileNameExtractor, _ = NewFileNameExtractor(nil)
+
+func (e *FileNameExtractor) Source() model.CaptureSource {
+	return model.CaptureSourceFileName
+}
+
+// Matches returns true if fileName matches one of e's patterns.
+func (e *FileNameExtractor) Matches(fileName string) bool {
+	_, ok := e.timeFromName(filepath.Base(fileName))
+	return ok
+}
+
+func (e *FileNameExtractor) CaptureTime(info *CaptureInfo) (time.Time, bool) {
+	return e.timeFromName(filepath.Base(info.FileName))
+}
+
+func (e *FileNameExtractor) timeFromName(name string) (time.Time, bool) {
+	for _, re := range e.patterns {
+		m := re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+
+		fields := map[string]int{}
+		ampm := ""
+		for i, group := range re.SubexpNames() {
+			if group == "ampm" {
+				ampm = strings.ToUpper(m[i])
+			} else if group != "" {
+				// Groups that didn't take part in the match are 0.
+				fields[group], _ = strconv.Atoi(m[i])
+			}
+		}
+		hour := fields["hour"]
+		if ampm != "" {
+			if hour < 1 || hour > 12 {
+				continue
+			}
+			hour %= 12
+			if ampm == "PM" {
+				hour += 12
+			}
+		}
+
+		t := time.Date(fields["year"], time.Month(fields["month"]), fields["day"],
+			hour, fields["minute"], fields["second"], 0, time.Local)
+		// time.Date normalizes out of range values.  They mean the
+		// pattern matched something that isn't a timestamp.
+		if t.Month() != time.Month(fields["month"]) || t.Day() != fields["day"] ||
+			t.Hour() != hour || t.Minute() != fields["minute"] || t.Second() != fields["second"] {
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunks calls f with the type and contents of each chunk in a PNG file.
+// Stops early if f returns false.
+func pngChunks(data []byte, f func(chunkType string, chunk []byte) bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return
+	}
+	data = data[len(pngSignature):]
+	for len(data) >= 12 {
+		length := binary.BigEndian.Uint32(data[:4])
+		if uint64(length) > uint64(len(data)-12) {
+			return
+		}
+		if !f(string(data[4:8]), data[8:8+length]) {
+			return
+		}
+		data = data[12+length:]
+	}
+}
+
+// Layouts of the PNG "Creation Time" text chunk seen in the wild.  The spec
+// suggests RFC 1123.
+var pngTimeLayouts = []string{
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC3339,
+	"2006:01:02 15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// PNGExtractor reads capture times from PNG "Creation Time" text chunks and
+// tIME chunks.
+type PNGExtractor struct{}
+
+func (PNGExtractor) Source() model.CaptureSource {
+	return model.CaptureSourcePNG
+}
+
+func (PNGExtractor) CaptureTime(info *CaptureInfo) (time.Time, bool) {
+	var text, modified time.Time
+	pngChunks(info.Data, func(chunkType string, chunk []byte) bool {
+		switch chunkType {
+		case "tEXt":
+			kv := bytes.SplitN(chunk, []byte{0}, 2)
+			if len(kv) != 2 || string(kv[0]) != "Creation Time" {
+				break
+			}
+			for _, layout := range pngTimeLayouts {
+				t, err := time.ParseInLocation(layout, string(kv[1]), time.Local)
+				if err == nil {
+					text = t
+					return false
+				}
+			}
+		case "tIME":
+			if len(chunk) != 7 {
+				break
+			}
+			// tIME is always UTC.
+			modified = time.Date(int(binary.BigEndian.Uint16(chunk[:2])),
+				time.Month(chunk[2]), int(chunk[3]), int(chunk[4]), int(chunk[5]), int(chunk[6]),
+				0, time.UTC)
+		}
+		return true
+	})
+
+	if !text.IsZero() {
+		return text, true
+	}
+	return modified, !modified.IsZero()
+}
+
+// exifData returns the TIFF structure holding the EXIF data of a JPEG or PNG
+// file or nil if it doesn't have any.
+func exifData(data []byte) []byte {
+	var exif []byte
+	pngChunks(data, func(chunkType string, chunk []byte) bool {
+		if chunkType == "eXIf" {
+			exif = chunk
+			return false
+		}
+		return true
+	})
+	if exif != nil {
+		return exif
+	}
+
+	// JPEG markers up to the start of the image data.
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil
+	}
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xff && data[1] != 0xda {
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 2 || length+2 > len(data) {
+			return nil
+		}
+		segment := data[4 : 2+length]
+		if data[1] == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		data = data[2+length:]
+	}
+	return nil
+}
+
+const (
+	exifTagDateTime         = 0x0132
+	exifTagExifIFD          = 0x8769
+	exifTagDateTimeOriginal = 0x9003
+)
+
+// tiffIFD returns the tags of the IFD at offset in tiff.  Values are the
+// raw 4 byte value or offset fields.
+func tiffIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]uint32 {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	entries := tiff[offset+2:]
+	if len(entries) < n*12 {
+		return nil
+	}
+	tags := map[uint16]uint32{}
+	for i := 0; i < n; i++ {
+		e := entries[i*12:]
+		tags[order.Uint16(e)] = order.Uint32(e[8:])
+	}
+	return tags
+}
+
+// tiffTime reads an EXIF timestamp stored at offset in tiff.
+func tiffTime(tiff []byte, offset uint32) (time.Time, bool) {
+	const layout = "2006:01:02 15:04:05"
+	if uint64(offset)+uint64(len(layout)) > uint64(len(tiff)) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(layout, string(tiff[offset:offset+uint32(len(layout))]), time.Local)
+	return t, err == nil
+}
+
+// EXIFExtractor reads capture times from the EXIF data of JPEG and PNG
+// files.
+type EXIFExtractor struct{}
+
+func (EXIFExtractor) Source() model.CaptureSource {
+	return model.CaptureSourceEXIF
+}
+
+func (EXIFExtractor) CaptureTime(info *CaptureInfo) (time.Time, bool) {
+	tiff := exifData(info.Data)
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	ifd0 := tiffIFD(tiff, order, order.Uint32(tiff[4:]))
+	if offset, ok := ifd0[exifTagExifIFD]; ok {
+		exif := tiffIFD(tiff, order, offset)
+		if offset, ok := exif[exifTagDateTimeOriginal]; ok {
+			if t, ok := tiffTime(tiff, offset); ok {
+				return t, true
+			}
+		}
+	}
+	if offset, ok := ifd0[exifTagDateTime]; ok {
+		return tiffTime(tiff, offset)
+	}
+	return time.Time{}, false
+}
+
+// UploadExtractor uses the capture time given by the uploader.
+type UploadExtractor struct{}
+
+func (UploadExtractor) Source() model.CaptureSource {
+	return model.CaptureSourceUpload
+}
+
+func (UploadExtractor) CaptureTime(info *CaptureInfo) (time.Time, bool) {
+	return info.CapturedAt, !info.CapturedAt.IsZero()
+}
+
+// ModTimeExtractor uses the file's modification time.
+type ModTimeExtractor struct{}
+
+func (ModTimeExtractor) Source() model.CaptureSource {
+	return model.CaptureSourceModTime
+}
+
+func (ModTimeExtractor) CaptureTime(info *CaptureInfo) (time.Time, bool) {
+	return info.ModTime, !info.ModTime.IsZero()
+}
+
+// DefaultCaptureTimeExtractors returns the extractors in the order they are
+// tried by default.
+func DefaultCaptureTimeExtractors(fileNames *FileNameExtractor) []CaptureTimeExtractor {
+	return []CaptureTimeExtractor{
+		fileNames,
+		PNGExtractor{},
+		EXIFExtractor{},
+		UploadExtractor{},
+		ModTimeExtractor{},
+	}
+}
@@ -0,0 +1,196 @@
+package imagedb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileNameExtractor(t *testing.T) {
+	e, err := NewFileNameExtractor([]string{`^codex-(?P<year>\d{4})\.(?P<month>\d+)\.(?P<day>\d+)\.png$`})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		expected time.Time
+	}{
+		{"230700_20190517183348_1.png", time.Date(2019, 5, 17, 18, 33, 48, 0, time.Local)},
+		{"/some/dir/230700_20190517183348_1.png", time.Date(2019, 5, 17, 18, 33, 48, 0, time.Local)},
+		{"230700_20190517183348_1.jpg", time.Date(2019, 5, 17, 18, 33, 48, 0, time.Local)},
+		{"LaMulana 5_17_2019 6_33_48 PM.png", time.Date(2019, 5, 17, 18, 33, 48, 0, time.Local)},
+		{"LaMulana 5_17_2019 12_03_48 AM.png", time.Date(2019, 5, 17, 0, 3, 48, 0, time.Local)},
+		{"2019-05-17 18-33-48.png", time.Date(2019, 5, 17, 18, 33, 48, 0, time.Local)},
+		{"Screenshot_20190517_183348.png", time.Date(2019, 5, 17, 18, 33, 48, 0, time.Local)},
+		{"codex-2019.5.17.png", time.Date(2019, 5, 17, 0, 0, 0, 0, time.Local)},
+		{"230700_2019051718334_1.png", time.Time{}},
+		{"2019-13-17 18-33-48.png", time.Time{}},
+		{"LaMulana 5_17_2019 13_33_48 PM.png", time.Time{}},
+		{"thumbnails", time.Time{}},
+	}
+
+	for _, test := range tests {
+		captured, ok := e.CaptureTime(&CaptureInfo{FileName: test.name})
+		assert.Equal(t, !test.expected.IsZero(), ok, test.name)
+		assert.True(t, test.expected.Equal(captured), "%s: got %v", test.name, captured)
+		assert.Equal(t, ok, e.Matches(test.name), test.name)
+	}
+
+	// Built in patterns only.
+	assert.False(t, defaultFileNameExtractor.Matches("codex-2019.5.17.png"))
+
+	_, err = NewFileNameExtractor([]string{`(`})
+	assert.Error(t, err)
+	_, err = NewFileNameExtractor([]string{`(?P<year>\d{4})(?P<month>\d{2})`})
+	assert.Error(t, err)
+}
+
+// newTestPNG returns a PNG signature followed by chunks.  Only the chunks
+// are of interest so no image data is included.
+func newTestPNG(chunks ...string) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write(pngSignature)
+	for i := 0; i < len(chunks); i += 2 {
+		binary.Write(buf, binary.BigEndian, uint32(len(chunks[i+1])))
+		typeAndData := chunks[i] + chunks[i+1]
+		buf.WriteString(typeAndData)
+		binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE([]byte(typeAndData)))
+	}
+	return buf.Bytes()
+}
+
+func TestPNGExtractor(t *testing.T) {
+	tIME := "\x07\xe3\x05\x11\x12\x21\x30"
+	tests := []struct {
+		data     []byte
+		expected time.Time
+	}{
+		{newTestPNG("IHDR", "", "tIME", tIME), time.Date(2019, 5, 17, 18, 33, 48, 0, time.UTC)},
+		{newTestPNG("tIME", tIME, "tEXt", "Creation Time\x002019:05:17 10:00:00"),
+			time.Date(2019, 5, 17, 10, 0, 0, 0, time.Local)},
+		{newTestPNG("tEXt", "Creation Time\x00Fri, 17 May 2019 10:00:00 UTC"),
+			time.Date(2019, 5, 17, 10, 0, 0, 0, time.UTC)},
+		{newTestPNG("tEXt", "Software\x00LaMulana"), time.Time{}},
+		{newTestPNG("tIME", "\x07\xe3"), time.Time{}},
+		{[]byte("not a png"), time.Time{}},
+		{nil, time.Time{}},
+	}
+
+	for i, test := range tests {
+		captured, ok := PNGExtractor{}.CaptureTime(&CaptureInfo{Data: test.data})
+		assert.Equal(t, !test.expected.IsZero(), ok, "%d", i)
+		assert.True(t, test.expected.Equal(captured), "%d: got %v", i, captured)
+	}
+}
+
+// newTestExif returns a little endian TIFF structure with DateTime in IFD0
+// and, if set, DateTimeOriginal in an EXIF IFD.
+func newTestExif(dateTime, original string) []byte {
+	buf := &bytes.Buffer{}
+	le := binary.LittleEndian
+	buf.WriteString("II")
+	binary.Write(buf, le, uint16(42))
+	binary.Write(buf, le, uint32(8))
+
+	// IFD0 at 8 with two entries, the EXIF IFD at 38 with one and the
+	// strings after it at 56 and 76.
+	entry := func(tag uint16, kind uint16, count uint32, value uint32) {
+		binary.Write(buf, le, tag)
+		binary.Write(buf, le, kind)
+		binary.Write(buf, le, count)
+		binary.Write(buf, le, value)
+	}
+	binary.Write(buf, le, uint16(2))
+	entry(exifTagDateTime, 2, 20, 56)
+	entry(exifTagExifIFD, 4, 1, 38)
+	binary.Write(buf, le, uint32(0))
+	binary.Write(buf, le, uint16(1))
+	entry(exifTagDateTimeOriginal, 2, 20, 76)
+	binary.Write(buf, le, uint32(0))
+	buf.WriteString(dateTime + "\x00")
+	buf.WriteString(original + "\x00")
+	return buf.Bytes()
+}
+
+// newTestJPEG returns the start of a JPEG file with exif in an APP1
+// segment.
+func newTestJPEG(exif []byte) []byte {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0xff, 0xd8})
+	// An APP0 segment to skip over.
+	buf.Write([]byte{0xff, 0xe0, 0x00, 0x04, 0x00, 0x00})
+	buf.Write([]byte{0xff, 0xe1})
+	binary.Write(buf, binary.BigEndian, uint16(len(exif)+8))
+	buf.WriteString("Exif\x00\x00")
+	buf.Write(exif)
+	buf.Write([]byte{0xff, 0xda})
+	return buf.Bytes()
+}
+
+func TestEXIFExtractor(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected time.Time
+	}{
+		{newTestJPEG(newTestExif("2019:05:17 18:33:48", "2019:05:17 10:00:00")),
+			time.Date(2019, 5, 17, 10, 0, 0, 0, time.Local)},
+		{newTestJPEG(newTestExif("2019:05:17 18:33:48", "")),
+			time.Date(2019, 5, 17, 18, 33, 48, 0, time.Local)},
+		{newTestPNG("eXIf", string(newTestExif("2019:05:17 18:33:48", "2019:05:17 10:00:00"))),
+			time.Date(2019, 5, 17, 10, 0, 0, 0, time.Local)},
+		{newTestJPEG([]byte("XX\x2a\x00")), time.Time{}},
+		{newTestJPEG(nil), time.Time{}},
+		{newTestPNG("IHDR", ""), time.Time{}},
+	}
+
+	for i, test := range tests {
+		captured, ok := EXIFExtractor{}.CaptureTime(&CaptureInfo{Data: test.data})
+		assert.Equal(t, !test.expected.IsZero(), ok, "%d", i)
+		assert.True(t, test.expected.Equal(captured), "%d: got %v", i, captured)
+	}
+}
+
+func TestCaptureTime(t *testing.T) {
+	idb := NewImageDB(nil)
+	uploaded := time.Date(2019, 5, 17, 10, 0, 0, 0, time.UTC)
+	modified := time.Date(2019, 5, 18, 10, 0, 0, 0, time.UTC)
+	png := newTestPNG("tIME", "\x07\xe3\x05\x11\x12\x21\x30")
+
+	tests := []struct {
+		info   CaptureInfo
+		source model.CaptureSource
+	}{
+		{CaptureInfo{FileName: "230700_20190517183348_1.png", Data: png, CapturedAt: uploaded},
+			model.CaptureSourceFileName},
+		{CaptureInfo{FileName: "tablet.png", Data: png, CapturedAt: uploaded}, model.CaptureSourcePNG},
+		{CaptureInfo{FileName: "tablet.png", CapturedAt: uploaded, ModTime: modified},
+			model.CaptureSourceUpload},
+		{CaptureInfo{FileName: "tablet.png", ModTime: modified}, model.CaptureSourceModTime},
+	}
+	for _, test := range tests {
+		capture, err := idb.CaptureTime(&test.info)
+		if assert.NoError(t, err) {
+			assert.Equal(t, test.source, capture.Source)
+		}
+	}
+
+	_, err := idb.CaptureTime(&CaptureInfo{FileName: "/some/dir/tablet.png"})
+	assert.EqualError(t, err, "Can't tell when tablet.png was captured")
+
+	err = idb.SetFileNamePatterns([]string{`^tablet-(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})\.png$`})
+	assert.NoError(t, err)
+	assert.True(t, idb.IsScreenshotName("tablet-20190517.png"))
+	assert.True(t, idb.IsScreenshotName("230700_20190517183348_1.png"))
+	assert.False(t, idb.IsScreenshotName("tablet.png"))
+	assert.Error(t, idb.SetFileNamePatterns([]string{`(`}))
+
+	idb.SetCaptureTimeExtractors([]CaptureTimeExtractor{ModTimeExtractor{}})
+	capture, err := idb.CaptureTime(&CaptureInfo{FileName: "tablet-20190517.png", ModTime: modified})
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Capture{Time: modified, Source: model.CaptureSourceModTime}, capture)
+	}
+}
@@ -0,0 +1,106 @@
+package imagedb
+
+import (
+	"github.com/asdine/storm"
+	"github.com/konkers/lacodex/model"
+)
+
+// GCStats describes the image data removed by DeleteImage or GC.
+type GCStats struct {
+	// Blobs is the number of stored images removed.
+	Blobs int `json:"blobs"`
+
+	// Bytes is the size of the removed images.
+	Bytes int64 `json:"bytes"`
+}
+
+// deleteBlob removes the image data stored under hash.
+func (idb *ImageDB) deleteBlob(hash string, stats *GCStats) error {
+	data, err := idb.db.GetBytes(imagesBucket, hash)
+	if err == storm.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = idb.db.Delete(imagesBucket, hash)
+	if err != nil {
+		return err
+	}
+	stats.Blobs++
+	stats.Bytes += int64(len(data))
+	return nil
+}
+
+// DeleteImage removes the metadata for fileName.  The image data is removed
+// too unless another file has the same contents.  Both are done in one
+// transaction so that an import of the same contents can't lose its data.
+func (idb *ImageDB) DeleteImage(fileName string) (*GCStats, error) {
+	stats := &GCStats{}
+	err := idb.update(func(idb *ImageDB) error {
+		meta, err := idb.LookupFile(fileName)
+		if err != nil {
+			return err
+		}
+
+		err = idb.db.DeleteStruct(meta)
+		if err != nil {
+			return err
+		}
+
+		others, err := idb.ImagesWithHash(meta.Hash)
+		if err != nil {
+			return err
+		}
+		if len(others) == 0 {
+			return idb.deleteBlob(meta.Hash, stats)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GC removes image data that no metadata refers to.
+func (idb *ImageDB) GC() (*GCStats, error) {
+	stats := &GCStats{}
+	err := idb.update(func(idb *ImageDB) error {
+		var metas []*model.ImageMetadata
+		err := idb.db.All(&metas)
+		if err != nil {
+			return err
+		}
+		referenced := map[string]bool{}
+		for _, meta := range metas {
+			referenced[meta.Hash] = true
+		}
+
+		// Keys are only valid during the query so collect them before
+		// deleting anything.
+		var unreferenced []string
+		err = idb.db.Select().Bucket(imagesBucket).RawEach(func(k, v []byte) error {
+			if !referenced[string(k)] {
+				unreferenced = append(unreferenced, string(k))
+			}
+			return nil
+		})
+		if err != nil && err != storm.ErrNotFound {
+			return err
+		}
+
+		for _, hash := range unreferenced {
+			err = idb.deleteBlob(hash, stats)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -9,9 +9,7 @@ import (
 	"image/png"
 	_ "image/png" // Pull in png decoder.
 	"path/filepath"
-	"regexp"
-	"strconv"
-	"time"
+	"sort"
 
 	"github.com/konkers/lacodex/model"
 
@@ -20,34 +18,94 @@ import (
 
 const imagesBucket = "__images__"
 
-// Steam names screenshots like 230700_20190517183348_1.png
-var screenshotNameRegexp = regexp.MustCompile(
-	`^\d+_(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})` +
-		`(?P<hour>\d{2})(?P<minute>\d{2})(?P<second>\d{2})_\d+\.png`)
-
 type ImageDB struct {
 	db storm.Node
+
+	// inTx is true if db is a write transaction that the caller commits.
+	inTx bool
+
+	fileNames  *FileNameExtractor
+	extractors []CaptureTimeExtractor
 }
 
 func NewImageDB(db storm.Node) *ImageDB {
-	return &ImageDB{db: db}
+	return &ImageDB{
+		db:         db,
+		fileNames:  defaultFileNameExtractor,
+		extractors: DefaultCaptureTimeExtractors(defaultFileNameExtractor),
+	}
+}
+
+// WithNode returns a copy of idb that stores images in db.
+func (idb *ImageDB) WithNode(db storm.Node) *ImageDB {
+	c := *idb
+	c.db = db
+	c.inTx = false
+	return &c
+}
+
+// WithTransaction returns a copy of idb that stores images in tx, a write
+// transaction that the caller commits.  Changes that idb would make in a
+// transaction of its own are made in tx instead.
+func (idb *ImageDB) WithTransaction(tx storm.Node) *ImageDB {
+	c := idb.WithNode(tx)
+	c.inTx = true
+	return c
+}
+
+// update runs f with a copy of idb that uses a write transaction.  If idb
+// is using the caller's transaction f runs in it, as bolt can't nest write
+// transactions.  Otherwise a new transaction is committed if f succeeds.
+func (idb *ImageDB) update(f func(idb *ImageDB) error) error {
+	if idb.inTx {
+		return f(idb)
+	}
+
+	tx, err := idb.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = f(idb.WithTransaction(tx))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func getScreenshotTime(fileName string) (time.Time, error) {
-	m := screenshotNameRegexp.FindStringSubmatch(fileName)
-	if m == nil {
-		return time.Time{}, fmt.Errorf("%s is not a properly formatted steam screenshot name", fileName)
+// SetFileNamePatterns sets file name patterns that are tried before
+// DefaultFileNamePatterns and resets the capture time extractors to the
+// defaults.
+func (idb *ImageDB) SetFileNamePatterns(patterns []string) error {
+	e, err := NewFileNameExtractor(patterns)
+	if err != nil {
+		return err
 	}
+	idb.fileNames = e
+	idb.extractors = DefaultCaptureTimeExtractors(e)
+	return nil
+}
 
-	// We know these will succeed as they came from the regexp.
-	year, _ := strconv.Atoi(m[1])
-	month, _ := strconv.Atoi(m[2])
-	day, _ := strconv.Atoi(m[3])
-	hour, _ := strconv.Atoi(m[4])
-	minute, _ := strconv.Atoi(m[5])
-	sec, _ := strconv.Atoi(m[6])
+// SetCaptureTimeExtractors replaces the ways capture times are worked out.
+// They are tried in order.
+func (idb *ImageDB) SetCaptureTimeExtractors(extractors []CaptureTimeExtractor) {
+	idb.extractors = extractors
+}
 
-	return time.Date(year, time.Month(month), day, hour, minute, sec, 0, time.Local), nil
+// IsScreenshotName returns true if fileName has a capture time in it.
+func (idb *ImageDB) IsScreenshotName(fileName string) bool {
+	return idb.fileNames.Matches(fileName)
+}
+
+// CaptureTime works out when the screenshot described by info was captured.
+func (idb *ImageDB) CaptureTime(info *CaptureInfo) (*Capture, error) {
+	for _, e := range idb.extractors {
+		if t, ok := e.CaptureTime(info); ok {
+			return &Capture{Time: t, Source: e.Source()}, nil
+		}
+	}
+	return nil, fmt.Errorf("Can't tell when %s was captured", filepath.Base(info.FileName))
 }
 
 func calcImageHash(img *image.RGBA) string {
@@ -72,41 +130,42 @@ func encodeImage(img *image.RGBA) ([]byte, error) {
 	return buf.Bytes(), err
 }
 
-func (idb *ImageDB) ImportScreenshot(fileName string, recordId int, img *image.RGBA) error {
+// ImportScreenshot stores a game image and its metadata.  capture is from
+// CaptureTime.  The image data and metadata are stored in one transaction so
+// that DeleteImage can't remove the data in between.
+func (idb *ImageDB) ImportScreenshot(fileName string, capture *Capture, recordId int, img *image.RGBA) error {
 	bounds := img.Bounds()
 	if bounds.Dx() != 640 && bounds.Dy() != 480 {
 		return fmt.Errorf("Image size (%dx%d) was not the expected 640x480", bounds.Dx(), bounds.Dy())
 	}
 
 	baseName := filepath.Base(fileName)
-	capturedAt, err := getScreenshotTime(baseName)
-	if err != nil {
-		return err
-	}
 
 	hash := calcImageHash(img)
-	exists, _ := idb.db.KeyExists(imagesBucket, hash)
-
-	if !exists {
-		imgData, err := encodeImage(img)
-		if err != nil {
-			return err
-		}
-
-		err = idb.db.SetBytes(imagesBucket, hash, imgData)
-		if err != nil {
-			return err
-		}
+	imgData, err := encodeImage(img)
+	if err != nil {
+		return err
 	}
 
 	meta := model.ImageMetadata{
-		Hash:       hash,
-		CapturedAt: capturedAt,
-		FileName:   baseName,
-		Record:     recordId,
+		Hash:           hash,
+		CapturedAt:     capture.Time,
+		FileName:       baseName,
+		Record:         recordId,
+		CaptureSource:  capture.Source,
+		PerceptualHash: calcPerceptualHash(img),
 	}
 
-	return idb.db.Save(&meta)
+	return idb.update(func(idb *ImageDB) error {
+		exists, _ := idb.db.KeyExists(imagesBucket, hash)
+		if !exists {
+			err := idb.db.SetBytes(imagesBucket, hash, imgData)
+			if err != nil {
+				return err
+			}
+		}
+		return idb.db.Save(&meta)
+	})
 }
 
 func (idb *ImageDB) LookupFile(fileName string) (*model.ImageMetadata, error) {
@@ -119,6 +178,42 @@ func (idb *ImageDB) LookupFile(fileName string) (*model.ImageMetadata, error) {
 	return &meta, nil
 }
 
+// LinkRecord points the image metadata for fileName at recordId.
+func (idb *ImageDB) LinkRecord(fileName string, recordId int) error {
+	meta, err := idb.LookupFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	return idb.db.UpdateField(meta, "Record", recordId)
+}
+
+// ImagesForRecord returns the metadata of all images that point at recordId.
+func (idb *ImageDB) ImagesForRecord(recordId int) ([]*model.ImageMetadata, error) {
+	var meta []*model.ImageMetadata
+	err := idb.db.Find("Record", recordId, &meta)
+	if err == storm.ErrNotFound {
+		return []*model.ImageMetadata{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// ImagesWithHash returns the metadata of all images whose contents have hash.
+func (idb *ImageDB) ImagesWithHash(hash string) ([]*model.ImageMetadata, error) {
+	var meta []*model.ImageMetadata
+	err := idb.db.Find("Hash", hash, &meta)
+	if err == storm.ErrNotFound {
+		return []*model.ImageMetadata{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
 func (idb *ImageDB) GetImageData(hash string) ([]byte, error) {
 	return idb.db.GetBytes(imagesBucket, hash)
 }
@@ -145,3 +240,90 @@ func (idb *ImageDB) ListImages() ([]*model.ImageMetadata, error) {
 	}
 	return meta, nil
 }
+
+// SimilarImages returns the images whose perceptual hashes are within
+// maxDistance of hash, closest first.
+func (idb *ImageDB) SimilarImages(hash string, maxDistance int) ([]*model.SimilarImage, error) {
+	all, err := idb.ListImages()
+	if err != nil {
+		return nil, err
+	}
+
+	similar := []*model.SimilarImage{}
+	for _, meta := range all {
+		if meta.PerceptualHash == "" {
+			continue
+		}
+		d, err := HashDistance(hash, meta.PerceptualHash)
+		if err != nil {
+			return nil, err
+		}
+		if d <= maxDistance {
+			similar = append(similar, &model.SimilarImage{ImageMetadata: *meta, Distance: d})
+		}
+	}
+
+	sort.SliceStable(similar, func(i, j int) bool {
+		return similar[i].Distance < similar[j].Distance
+	})
+	return similar, nil
+}
+
+// BackfillPerceptualHashes sets the perceptual hashes of images that were
+// imported before they were calculated.  Returns the number of images
+// updated.
+func (idb *ImageDB) BackfillPerceptualHashes() (int, error) {
+	all, err := idb.ListImages()
+	if err != nil {
+		return 0, err
+	}
+
+	// Images with the same contents share a perceptual hash.
+	hashes := map[string]string{}
+	updated := 0
+	for _, meta := range all {
+		if meta.PerceptualHash != "" {
+			continue
+		}
+
+		phash, ok := hashes[meta.Hash]
+		if !ok {
+			img, err := idb.GetImage(meta.Hash)
+			if err != nil {
+				return updated, fmt.Errorf("Can't load image %s: %v", meta.Hash, err)
+			}
+			phash = calcPerceptualHash(img)
+			hashes[meta.Hash] = phash
+		}
+
+		err = idb.db.UpdateField(meta, "PerceptualHash", phash)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// BackfillCaptureSources marks images that were imported before capture
+// sources were recorded as captured at the time in their file names, which
+// was the only source then.  Returns the number of images updated.
+func (idb *ImageDB) BackfillCaptureSources() (int, error) {
+	all, err := idb.ListImages()
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, meta := range all {
+		if meta.CaptureSource != "" {
+			continue
+		}
+		err = idb.db.UpdateField(meta, "CaptureSource", model.CaptureSourceFileName)
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
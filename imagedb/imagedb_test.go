@@ -1,6 +1,7 @@
 package imagedb
 
 import (
+	"fmt"
 	"image"
 	"io/ioutil"
 	"os"
@@ -46,23 +47,6 @@ func (i *testIdb) Close() {
 	i.Db.Close()
 	os.Remove(i.Filename)
 }
-func TestGetScreenshotTime(t *testing.T) {
-	timestamp, err := getScreenshotTime("230700_20190517183348_1.png")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	expected := time.Date(2019, time.Month(5), 17, 18, 33, 48, 0, time.Local)
-	if !timestamp.Equal(expected) {
-		t.Fatalf("Got %v, expected %v", timestamp, expected)
-	}
-
-	_, err = getScreenshotTime("230700_2019051718334_1.png")
-	if err == nil {
-		t.Fatal("Expected error")
-	}
-}
-
 func TestCalcImageHash(t *testing.T) {
 	imgA := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/230700_20190519134140_1.png"))
 	imgB := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/230700_20190519134145_1.png"))
@@ -110,11 +94,19 @@ func TestImportScreenshot(t *testing.T) {
 	imgA := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/230700_20190519134140_1.png"))
 	imgB := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/230700_20190519134145_1.png"))
 
-	err := idb.ImportScreenshot("230700_20190519134140_1.png", 1, imgA)
+	capture := &Capture{
+		Time:   time.Date(2019, time.Month(5), 19, 13, 41, 40, 0, time.Local),
+		Source: model.CaptureSourceFileName,
+	}
+	err := idb.ImportScreenshot("230700_20190519134140_1.png", capture, 1, imgA)
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = idb.ImportScreenshot("230700_20190519134145_1.png", 2, imgB)
+	capture = &Capture{
+		Time:   time.Date(2019, time.Month(5), 19, 13, 41, 45, 0, time.Local),
+		Source: model.CaptureSourceFileName,
+	}
+	err = idb.ImportScreenshot("230700_20190519134145_1.png", capture, 2, imgB)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -130,19 +122,23 @@ func TestImportScreenshot(t *testing.T) {
 	}
 
 	assert.Equal(t, &model.ImageMetadata{
-		Id:         1,
-		Hash:       "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
-		CapturedAt: time.Date(2019, time.Month(5), 19, 13, 41, 40, 0, time.Local),
-		FileName:   "230700_20190519134140_1.png",
-		Record:     1,
+		Id:             1,
+		Hash:           "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
+		CapturedAt:     time.Date(2019, time.Month(5), 19, 13, 41, 40, 0, time.Local),
+		FileName:       "230700_20190519134140_1.png",
+		Record:         1,
+		CaptureSource:  model.CaptureSourceFileName,
+		PerceptualHash: "dhash-8100d7005300df00df009f008e008c0080008000800080008000800080008200",
 	}, metaA)
 
 	assert.Equal(t, &model.ImageMetadata{
-		Id:         2,
-		Hash:       "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
-		CapturedAt: time.Date(2019, time.Month(5), 19, 13, 41, 45, 0, time.Local),
-		FileName:   "230700_20190519134145_1.png",
-		Record:     2,
+		Id:             2,
+		Hash:           "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
+		CapturedAt:     time.Date(2019, time.Month(5), 19, 13, 41, 45, 0, time.Local),
+		FileName:       "230700_20190519134145_1.png",
+		Record:         2,
+		CaptureSource:  model.CaptureSourceFileName,
+		PerceptualHash: "dhash-8100d7005300df00df009f008e008c0080008000800080008000800080008200",
 	}, metaB)
 
 	img, err := idb.GetImage(metaA.Hash)
@@ -158,15 +154,48 @@ func TestImportScreenshot(t *testing.T) {
 	}
 	assert.Equal(t, []*model.ImageMetadata{metaA, metaB}, meta)
 
-	// Test failure case: Unencodable image.
-	img = image.NewRGBA(image.Rect(0, 0, 0, 0))
-	err = idb.ImportScreenshot("230700_20190519134145_1.png", 1, img.(*image.RGBA))
+	err = idb.LinkRecord("230700_20190519134145_1.png", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metaB, err = idb.LookupFile("230700_20190519134145_1.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, metaB.Record)
+
+	meta, err = idb.ImagesForRecord(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []*model.ImageMetadata{metaB}, meta)
+
+	meta, err = idb.ImagesForRecord(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, meta)
+
+	meta, err = idb.ImagesWithHash(metaA.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []*model.ImageMetadata{metaA, metaB}, meta)
+
+	meta, err = idb.ImagesWithHash("sha256-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, meta)
+
+	err = idb.LinkRecord("230700_20190519134146_1.png", 3)
 	if err == nil {
 		t.Fatal("Expected error")
 	}
 
-	// Test failure case: Bad file name.
-	err = idb.ImportScreenshot("230700_2019051913414_1.png", 1, imgA)
+	// Test failure case: Unencodable image.
+	img = image.NewRGBA(image.Rect(0, 0, 0, 0))
+	err = idb.ImportScreenshot("230700_20190519134145_1.png", capture, 1, img.(*image.RGBA))
 	if err == nil {
 		t.Fatal("Expected error")
 	}
@@ -184,3 +213,193 @@ func TestImportScreenshot(t *testing.T) {
 		t.Fatal("Expected error")
 	}
 }
+
+func TestSimilarImages(t *testing.T) {
+	testIdb := newTestImageDB(t)
+	defer testIdb.Close()
+	idb := testIdb.Idb
+
+	files := []string{
+		"230700_20190519134140_1.png",
+		"230700_20190519134145_1.png",
+		"230700_20190517185334_1.png",
+	}
+	for i, file := range files {
+		img := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/"+file))
+		capture, err := idb.CaptureTime(&CaptureInfo{FileName: file})
+		assert.NoError(t, err)
+		err = idb.ImportScreenshot(file, capture, i+1, img)
+		assert.NoError(t, err)
+	}
+	meta, err := idb.ListImages()
+	assert.NoError(t, err)
+
+	similar, err := idb.SimilarImages(meta[0].PerceptualHash, DefaultSimilarDistance)
+	assert.NoError(t, err)
+	assert.Equal(t, []*model.SimilarImage{
+		{ImageMetadata: *meta[0], Distance: 0},
+		{ImageMetadata: *meta[1], Distance: 0},
+	}, similar)
+
+	similar, err = idb.SimilarImages(meta[2].PerceptualHash, 256)
+	assert.NoError(t, err)
+	if assert.Len(t, similar, 3) {
+		assert.Equal(t, meta[2].FileName, similar[0].FileName)
+		assert.True(t, similar[1].Distance > DefaultSimilarDistance)
+	}
+
+	_, err = idb.SimilarImages("sha256-0", DefaultSimilarDistance)
+	assert.Error(t, err)
+
+	// Images from before perceptual hashes were calculated.
+	for _, m := range meta[:2] {
+		err = idb.db.UpdateField(m, "PerceptualHash", "")
+		assert.NoError(t, err)
+	}
+	similar, err = idb.SimilarImages(meta[0].PerceptualHash, DefaultSimilarDistance)
+	assert.NoError(t, err)
+	assert.Empty(t, similar)
+
+	n, err := idb.BackfillPerceptualHashes()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	backfilled, err := idb.ListImages()
+	assert.NoError(t, err)
+	assert.Equal(t, meta, backfilled)
+}
+
+func TestDeleteImageAndGC(t *testing.T) {
+	testIdb := newTestImageDB(t)
+	defer testIdb.Close()
+	idb := testIdb.Idb
+
+	files := []string{
+		"230700_20190519134140_1.png",
+		"230700_20190519134145_1.png",
+	}
+	for i, file := range files {
+		img := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/"+file))
+		capture, err := idb.CaptureTime(&CaptureInfo{FileName: file})
+		assert.NoError(t, err)
+		err = idb.ImportScreenshot(file, capture, i+1, img)
+		assert.NoError(t, err)
+	}
+	meta, err := idb.LookupFile(files[0])
+	assert.NoError(t, err)
+	data, err := idb.GetImageData(meta.Hash)
+	assert.NoError(t, err)
+
+	err = idb.db.SetBytes(imagesBucket, "sha256-orphan", []byte{1, 2, 3})
+	assert.NoError(t, err)
+	stats, err := idb.GC()
+	assert.NoError(t, err)
+	assert.Equal(t, &GCStats{Blobs: 1, Bytes: 3}, stats)
+	_, err = idb.GetImageData("sha256-orphan")
+	assert.Error(t, err)
+
+	// Both files have the same contents.
+	stats, err = idb.DeleteImage(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, &GCStats{}, stats)
+	_, err = idb.LookupFile(files[0])
+	assert.Error(t, err)
+	_, err = idb.GetImageData(meta.Hash)
+	assert.NoError(t, err)
+
+	stats, err = idb.DeleteImage(files[1])
+	assert.NoError(t, err)
+	assert.Equal(t, &GCStats{Blobs: 1, Bytes: int64(len(data))}, stats)
+	_, err = idb.GetImageData(meta.Hash)
+	assert.Error(t, err)
+
+	_, err = idb.DeleteImage(files[1])
+	assert.Error(t, err)
+
+	stats, err = idb.GC()
+	assert.NoError(t, err)
+	assert.Equal(t, &GCStats{}, stats)
+}
+
+func TestDeleteImageInTransaction(t *testing.T) {
+	testIdb := newTestImageDB(t)
+	defer testIdb.Close()
+	idb := testIdb.Idb
+
+	file := "230700_20190519134140_1.png"
+	img := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/"+file))
+	capture, err := idb.CaptureTime(&CaptureInfo{FileName: file})
+	assert.NoError(t, err)
+	err = idb.ImportScreenshot(file, capture, 1, img)
+	assert.NoError(t, err)
+
+	// The caller's transaction is used instead of a new one, which would
+	// block, and nothing is changed until it is committed.
+	for _, commit := range []bool{false, true} {
+		tx, err := testIdb.Db.Begin(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := idb.WithTransaction(tx.From("imagedb")).DeleteImage(file)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stats.Blobs)
+		if commit {
+			assert.NoError(t, tx.Commit())
+		} else {
+			assert.NoError(t, tx.Rollback())
+		}
+
+		_, err = idb.LookupFile(file)
+		assert.Equal(t, commit, err == storm.ErrNotFound)
+	}
+}
+
+func TestImportImage(t *testing.T) {
+	testIdb := newTestImageDB(t)
+	defer testIdb.Close()
+	idb := testIdb.Idb
+
+	file := "230700_20190519134140_1.png"
+	img := ingest.CropGameImage(testutil.LoadTestImage(t, "../testdata/screenshots/"+file))
+	data, err := encodeImage(img)
+	assert.NoError(t, err)
+	hash := calcImageHash(img)
+
+	_, err = VerifyImageData("sha256-0", data)
+	assert.EqualError(t, err, "Image sha256-0 has hash "+hash)
+
+	meta := &model.ImageMetadata{Id: 7, Hash: hash, FileName: "a.png", Record: 3}
+	stored, err := idb.ImportImage(meta, data)
+	assert.NoError(t, err)
+	assert.True(t, stored)
+	assert.Equal(t, 1, meta.Id)
+	assert.Equal(t, calcPerceptualHash(img), meta.PerceptualHash)
+
+	stored, err = idb.ImportImage(&model.ImageMetadata{Hash: hash, FileName: "b.png"}, data)
+	assert.NoError(t, err)
+	assert.False(t, stored)
+
+	images, err := idb.ImagesWithHash(hash)
+	assert.NoError(t, err)
+	assert.Len(t, images, 2)
+	stored, err = idb.ImportImage(&model.ImageMetadata{Hash: "sha256-0", FileName: "c.png"}, data)
+	assert.Error(t, err)
+}
+
+func TestBackfillCaptureSources(t *testing.T) {
+	testIdb := newTestImageDB(t)
+	defer testIdb.Close()
+	idb := testIdb.Idb
+
+	for i, source := range []model.CaptureSource{"", model.CaptureSourceUpload} {
+		meta := &model.ImageMetadata{Hash: "sha256-0", FileName: fmt.Sprintf("%d.png", i), CaptureSource: source}
+		assert.NoError(t, idb.db.Save(meta))
+	}
+
+	n, err := idb.BackfillCaptureSources()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	meta, err := idb.ListImages()
+	assert.NoError(t, err)
+	assert.Equal(t, model.CaptureSourceFileName, meta[0].CaptureSource)
+	assert.Equal(t, model.CaptureSourceUpload, meta[1].CaptureSource)
+}
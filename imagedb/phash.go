@@ -0,0 +1,91 @@
+package imagedb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"strings"
+)
+
+// Width and height of the grid of brightness gradients a perceptual hash
+// is made of.  Game images are mostly flat backgrounds with lines of small
+// text so the grid has to be fine enough for lines to land in different
+// cells.
+const dHashSize = 16
+
+// Difference in average brightness between neighbouring cells below which
+// they are considered equal.
+const dHashMargin = 2
+
+const dHashPrefix = "dhash-"
+
+// DefaultSimilarDistance is the Hamming distance between perceptual hashes
+// below which images are considered near duplicates.
+const DefaultSimilarDistance = 10
+
+// calcPerceptualHash returns a difference hash of img.  img is shrunk to a
+// (dHashSize+1) x dHashSize grid of average brightnesses and each bit is
+// whether a cell is brighter than the one to its right.  Small changes, like
+// an animated sprite or compression noise, only flip a few bits.
+func calcPerceptualHash(img image.Image) string {
+	b := img.Bounds()
+	w, h := dHashSize+1, dHashSize
+	var sums [dHashSize][dHashSize + 1]uint64
+	var counts [dHashSize][dHashSize + 1]uint64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * w / b.Dx()
+			g := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			sums[cy][cx] += uint64(g.Y)
+			counts[cy][cx]++
+		}
+	}
+
+	hash := make([]byte, dHashSize*dHashSize/8)
+	bit := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < dHashSize; x++ {
+			// Cells of flat color are only nearly equal after lossy
+			// compression so small differences don't count.
+			left := sums[y][x] * counts[y][x+1]
+			right := (sums[y][x+1] + dHashMargin*counts[y][x+1]) * counts[y][x]
+			if left > right {
+				hash[bit/8] |= 1 << uint(7-bit%8)
+			}
+			bit++
+		}
+	}
+	return dHashPrefix + hex.EncodeToString(hash)
+}
+
+func parsePerceptualHash(hash string) ([]byte, error) {
+	if !strings.HasPrefix(hash, dHashPrefix) {
+		return nil, fmt.Errorf("Bad perceptual hash %q", hash)
+	}
+	b, err := hex.DecodeString(hash[len(dHashPrefix):])
+	if err != nil || len(b) != dHashSize*dHashSize/8 {
+		return nil, fmt.Errorf("Bad perceptual hash %q", hash)
+	}
+	return b, nil
+}
+
+// HashDistance returns the Hamming distance between two perceptual hashes.
+func HashDistance(a, b string) (int, error) {
+	ab, err := parsePerceptualHash(a)
+	if err != nil {
+		return 0, err
+	}
+	bb, err := parsePerceptualHash(b)
+	if err != nil {
+		return 0, err
+	}
+
+	d := 0
+	for i := range ab {
+		d += bits.OnesCount8(ab[i] ^ bb[i])
+	}
+	return d, nil
+}
@@ -0,0 +1,72 @@
+package imagedb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+
+	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func loadGameImage(t *testing.T, name string) *image.RGBA {
+	return ingest.CropGameImage(testutil.LoadTestImage(t, "../ingest/test_data/"+name+".png"))
+}
+
+func hashDistance(t *testing.T, a, b string) int {
+	d, err := HashDistance(a, b)
+	assert.NoError(t, err)
+	return d
+}
+
+func TestCalcPerceptualHash(t *testing.T) {
+	for _, name := range []string{"classify-tent0", "classify-mailer0", "screenshot1"} {
+		img := loadGameImage(t, name)
+		hash := calcPerceptualHash(img)
+		assert.Regexp(t, "^dhash-[0-9a-f]{64}$", hash)
+
+		// A sprite moving.
+		moved := loadGameImage(t, name)
+		draw.Draw(moved, image.Rect(300, 200, 310, 210), &image.Uniform{color.RGBA{255, 0, 0, 255}},
+			image.ZP, draw.Src)
+		assert.True(t, hashDistance(t, hash, calcPerceptualHash(moved)) <= 2, name)
+
+		// Compression noise.
+		buf := &bytes.Buffer{}
+		err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 50})
+		assert.NoError(t, err)
+		jpegImg, err := jpeg.Decode(buf)
+		assert.NoError(t, err)
+		assert.True(t, hashDistance(t, hash, calcPerceptualHash(jpegImg)) <= 5, name)
+	}
+
+	// Different tablets on the same screen are not near duplicates.
+	tests := [][2]string{
+		{"classify-tent0", "classify-tent1"},
+		{"classify-mailer0", "classify-mailer1"},
+		{"screenshot1", "screenshot2"},
+	}
+	for _, test := range tests {
+		a := calcPerceptualHash(loadGameImage(t, test[0]))
+		b := calcPerceptualHash(loadGameImage(t, test[1]))
+		assert.True(t, hashDistance(t, a, b) > 2*DefaultSimilarDistance, "%v", test)
+	}
+}
+
+func TestHashDistance(t *testing.T) {
+	zero := "dhash-0000000000000000000000000000000000000000000000000000000000000000"
+	d, err := HashDistance(zero, "dhash-f000000000000000000000000000000000000000000000000000000000000003")
+	assert.NoError(t, err)
+	assert.Equal(t, 6, d)
+
+	for _, bad := range []string{"", "sha256-00", "dhash-00", "dhash-xx00000000000000000000000000000000000000000000000000000000000000"} {
+		_, err = HashDistance(zero, bad)
+		assert.Error(t, err, bad)
+		_, err = HashDistance(bad, zero)
+		assert.Error(t, err, bad)
+	}
+}
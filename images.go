@@ -0,0 +1,178 @@
+package lacodex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/anthonynsimon/bild/transform"
+	"github.com/go-zoo/bone"
+	"github.com/konkers/lacodex/imagedb"
+	"github.com/konkers/lacodex/ingest"
+)
+
+const maxImageScale = 8
+
+// Stored images are addressed by the hash of their contents so they never
+// change.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+func etagMatches(r *http.Request, etag string) bool {
+	for _, match := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		match = strings.TrimSpace(match)
+		if match == etag || match == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+type imageVariant struct {
+	crop  string
+	scale int
+}
+
+func parseImageVariant(r *http.Request) (*imageVariant, error) {
+	query := r.URL.Query()
+	v := &imageVariant{
+		crop:  query.Get("crop"),
+		scale: 1,
+	}
+
+	switch v.crop {
+	case "", "game":
+		// Images are stored cropped to the game area already.
+		v.crop = ""
+	case "content":
+	default:
+		return nil, fmt.Errorf("Unknown crop %s", v.crop)
+	}
+
+	if s := query.Get("scale"); s != "" {
+		var err error
+		v.scale, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("Bad scale %s: %v", s, err)
+		}
+	}
+	if v.scale < 1 || v.scale > maxImageScale {
+		return nil, fmt.Errorf("Scale %d out of range 1-%d", v.scale, maxImageScale)
+	}
+
+	return v, nil
+}
+
+func (v *imageVariant) isOriginal() bool {
+	return v.crop == "" && v.scale == 1
+}
+
+// etag returns a strong ETag for this variant of the image with hash.
+func (v *imageVariant) etag(hash string) string {
+	tag := hash
+	if v.crop != "" {
+		tag += "-" + v.crop
+	}
+	if v.scale != 1 {
+		tag += fmt.Sprintf("-x%d", v.scale)
+	}
+	return `"` + tag + `"`
+}
+
+func (v *imageVariant) render(img image.Image) image.Image {
+	if v.crop == "content" {
+		img = ingest.CropContentImage(img)
+	}
+	if v.scale != 1 {
+		b := img.Bounds()
+		img = transform.Resize(img, b.Dx()*v.scale, b.Dy()*v.scale, transform.NearestNeighbor)
+	}
+	return img
+}
+
+// imageHandler serves /image/:hash[?crop=content|game][&scale=n]
+func (l *LaCodex) imageHandler(w http.ResponseWriter, r *http.Request) {
+	hash := bone.GetValue(r, "hash")
+
+	v, err := parseImageVariant(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	data, err := l.idb.GetImageData(hash)
+	if err != nil {
+		httpError(w, http.StatusNotFound, "Image %s not found", hash)
+		return
+	}
+
+	etag := v.etag(hash)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", immutableCacheControl)
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if v.isOriginal() {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+		return
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't decode image %s: %v", hash, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	err = png.Encode(w, v.render(img))
+	warnIfError(err, "Can't encode image %s", hash)
+}
+
+// similarHandler serves /image/:hash/similar[?distance=n]
+//
+// Returns the images whose perceptual hashes are within distance, which
+// defaults to imagedb.DefaultSimilarDistance, of the image with hash.  The
+// image itself and other copies of it are included at distance 0.
+func (l *LaCodex) similarHandler(w http.ResponseWriter, r *http.Request) {
+	hash := bone.GetValue(r, "hash")
+
+	distance := imagedb.DefaultSimilarDistance
+	if s := r.URL.Query().Get("distance"); s != "" {
+		var err error
+		distance, err = strconv.Atoi(s)
+		if err != nil || distance < 0 {
+			httpError(w, http.StatusBadRequest, "Bad distance %s", s)
+			return
+		}
+	}
+
+	meta, err := l.idb.ImagesWithHash(hash)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't look up image %s: %v", hash, err)
+		return
+	}
+	if len(meta) == 0 {
+		httpError(w, http.StatusNotFound, "Image %s not found", hash)
+		return
+	}
+	if meta[0].PerceptualHash == "" {
+		httpError(w, http.StatusInternalServerError, "Image %s has no perceptual hash", hash)
+		return
+	}
+
+	similar, err := l.idb.SimilarImages(meta[0].PerceptualHash, distance)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't find images similar to %s: %v", hash, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(similar)
+}
@@ -0,0 +1,115 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const testImageHash = "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db"
+
+func testImageGet(t *testing.T, url string, etag string) *http.Response {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestImageHandler(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	base := fmt.Sprintf("http://%s/image/%s", tlc.l.config.ListenAddr, testImageHash)
+
+	tests := []struct {
+		query  string
+		etag   string
+		width  int
+		height int
+	}{
+		{"", `"` + testImageHash + `"`, 640, 480},
+		{"?crop=game", `"` + testImageHash + `"`, 640, 480},
+		{"?crop=content", `"` + testImageHash + `-content"`, 604, 412},
+		{"?scale=2", `"` + testImageHash + `-x2"`, 1280, 960},
+		{"?crop=content&scale=3", `"` + testImageHash + `-content-x3"`, 1812, 1236},
+	}
+
+	for _, test := range tests {
+		resp := testImageGet(t, base+test.query, "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode, test.query)
+		assert.Equal(t, test.etag, resp.Header.Get("ETag"), test.query)
+		assert.Equal(t, "image/png", resp.Header.Get("Content-Type"), test.query)
+		assert.Contains(t, resp.Header.Get("Cache-Control"), "immutable", test.query)
+
+		img, err := png.Decode(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		assert.Equal(t, test.width, img.Bounds().Dx(), test.query)
+		assert.Equal(t, test.height, img.Bounds().Dy(), test.query)
+
+		resp = testImageGet(t, base+test.query, `"other", `+test.etag)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode, test.query)
+	}
+
+	resp := testImageGet(t, base, `"other"`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	for _, query := range []string{"?crop=map", "?scale=0", "?scale=9", "?scale=x"} {
+		resp := testImageGet(t, base+query, "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	resp = testImageGet(t, fmt.Sprintf("http://%s/image/sha256-nope", tlc.l.config.ListenAddr), "")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestSimilarHandler(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134145_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+
+	base := fmt.Sprintf("http://%s/image/%s/similar", tlc.l.config.ListenAddr, testImageHash)
+
+	var similar []*model.SimilarImage
+	r := testGet(t, base)
+	err := json.Unmarshal([]byte(r), &similar)
+	assert.NoError(t, err, "Can't decode json: %s", r)
+	if assert.Len(t, similar, 2) {
+		assert.Equal(t, "230700_20190519134140_1.png", similar[0].FileName)
+		assert.Equal(t, "230700_20190519134145_1.png", similar[1].FileName)
+		assert.Equal(t, 0, similar[1].Distance)
+	}
+
+	r = testGet(t, base+"?distance=256")
+	err = json.Unmarshal([]byte(r), &similar)
+	assert.NoError(t, err, "Can't decode json: %s", r)
+	assert.Len(t, similar, 3)
+
+	testBadGet(t, base+"?distance=-1")
+	testBadGet(t, base+"?distance=x")
+	testBadGet(t, fmt.Sprintf("http://%s/image/sha256-nope/similar", tlc.l.config.ListenAddr))
+}
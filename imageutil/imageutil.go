@@ -65,6 +65,69 @@ func ImageCompare(imgA image.Image, imgB image.Image) float64 {
 	return 1.0 - float64(delta)/float64(n*3*0xff)
 }
 
+// Denoise smooths out compression noise in img.  Each opaque pixel is
+// averaged with the opaque pixels around it that are within threshold of it
+// so that edges stay sharp.  Areas of flat color are left as they are.
+func Denoise(img image.Image, threshold uint32) *image.RGBA {
+	src := AsRGBA(img)
+	dst := AsRGBA(src)
+	b := src.Bounds()
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := src.RGBAAt(x, y)
+			if c.A != 0xff {
+				continue
+			}
+
+			var r, g, bl, n uint32
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					p := image.Pt(x+dx, y+dy)
+					if !p.In(b) {
+						continue
+					}
+					nc := src.RGBAAt(p.X, p.Y)
+					if nc.A != 0xff || ColorDelta(c, nc) > threshold {
+						continue
+					}
+					r += uint32(nc.R)
+					g += uint32(nc.G)
+					bl += uint32(nc.B)
+					n++
+				}
+			}
+			// n is at least 1 as c is always averaged with itself.
+			dst.SetRGBA(x, y, color.RGBA{
+				uint8((r + n/2) / n), uint8((g + n/2) / n), uint8((bl + n/2) / n), 0xff,
+			})
+		}
+	}
+	return dst
+}
+
+// Quantize replaces colors in img that are within threshold of a color in
+// palette with the closest one.  Other colors are left as they are.
+func Quantize(img *image.RGBA, palette []color.RGBA, threshold uint32) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if c.A != 0xff {
+				continue
+			}
+
+			best := threshold + 1
+			for _, p := range palette {
+				if d := ColorDelta(c, p); d < best {
+					best = d
+					img.SetRGBA(x, y, p)
+				}
+			}
+		}
+	}
+}
+
 // OffsetRect offsets the base rectangle by offset.Min
 //
 // This is useful for cropping an image that is already cropped.
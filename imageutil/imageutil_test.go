@@ -52,3 +52,45 @@ func TestImageCompareNonRGBA(t *testing.T) {
 	draw.Draw(b, b.Bounds(), &image.Uniform{white}, image.ZP, draw.Src)
 	assert.InDelta(t, 0.0, ImageCompare(a, b), 1e-9)
 }
+
+func TestDenoise(t *testing.T) {
+	black := color.RGBA{0, 0, 0, 255}
+	white := color.RGBA{255, 255, 255, 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(img, img.Bounds(), &image.Uniform{black}, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(5, 0, 10, 10), &image.Uniform{white}, image.ZP, draw.Src)
+	img.SetRGBA(0, 0, color.RGBA{})
+	img.SetRGBA(2, 2, color.RGBA{9, 9, 9, 255})
+
+	out := Denoise(img, 30)
+	// Transparent pixels are left alone and not averaged in.
+	assert.Equal(t, color.RGBA{}, out.RGBAAt(0, 0))
+	assert.Equal(t, black, out.RGBAAt(1, 0))
+	// Noise is spread out.
+	assert.Equal(t, color.RGBA{1, 1, 1, 255}, out.RGBAAt(2, 2))
+	assert.Equal(t, color.RGBA{1, 1, 1, 255}, out.RGBAAt(3, 3))
+	// Edges are kept.
+	assert.Equal(t, black, out.RGBAAt(4, 5))
+	assert.Equal(t, white, out.RGBAAt(5, 5))
+	// Flat areas are unchanged.
+	assert.Equal(t, white, out.RGBAAt(9, 9))
+}
+
+func TestQuantize(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	palette := []color.RGBA{red, blue}
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	img.SetRGBA(0, 0, color.RGBA{245, 5, 0, 255})
+	img.SetRGBA(1, 0, color.RGBA{5, 5, 240, 255})
+	img.SetRGBA(2, 0, color.RGBA{128, 0, 128, 255})
+	img.SetRGBA(3, 0, color.RGBA{250, 0, 0, 128})
+
+	Quantize(img, palette, 30)
+	assert.Equal(t, red, img.RGBAAt(0, 0))
+	assert.Equal(t, blue, img.RGBAAt(1, 0))
+	assert.Equal(t, color.RGBA{128, 0, 128, 255}, img.RGBAAt(2, 0))
+	assert.Equal(t, color.RGBA{250, 0, 0, 128}, img.RGBAAt(3, 0))
+}
@@ -0,0 +1,207 @@
+package ingest
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/konkers/lacodex/imageutil"
+	"github.com/konkers/lacodex/model"
+)
+
+// DefaultClassifyThreshold is the score a screen needs to match if its
+// Threshold isn't set.
+const DefaultClassifyThreshold = 0.9
+
+// ClassifyScore is how well an image matched a screen type.
+type ClassifyScore struct {
+	Type      model.RecordType `json:"type"`
+	Score     float64          `json:"score"`
+	Threshold float64          `json:"threshold"`
+}
+
+// Matched returns true if the score is at or above the threshold.
+func (s ClassifyScore) Matched() bool {
+	return s.Score >= s.Threshold
+}
+
+// Classification is the result of classifying an image.
+type Classification struct {
+	// Type is the best matching screen type.  Only valid if Matched is true.
+	Type       model.RecordType `json:"type"`
+	Confidence float64          `json:"confidence"`
+	Matched    bool             `json:"matched"`
+
+	// Scores has the score of every screen type, best first.
+	Scores []ClassifyScore `json:"scores"`
+}
+
+func (c *Classification) String() string {
+	var scores []string
+	for _, s := range c.Scores {
+		name, _ := s.Type.MarshalText()
+		scores = append(scores, fmt.Sprintf("%s: %.3f/%.3f", name, s.Score, s.Threshold))
+	}
+	return strings.Join(scores, ", ")
+}
+
+// ClassificationError is returned by IngestImage when no screen type matches
+// an image.
+type ClassificationError struct {
+	Classification *Classification
+}
+
+func (e *ClassificationError) Error() string {
+	return fmt.Sprintf("Image doesn't match any screen type (%v)", e.Classification)
+}
+
+// classifyReference is a screen's reference image prepared for comparison.
+type classifyReference struct {
+	img  *image.RGBA
+	rect image.Rectangle
+}
+
+var classifyCacheMutex sync.Mutex
+var classifyCache = map[*Screen]*classifyReference{}
+
+func clearClassifyCache() {
+	classifyCacheMutex.Lock()
+	defer classifyCacheMutex.Unlock()
+
+	classifyCache = map[*Screen]*classifyReference{}
+}
+
+func (s *Screen) threshold() float64 {
+	if s.Threshold == 0 {
+		return DefaultClassifyThreshold
+	}
+	return s.Threshold
+}
+
+func (s *Screen) maskImage() (image.Image, error) {
+	if s.MaskImage != nil {
+		return s.MaskImage, nil
+	}
+
+	name := s.Mask
+	if name == "" && s.ReferenceImage != nil {
+		return nil, nil
+	}
+	if name == "" {
+		refName, err := s.referenceName()
+		if err != nil {
+			return nil, err
+		}
+		// Masks are optional unless named explicitly.
+		img, err := getReferenceImage(refName + "-mask")
+		if _, ok := err.(*referenceNotFoundError); ok {
+			return nil, nil
+		}
+		return img, err
+	}
+	return getReferenceImage(name)
+}
+
+// prepareReference applies the screen's mask to its reference image and
+// works out the region that needs comparing.  Only pixels that are opaque in
+// both the reference and the mask are compared.
+func (s *Screen) prepareReference() (*classifyReference, error) {
+	refImg, err := s.referenceImage()
+	if err != nil {
+		return nil, err
+	}
+	mask, err := s.maskImage()
+	if err != nil {
+		return nil, err
+	}
+
+	// Cleaned the same way as the images compared against it.
+	ref := cleanImage(refImg)
+	bounds := ref.Bounds()
+	if !s.ClassifyRect.Empty() {
+		bounds = s.ClassifyRect.Intersect(bounds)
+	}
+
+	var rect image.Rectangle
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if mask != nil {
+				mb := mask.Bounds()
+				_, _, _, a := mask.At(mb.Min.X+x, mb.Min.Y+y).RGBA()
+				if a == 0 {
+					ref.SetRGBA(x, y, color.RGBA{})
+				}
+			}
+			if ref.RGBAAt(x, y).A == 0xff {
+				rect = rect.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	if rect.Empty() {
+		return nil, fmt.Errorf("Nothing to compare for RecordType %d", s.Type)
+	}
+	return &classifyReference{img: ref, rect: rect}, nil
+}
+
+func (s *Screen) classifyReference() (*classifyReference, error) {
+	classifyCacheMutex.Lock()
+	defer classifyCacheMutex.Unlock()
+
+	if ref, ok := classifyCache[s]; ok {
+		return ref, nil
+	}
+
+	ref, err := s.prepareReference()
+	if err != nil {
+		return nil, err
+	}
+	classifyCache[s] = ref
+	return ref, nil
+}
+
+// classifyImage compares a cropped game image against every registered
+// screen.
+func classifyImage(img image.Image) (*Classification, error) {
+	rgba := cleanImage(img)
+
+	c := &Classification{Scores: []ClassifyScore{}}
+	for _, screen := range Screens() {
+		ref, err := screen.classifyReference()
+		if _, ok := err.(*referenceNotFoundError); ok && screen.Optional {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		score := imageutil.ImageCompare(rgba.SubImage(ref.rect), ref.img.SubImage(ref.rect))
+		c.Scores = append(c.Scores, ClassifyScore{
+			Type:      screen.Type,
+			Score:     score,
+			Threshold: screen.threshold(),
+		})
+	}
+
+	sort.SliceStable(c.Scores, func(i, j int) bool {
+		return c.Scores[i].Score > c.Scores[j].Score
+	})
+
+	for _, s := range c.Scores {
+		if s.Matched() {
+			c.Type = s.Type
+			c.Confidence = s.Score
+			c.Matched = true
+			break
+		}
+	}
+	return c, nil
+}
+
+// Classify crops img to the game area and classifies it.
+func Classify(img image.Image) (*Classification, error) {
+	return classifyImage(CropGameImage(img))
+}
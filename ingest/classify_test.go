@@ -0,0 +1,138 @@
+package ingest
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func solidImage(c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, nativeWidth, nativeHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+	return img
+}
+
+func findScore(c *Classification, t model.RecordType) *ClassifyScore {
+	for i := range c.Scores {
+		if c.Scores[i].Type == t {
+			return &c.Scores[i]
+		}
+	}
+	return nil
+}
+
+func TestClassifyMask(t *testing.T) {
+	magenta := color.RGBA{255, 0, 255, 255}
+	refImg := solidImage(magenta)
+
+	// Only the top left corner is static.
+	mask := image.NewRGBA(image.Rect(0, 0, nativeWidth, nativeHeight))
+	draw.Draw(mask, image.Rect(10, 10, 100, 100), &image.Uniform{color.White}, image.ZP, draw.Src)
+
+	custom := &Screen{
+		Type:           model.RecordType(2003),
+		ReferenceImage: refImg,
+		MaskImage:      mask,
+		Regions:        []OCRRegion{{Field: FieldText, Rect: image.Rect(100, 100, 600, 400)}},
+	}
+	err := RegisterScreen(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterScreen(custom.Type)
+
+	ref, err := custom.classifyReference()
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(10, 10, 100, 100), ref.rect)
+
+	// Content outside the mask doesn't affect the score.
+	img := solidImage(magenta)
+	draw.Draw(img, image.Rect(100, 100, 600, 400), &image.Uniform{color.White}, image.ZP, draw.Src)
+
+	c, err := classifyImage(img)
+	assert.NoError(t, err)
+	assert.True(t, c.Matched)
+	assert.Equal(t, custom.Type, c.Type)
+	assert.InDelta(t, 1.0, c.Confidence, 1e-9)
+
+	assert.True(t, sort.SliceIsSorted(c.Scores, func(i, j int) bool {
+		return c.Scores[i].Score > c.Scores[j].Score
+	}))
+	assert.NotNil(t, findScore(c, model.RecordTypeTent))
+}
+
+func TestClassifyThreshold(t *testing.T) {
+	magenta := color.RGBA{255, 0, 255, 255}
+
+	custom := &Screen{
+		Type:           model.RecordType(2004),
+		ReferenceImage: solidImage(magenta),
+		ClassifyRect:   image.Rect(0, 0, 100, 100),
+		Threshold:      0.99,
+		Regions:        []OCRRegion{{Field: FieldText, Rect: image.Rect(100, 100, 600, 400)}},
+	}
+	err := RegisterScreen(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterScreen(custom.Type)
+
+	// Change a tenth of the compared pixels.
+	img := solidImage(magenta)
+	draw.Draw(img, image.Rect(0, 0, 100, 10), &image.Uniform{color.White}, image.ZP, draw.Src)
+
+	c, err := classifyImage(img)
+	assert.NoError(t, err)
+	score := findScore(c, custom.Type)
+	if assert.NotNil(t, score) {
+		assert.InDelta(t, 1.0-0.1/3, score.Score, 1e-9)
+		assert.Equal(t, 0.99, score.Threshold)
+		assert.False(t, score.Matched())
+	}
+	assert.NotEqual(t, custom.Type, c.Type)
+
+	custom.Threshold = 0.95
+	c, err = classifyImage(img)
+	assert.NoError(t, err)
+	assert.True(t, c.Matched)
+	assert.Equal(t, custom.Type, c.Type)
+}
+
+func TestClassifyEmptyMask(t *testing.T) {
+	s := &Screen{
+		Type:           model.RecordType(2005),
+		ReferenceImage: solidImage(color.RGBA{255, 0, 255, 255}),
+		MaskImage:      image.NewRGBA(image.Rect(0, 0, nativeWidth, nativeHeight)),
+	}
+	_, err := s.prepareReference()
+	assert.Error(t, err)
+}
+
+func TestClassificationError(t *testing.T) {
+	err := &ClassificationError{Classification: &Classification{
+		Scores: []ClassifyScore{
+			{Type: model.RecordTypeMailer, Score: 0.85, Threshold: 0.9},
+			{Type: model.RecordTypeTent, Score: 0.5, Threshold: 0.9},
+		},
+	}}
+	assert.Equal(t, "Image doesn't match any screen type (mailer: 0.850/0.900, tent: 0.500/0.900)", err.Error())
+}
+
+func TestIngestClassifyError(t *testing.T) {
+	_, err := IngestImage(solidImage(color.RGBA{0, 255, 0, 255}), "")
+	ingestErr, ok := err.(*IngestError)
+	if !assert.True(t, ok, "%v is not an IngestError", err) {
+		return
+	}
+	assert.Equal(t, StageClassify, ingestErr.Stage)
+	if assert.NotNil(t, ingestErr.Classification) {
+		assert.False(t, ingestErr.Classification.Matched)
+		assert.NotEmpty(t, ingestErr.Classification.Scores)
+	}
+	assert.IsType(t, &ClassificationError{}, ingestErr.Err)
+}
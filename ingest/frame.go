@@ -0,0 +1,280 @@
+package ingest
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/anthonynsimon/bild/transform"
+	"github.com/konkers/lacodex/imageutil"
+)
+
+// Maximum ColorDelta from black of a letterbox pixel.  Kept tight so that
+// dark game content isn't mistaken for letterboxing.
+const letterboxTolerance = 12
+
+// Scales this close to a whole number are snapped to it.  The game renders
+// at whole number scales when it can and those are resampled losslessly.
+const scaleSnap = 0.01
+
+// Fractions of the image's width or height at which letterbox edges are
+// measured.
+var frameSamples = []float64{0.25, 0.375, 0.5, 0.625, 0.75}
+
+// Minimum ColorDelta between neighbouring pixels for them to be on the edge
+// between the game and a window border, title bar or bezel around it.
+const frameEdgeDelta = 60
+
+// Fraction of each side of a viewport that must be on an edge.
+const frameEdgeCoverage = 0.75
+
+// GameFrame is where the game viewport is in a screenshot.
+type GameFrame struct {
+	// Rect is the viewport in screenshot coordinates.
+	Rect image.Rectangle `json:"rect"`
+
+	// Scale is the size of the viewport relative to the native 640x480.
+	Scale float64 `json:"scale"`
+}
+
+func (f *GameFrame) String() string {
+	return fmt.Sprintf("%v scale %.3f", f.Rect, f.Scale)
+}
+
+func isLetterbox(c color.RGBA) bool {
+	return imageutil.ColorDelta(c, color.RGBA{0, 0, 0, 0xff}) <= letterboxTolerance
+}
+
+// letterboxWidth returns the median number of letterbox pixels in from one
+// edge of img.  Each sample starts at start and steps by step until it
+// leaves img.  across is the direction samples are spread along.
+func letterboxWidth(img *image.RGBA, start image.Point, step image.Point, across image.Point, length int) int {
+	b := img.Bounds()
+	var widths []int
+	for _, f := range frameSamples {
+		p := start.Add(across.Mul(int(f * float64(length))))
+		n := 0
+		for p.In(b) && isLetterbox(img.RGBAAt(p.X, p.Y)) {
+			n++
+			p = p.Add(step)
+		}
+		widths = append(widths, n)
+	}
+	sort.Ints(widths)
+	return widths[len(widths)/2]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// fitFrame returns the largest native aspect rect centred in r.
+func fitFrame(r image.Rectangle) image.Rectangle {
+	w, h := r.Dx(), r.Dy()
+	if w*nativeHeight > h*nativeWidth {
+		w = int(math.Round(float64(h) * nativeWidth / nativeHeight))
+	} else {
+		h = int(math.Round(float64(w) * nativeHeight / nativeWidth))
+	}
+	min := r.Min.Add(image.Pt((r.Dx()-w)/2, (r.Dy()-h)/2))
+	return image.Rectangle{min, min.Add(image.Pt(w, h))}
+}
+
+// letterboxContent returns what is left of img once black letterboxing is
+// trimmed from its edges.
+func letterboxContent(img *image.RGBA) image.Rectangle {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	top := letterboxWidth(img, image.Pt(0, 0), image.Pt(0, 1), image.Pt(1, 0), w)
+	bottom := letterboxWidth(img, image.Pt(0, h-1), image.Pt(0, -1), image.Pt(1, 0), w)
+	left := letterboxWidth(img, image.Pt(0, 0), image.Pt(1, 0), image.Pt(0, 1), h)
+	right := letterboxWidth(img, image.Pt(w-1, 0), image.Pt(-1, 0), image.Pt(0, 1), h)
+
+	// The game is always centred.  Dark game content can only make one side
+	// of the letterbox look wider so the narrower side is the right one.
+	dy := minInt(top, bottom)
+	dx := minInt(left, right)
+	content := image.Rect(dx, dy, w-dx, h-dy)
+	if content.Dx() < nativeWidth || content.Dy() < nativeHeight {
+		// Too much was trimmed to be letterboxing.  Screenshots of the
+		// native size, for one, have no room for it.
+		content = image.Rect(0, 0, w, h)
+	}
+	return content
+}
+
+// frameEdges records which pixels of a content rect are on a straight edge.
+// horizontal[y][x] is set if (x, y) differs from (x, y-1) and vertical[y][x]
+// if it differs from (x-1, y).  Rows and columns that have enough edge
+// pixels to be a side of a viewport are candidates.
+type frameEdges struct {
+	content    image.Rectangle
+	horizontal [][]bool
+	vertical   [][]bool
+	rows       []int
+	cols       []int
+}
+
+func findFrameEdges(img *image.RGBA, content image.Rectangle) *frameEdges {
+	e := &frameEdges{
+		content:    content,
+		horizontal: make([][]bool, content.Max.Y+1),
+		vertical:   make([][]bool, content.Max.Y+1),
+	}
+	// Lines along the far sides of content have nothing to be measured
+	// against and are left without edges.
+	for y := content.Min.Y; y <= content.Max.Y; y++ {
+		e.horizontal[y] = make([]bool, content.Max.X+1)
+		e.vertical[y] = make([]bool, content.Max.X+1)
+	}
+	colCounts := make([]int, content.Max.X+1)
+	for y := content.Min.Y; y < content.Max.Y; y++ {
+		rowCount := 0
+		for x := content.Min.X; x < content.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if y > content.Min.Y && imageutil.ColorDelta(c, img.RGBAAt(x, y-1)) >= frameEdgeDelta {
+				e.horizontal[y][x] = true
+				rowCount++
+			}
+			if x > content.Min.X && imageutil.ColorDelta(c, img.RGBAAt(x-1, y)) >= frameEdgeDelta {
+				e.vertical[y][x] = true
+				colCounts[x]++
+			}
+		}
+		if y == content.Min.Y || rowCount >= int(frameEdgeCoverage*nativeWidth) {
+			e.rows = append(e.rows, y)
+		}
+	}
+	e.rows = append(e.rows, content.Max.Y)
+
+	for x := content.Min.X; x < content.Max.X; x++ {
+		if x == content.Min.X || colCounts[x] >= int(frameEdgeCoverage*nativeHeight) {
+			e.cols = append(e.cols, x)
+		}
+	}
+	e.cols = append(e.cols, content.Max.X)
+	return e
+}
+
+// isSide returns true if enough of the line from a to b, exclusive, is on an
+// edge.
+func isSide(edges [][]bool, line int, horizontal bool, a, b int) bool {
+	n := 0
+	for i := a; i < b; i++ {
+		if horizontal && edges[line][i] || !horizontal && edges[i][line] {
+			n++
+		}
+	}
+	return float64(n) >= frameEdgeCoverage*float64(b-a)
+}
+
+// isViewport returns true if every side of r is on an edge.  A game that
+// fills the content rect from one side to the other has nothing to have an
+// edge against on those sides so they count as edges.  A game that only
+// touches one side of it doesn't, since it is more likely to be something
+// inside the game.
+func (e *frameEdges) isViewport(r image.Rectangle) bool {
+	fillsY := r.Min.Y == e.content.Min.Y && r.Max.Y == e.content.Max.Y
+	fillsX := r.Min.X == e.content.Min.X && r.Max.X == e.content.Max.X
+	return (fillsY || isSide(e.horizontal, r.Min.Y, true, r.Min.X, r.Max.X) &&
+		isSide(e.horizontal, r.Max.Y, true, r.Min.X, r.Max.X)) &&
+		(fillsX || isSide(e.vertical, r.Min.X, false, r.Min.Y, r.Max.Y) &&
+			isSide(e.vertical, r.Max.X, false, r.Min.Y, r.Max.Y))
+}
+
+// findViewport returns the largest native aspect rect in content whose
+// sides are all on straight edges, which is the game when it is surrounded
+// by a window border and title bar or a bezel.  Sizes are allowed to be a
+// pixel off for rounding at fractional scales.  Returns an empty rect if
+// there is none at least the native size.
+func findViewport(img *image.RGBA, content image.Rectangle) image.Rectangle {
+	e := findFrameEdges(img, content)
+
+	isCol := map[int]bool{}
+	for _, x := range e.cols {
+		isCol[x] = true
+	}
+
+	type span struct{ min, max int }
+	var spans []span
+	for i, top := range e.rows {
+		for _, bottom := range e.rows[i+1:] {
+			if bottom-top >= nativeHeight {
+				spans = append(spans, span{top, bottom})
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].max-spans[i].min > spans[j].max-spans[j].min
+	})
+
+	for _, s := range spans {
+		h := s.max - s.min
+		w := int(math.Round(float64(h) * nativeWidth / nativeHeight))
+		for _, left := range e.cols {
+			for _, right := range []int{left + w, left + w - 1, left + w + 1} {
+				if !isCol[right] {
+					continue
+				}
+				r := image.Rect(left, s.min, right, s.max)
+				if e.isViewport(r) {
+					return r
+				}
+			}
+		}
+	}
+	return image.Rectangle{}
+}
+
+// FindGameFrame locates the game viewport in a screenshot.  Black
+// letterboxing is measured in from each edge.  The viewport is then the
+// largest 4:3 rect in what is left whose sides are on straight edges, so
+// that window borders, title bars and bezels around the game are skipped.
+// If there is no such rect it is the largest 4:3 rect centred in what is
+// left, ignoring anything beside the viewport that isn't letterboxing, like
+// the wallpaper the game draws around it.
+func FindGameFrame(img image.Image) *GameFrame {
+	rgba := imageutil.AsRGBA(img)
+
+	content := letterboxContent(rgba)
+	rect := findViewport(rgba, content)
+	if rect.Empty() {
+		rect = fitFrame(content)
+	}
+
+	scale := float64(rect.Dx()) / nativeWidth
+	if whole := math.Round(scale); whole >= 1 && math.Abs(scale-whole) < scaleSnap*scale {
+		scale = whole
+		size := image.Pt(nativeWidth*int(whole), nativeHeight*int(whole))
+		min := rect.Min.Add(rect.Size().Sub(size).Div(2))
+		rect = image.Rectangle{min, min.Add(size)}
+	}
+
+	return &GameFrame{
+		Rect:  rect.Add(img.Bounds().Min),
+		Scale: scale,
+	}
+}
+
+// CropGameFrame crops frame out of img and resamples it to the native
+// 640x480.  Whole number scales are sampled without filtering so that pixels
+// stay sharp.
+func CropGameFrame(img image.Image, frame *GameFrame) *image.RGBA {
+	filter := transform.Box
+	if frame.Scale == math.Trunc(frame.Scale) {
+		filter = transform.NearestNeighbor
+	}
+
+	croppedImg := transform.Crop(img, frame.Rect)
+	writeIntermediateImg("cropped-frame", croppedImg)
+
+	gameImg := transform.Resize(croppedImg, nativeWidth, nativeHeight, filter)
+	writeIntermediateImg("cropped-game", gameImg)
+
+	return gameImg
+}
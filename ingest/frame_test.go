@@ -0,0 +1,159 @@
+package ingest
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindGameFrame(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame GameFrame
+	}{
+		{"screenshot1", GameFrame{image.Rect(320, 60, 1600, 1020), 2}},
+		{"screenshot2", GameFrame{image.Rect(0, 0, 640, 480), 1}},
+		{"classify-tent0", GameFrame{image.Rect(320, 60, 1600, 1020), 2}},
+		{"classify-mailer0", GameFrame{image.Rect(320, 60, 1600, 1020), 2}},
+	}
+
+	for _, test := range tests {
+		frame := FindGameFrame(loadTestImage(t, test.name))
+		assert.Equal(t, test.frame, *frame, test.name)
+	}
+}
+
+// newFramedImage returns a black width x height image with a game drawn at
+// viewport.  The game has a bright border with a dark band along the top
+// of it and wallpaper is drawn beside it to the edges of the image.
+func newFramedImage(width, height int, viewport image.Rectangle, wallpaper bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.ZP, draw.Src)
+	if wallpaper {
+		draw.Draw(img, image.Rect(0, 0, width, height), &image.Uniform{color.RGBA{40, 20, 30, 255}},
+			image.ZP, draw.Src)
+		draw.Draw(img, image.Rect(viewport.Min.X, 0, viewport.Max.X, height), &image.Uniform{color.Black},
+			image.ZP, draw.Src)
+	}
+	drawGame(img, viewport)
+	return img
+}
+
+// drawGame draws a game with a bright border and a dark band along the top
+// of it at viewport.
+func drawGame(img *image.RGBA, viewport image.Rectangle) {
+	draw.Draw(img, viewport, &image.Uniform{normalColor}, image.ZP, draw.Src)
+	draw.Draw(img, viewport.Inset(viewport.Dy()/48), &image.Uniform{glyphBackground}, image.ZP, draw.Src)
+	dark := viewport
+	dark.Max.Y = dark.Min.Y + viewport.Dy()/48
+	draw.Draw(img, dark, &image.Uniform{color.RGBA{2, 2, 2, 255}}, image.ZP, draw.Src)
+}
+
+// newWindowedImage returns a capture of a game window.  The game is drawn at
+// viewport inside a grey window border with a title bar above it that has a
+// title and buttons on it.
+func newWindowedImage(width, height int, viewport image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{90, 90, 96, 255}}, image.ZP, draw.Src)
+	titleBar := image.Rect(0, 0, width, viewport.Min.Y)
+	draw.Draw(img, titleBar, &image.Uniform{color.RGBA{210, 212, 218, 255}}, image.ZP, draw.Src)
+	text := &image.Uniform{color.RGBA{20, 20, 20, 255}}
+	for x := 12; x < 200; x += 14 {
+		draw.Draw(img, image.Rect(x, 8, x+10, titleBar.Max.Y-8), text, image.ZP, draw.Src)
+	}
+	for x := width - 90; x < width-10; x += 28 {
+		draw.Draw(img, image.Rect(x, 6, x+20, titleBar.Max.Y-6), text, image.ZP, draw.Src)
+	}
+	drawGame(img, viewport)
+	return img
+}
+
+// newBezelImage returns a width x height image with a game drawn at viewport
+// inside a bezel.  The bezel is a gradient with a logo and a light on it and
+// nothing in it is black.
+func newBezelImage(width, height int, viewport image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(40 + 100*(x+y)/(width+height))
+			img.SetRGBA(x, y, color.RGBA{v, v / 2, v / 3, 255})
+		}
+	}
+	logo := image.Rect(viewport.Min.X+40, viewport.Max.Y+10, viewport.Min.X+240, height-10)
+	draw.Draw(img, logo, &image.Uniform{color.RGBA{220, 200, 60, 255}}, image.ZP, draw.Src)
+	light := image.Rect(viewport.Max.X+20, viewport.Max.Y-40, viewport.Max.X+40, viewport.Max.Y-20)
+	draw.Draw(img, light, &image.Uniform{color.RGBA{255, 40, 40, 255}}, image.ZP, draw.Src)
+	drawGame(img, viewport)
+	return img
+}
+
+func TestFindGameFrameScaled(t *testing.T) {
+	tests := []struct {
+		width, height int
+		viewport      image.Rectangle
+		wallpaper     bool
+		scale         float64
+	}{
+		// Fractional scale, pillarboxed.
+		{1366, 768, image.Rect(171, 0, 1195, 768), false, 1.6},
+		{1366, 768, image.Rect(171, 0, 1195, 768), true, 1.6},
+		// Letterboxed on all sides.
+		{1000, 701, image.Rect(50, 13, 950, 688), false, 1.40625},
+		// A few pixels off a whole number scale.
+		{1920, 1080, image.Rect(322, 62, 1598, 1018), true, 2},
+	}
+
+	for _, test := range tests {
+		img := newFramedImage(test.width, test.height, test.viewport, test.wallpaper)
+		frame := FindGameFrame(img)
+		assert.Equal(t, test.scale, frame.Scale, "%v", test.viewport)
+		if test.scale == 2 {
+			assert.Equal(t, image.Rect(320, 60, 1600, 1020), frame.Rect)
+		} else {
+			assert.Equal(t, test.viewport, frame.Rect)
+		}
+
+		gameImg := CropGameFrame(img, frame)
+		assert.Equal(t, image.Rect(0, 0, nativeWidth, nativeHeight), gameImg.Bounds())
+		assert.Equal(t, normalColor, gameImg.RGBAAt(4, nativeHeight/2))
+		assert.Equal(t, glyphBackground, gameImg.RGBAAt(nativeWidth/2, nativeHeight/2))
+	}
+}
+
+func TestFindGameFrameOffset(t *testing.T) {
+	img := newFramedImage(1920, 1080, image.Rect(320, 60, 1600, 1020), true)
+	sub := img.SubImage(image.Rect(10, 10, 1910, 1070))
+	frame := FindGameFrame(sub)
+	assert.Equal(t, image.Rect(320, 60, 1600, 1020), frame.Rect)
+	assert.Equal(t, 2.0, frame.Scale)
+}
+
+func TestFindGameFrameSurrounded(t *testing.T) {
+	tests := []struct {
+		name     string
+		img      *image.RGBA
+		viewport image.Rectangle
+		scale    float64
+	}{
+		{"windowed 2x", newWindowedImage(1296, 999, image.Rect(8, 31, 1288, 991)), image.Rect(8, 31, 1288, 991), 2},
+		{"windowed 1x", newWindowedImage(646, 509, image.Rect(3, 26, 643, 506)), image.Rect(3, 26, 643, 506), 1},
+		{"windowed fractional", newWindowedImage(1040, 807, image.Rect(8, 31, 1032, 799)), image.Rect(8, 31, 1032, 799), 1.6},
+		{"bezel", newBezelImage(1920, 1080, image.Rect(320, 60, 1600, 1020)), image.Rect(320, 60, 1600, 1020), 2},
+		{"bezel fractional", newBezelImage(1920, 1080, image.Rect(384, 108, 1536, 972)), image.Rect(384, 108, 1536, 972), 1.8},
+	}
+
+	for _, test := range tests {
+		for _, quality := range []int{0, 50, 90} {
+			var img image.Image = test.img
+			if quality != 0 {
+				img = jpegImage(t, img, quality)
+			}
+			frame := FindGameFrame(img)
+			assert.Equal(t, test.viewport, frame.Rect, "%s at %d", test.name, quality)
+			assert.Equal(t, test.scale, frame.Scale, "%s at %d", test.name, quality)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/konkers/lacodex/imageutil"
+	"github.com/konkers/lacodex/model"
+)
+
+// Greyscale level below which a prepared pixel is ink.  ocrPrep leaves text
+// dark on a light background.
+const glyphInkThreshold = 0x80
+
+// DefaultGlyphMismatch is the fraction of a cell's pixels that may differ from
+// a glyph when an atlas doesn't set MaxMismatch.
+const DefaultGlyphMismatch = 0.05
+
+// GlyphAtlas is a bitmap font laid out on a fixed character grid.  The grid
+// starts at the top left of each layout region, so regions need to be
+// aligned to it.
+type GlyphAtlas struct {
+	// Size of a character cell in native pixels.
+	CellWidth  int `json:"cell_width"`
+	CellHeight int `json:"cell_height"`
+
+	// MaxMismatch is the fraction of a cell's pixels that may differ from a
+	// glyph for the cell to match it.  Defaults to DefaultGlyphMismatch.
+	MaxMismatch float64 `json:"max_mismatch,omitempty"`
+
+	// Language is the game build the font is from.  Defaults to English.
+	Language model.Language `json:"language,omitempty"`
+
+	Glyphs []*Glyph `json:"glyphs"`
+}
+
+// Glyph is the bitmap of one character.  Bitmap has CellHeight rows of
+// CellWidth characters, '#' for ink and '.' for background.
+type Glyph struct {
+	Rune   string   `json:"rune"`
+	Bitmap []string `json:"bitmap"`
+
+	r    rune
+	mask []bool
+}
+
+func (g *Glyph) parse(width, height int) error {
+	r, size := utf8.DecodeRuneInString(g.Rune)
+	if r == utf8.RuneError || size != len(g.Rune) {
+		return fmt.Errorf("Glyph %q is not a single character", g.Rune)
+	}
+	if len(g.Bitmap) != height {
+		return fmt.Errorf("Glyph %q has %d rows, expected %d", g.Rune, len(g.Bitmap), height)
+	}
+
+	mask := make([]bool, 0, width*height)
+	for y, row := range g.Bitmap {
+		if len(row) != width {
+			return fmt.Errorf("Glyph %q row %d has %d columns, expected %d",
+				g.Rune, y, len(row), width)
+		}
+		for _, c := range row {
+			switch c {
+			case '#':
+				mask = append(mask, true)
+			case '.':
+				mask = append(mask, false)
+			default:
+				return fmt.Errorf("Glyph %q has bad pixel %q", g.Rune, c)
+			}
+		}
+	}
+
+	g.r = r
+	g.mask = mask
+	return nil
+}
+
+func bitmapFromMask(mask []bool, width int) []string {
+	var rows []string
+	for i := 0; i < len(mask); i += width {
+		var row strings.Builder
+		for _, ink := range mask[i : i+width] {
+			if ink {
+				row.WriteByte('#')
+			} else {
+				row.WriteByte('.')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return rows
+}
+
+func (a *GlyphAtlas) validate() error {
+	if a.CellWidth <= 0 || a.CellHeight <= 0 {
+		return fmt.Errorf("Bad cell size %dx%d", a.CellWidth, a.CellHeight)
+	}
+	if a.MaxMismatch < 0 || a.MaxMismatch > 1 {
+		return fmt.Errorf("Max mismatch %v is not within 0-1", a.MaxMismatch)
+	}
+
+	seen := map[string]bool{}
+	for _, g := range a.Glyphs {
+		err := g.parse(a.CellWidth, a.CellHeight)
+		if err != nil {
+			return err
+		}
+		if seen[g.Rune] {
+			return fmt.Errorf("Duplicate glyph %q", g.Rune)
+		}
+		seen[g.Rune] = true
+	}
+	return nil
+}
+
+func (a *GlyphAtlas) maxMismatch() float64 {
+	if a.MaxMismatch == 0 {
+		return DefaultGlyphMismatch
+	}
+	return a.MaxMismatch
+}
+
+// ParseGlyphAtlas parses and validates a JSON glyph atlas.
+func ParseGlyphAtlas(data []byte) (*GlyphAtlas, error) {
+	var a GlyphAtlas
+	err := json.Unmarshal(data, &a)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// LoadGlyphAtlas loads a JSON glyph atlas from fileName.
+func LoadGlyphAtlas(fileName string) (*GlyphAtlas, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := ParseGlyphAtlas(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	return a, nil
+}
+
+// glyphGrid is a region split into character cells.
+type glyphGrid struct {
+	width, height int
+	rows, cols    int
+
+	// ink is the thresholded prepared image.
+	ink []bool
+	img *image.RGBA
+}
+
+func newGlyphGrid(img image.Image, prep PrepMode, cellWidth, cellHeight int) *glyphGrid {
+	rgba := imageutil.AsRGBA(img)
+	grey := imageutil.AsRGBA(ocrPrep(rgba, prep))
+	b := rgba.Bounds()
+
+	g := &glyphGrid{
+		width:  b.Dx(),
+		height: b.Dy(),
+		rows:   b.Dy() / cellHeight,
+		cols:   b.Dx() / cellWidth,
+		ink:    make([]bool, b.Dx()*b.Dy()),
+		img:    rgba,
+	}
+	for y := 0; y < g.height; y++ {
+		for x := 0; x < g.width; x++ {
+			g.ink[y*g.width+x] = grey.RGBAAt(x, y).R < glyphInkThreshold
+		}
+	}
+	return g
+}
+
+// cell returns the ink mask of a cell and the average color of its ink.
+// The mask is nil if the cell is empty.
+func (g *glyphGrid) cell(rect image.Rectangle) ([]bool, color.RGBA) {
+	mask := make([]bool, 0, rect.Dx()*rect.Dy())
+	var r, gr, b, n uint32
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			ink := g.ink[y*g.width+x]
+			mask = append(mask, ink)
+			if ink {
+				c := g.img.RGBAAt(x, y)
+				r += uint32(c.R)
+				gr += uint32(c.G)
+				b += uint32(c.B)
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return nil, color.RGBA{}
+	}
+	return mask, color.RGBA{uint8(r / n), uint8(gr / n), uint8(b / n), 0xff}
+}
+
+// GlyphEngine is an OCREngine that matches character cells against a
+// GlyphAtlas.  Unlike tesseract it reports the color of each glyph.
+type GlyphEngine struct {
+	atlas *GlyphAtlas
+}
+
+func NewGlyphEngine(atlas *GlyphAtlas) *GlyphEngine {
+	return &GlyphEngine{atlas: atlas}
+}
+
+// match returns the glyph closest to mask and the fraction of pixels that
+// agree.
+func (e *GlyphEngine) match(mask []bool) (*Glyph, float64) {
+	var best *Glyph
+	bestDiff := len(mask) + 1
+	for _, g := range e.atlas.Glyphs {
+		diff := 0
+		for i, ink := range mask {
+			if ink != g.mask[i] {
+				diff++
+			}
+		}
+		if diff < bestDiff {
+			best = g
+			bestDiff = diff
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	return best, 1 - float64(bestDiff)/float64(len(mask))
+}
+
+// glyphWord collects the glyphs of a word.
+type glyphWord struct {
+	text   string
+	box    image.Rectangle
+	scores []float64
+	colors []color.RGBA
+}
+
+func (w *glyphWord) add(r rune, box image.Rectangle, score float64, c color.RGBA) {
+	if w.text == "" {
+		w.box = box
+	} else {
+		w.box = w.box.Union(box)
+	}
+	w.text += string(r)
+	w.scores = append(w.scores, score)
+	w.colors = append(w.colors, c)
+}
+
+func meanConfidence(scores []float64) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, s := range scores {
+		total += s
+	}
+	return 100 * total / float64(len(scores))
+}
+
+// color returns the average color of the word's most common keyphrase type.
+// Punctuation after a keyphrase is often in the normal color so a plain
+// average would blur the two.
+func (w *glyphWord) color() color.RGBA {
+	counts := map[model.KeyphraseType]int{}
+	for _, c := range w.colors {
+		counts[colorType(c)]++
+	}
+	t := model.KeyphraseTypeNone
+	for kt, n := range counts {
+		if n > counts[t] || (n == counts[t] && kt < t) {
+			t = kt
+		}
+	}
+
+	var r, g, b, n uint32
+	for _, c := range w.colors {
+		if colorType(c) == t {
+			r += uint32(c.R)
+			g += uint32(c.G)
+			b += uint32(c.B)
+			n++
+		}
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 0xff}
+}
+
+func (e *GlyphEngine) Recognize(img image.Image, prep PrepMode, lang model.Language) (*OCRResult, error) {
+	a := e.atlas
+	if a.Language.OrDefault() != lang {
+		return nil, fmt.Errorf("Glyph atlas is for language %s, not %s", a.Language.OrDefault(), lang)
+	}
+	grid := newGlyphGrid(img, prep, a.CellWidth, a.CellHeight)
+	minScore := 1 - a.maxMismatch()
+
+	result := &OCRResult{}
+	var para *OCRBox
+	var paraScores []float64
+	endPara := func() {
+		if para == nil {
+			return
+		}
+		para.Confidence = meanConfidence(paraScores)
+		result.Paragraphs = append(result.Paragraphs, *para)
+		result.Text += para.Text + "\n"
+		para = nil
+		paraScores = nil
+	}
+
+	for row := 0; row < grid.rows; row++ {
+		var words []*glyphWord
+		var word *glyphWord
+		for col := 0; col < grid.cols; col++ {
+			rect := image.Rect(col*a.CellWidth, row*a.CellHeight,
+				(col+1)*a.CellWidth, (row+1)*a.CellHeight)
+			mask, c := grid.cell(rect)
+			if mask == nil {
+				word = nil
+				continue
+			}
+
+			g, score := e.match(mask)
+			r := utf8.RuneError
+			if g != nil && score >= minScore {
+				r = g.r
+			}
+			if word == nil {
+				word = &glyphWord{}
+				words = append(words, word)
+			}
+			word.add(r, rect, score, c)
+			result.Symbols = append(result.Symbols, OCRBox{
+				Box:        rect,
+				Text:       string(r),
+				Confidence: 100 * score,
+				Color:      &c,
+			})
+		}
+
+		if len(words) == 0 {
+			// Blank rows separate paragraphs.
+			endPara()
+			continue
+		}
+
+		line := OCRBox{Box: words[0].box}
+		var lineScores []float64
+		var texts []string
+		for _, w := range words {
+			c := w.color()
+			result.Words = append(result.Words, OCRBox{
+				Box:        w.box,
+				Text:       w.text,
+				Confidence: meanConfidence(w.scores),
+				Color:      &c,
+			})
+			line.Box = line.Box.Union(w.box)
+			lineScores = append(lineScores, w.scores...)
+			texts = append(texts, w.text)
+		}
+		line.Text = strings.Join(texts, " ") + "\n"
+		line.Confidence = meanConfidence(lineScores)
+		result.Lines = append(result.Lines, line)
+
+		if para == nil {
+			para = &OCRBox{Box: line.Box}
+		}
+		para.Box = para.Box.Union(line.Box)
+		para.Text += line.Text
+		paraScores = append(paraScores, lineScores...)
+	}
+	endPara()
+
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package ingest
+
+import (
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+
+	"github.com/anthonynsimon/bild/transform"
+	"github.com/konkers/lacodex/imageutil"
+)
+
+// GlyphSample is a labelled text region used to build a GlyphAtlas.  Text
+// has a line for each non-blank row of the character grid and a character,
+// including spaces, for each cell of that row.
+type GlyphSample struct {
+	Name  string
+	Image image.Image
+	Prep  PrepMode
+	Text  string
+}
+
+// NewGlyphSample classifies a screenshot and labels its text region with
+// text.
+func NewGlyphSample(name string, img image.Image, text string) (*GlyphSample, error) {
+	img = CropGameImage(img)
+	c, err := classifyImage(img)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Matched {
+		return nil, &ClassificationError{Classification: c}
+	}
+
+	screen := LookupScreen(c.Type)
+	for _, region := range screen.Regions {
+		if region.Field != FieldText {
+			continue
+		}
+		bounds := imageutil.OffsetRect(region.Rect, img.Bounds())
+		return &GlyphSample{
+			Name:  name,
+			Image: transform.Crop(img, bounds),
+			Prep:  screen.Prep,
+			Text:  text,
+		}, nil
+	}
+	return nil, fmt.Errorf("Screen %v has no text region", c.Type)
+}
+
+// BuildGlyphAtlas builds an atlas with cells of cellWidth x cellHeight from
+// labelled samples.  When samples disagree about the bitmap of a character
+// the most common one is used.  Disagreements and cells that don't match
+// their labels are returned as warnings.
+func BuildGlyphAtlas(cellWidth, cellHeight int, samples []*GlyphSample) (*GlyphAtlas, []string, error) {
+	if cellWidth <= 0 || cellHeight <= 0 {
+		return nil, nil, fmt.Errorf("Bad cell size %dx%d", cellWidth, cellHeight)
+	}
+
+	// Bitmap counts keyed by character.
+	bitmaps := map[rune]map[string]int{}
+	var warnings []string
+	warn := func(format string, a ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, a...))
+	}
+
+	for _, sample := range samples {
+		grid := newGlyphGrid(sample.Image, sample.Prep, cellWidth, cellHeight)
+		lines := strings.Split(strings.TrimRight(sample.Text, "\n"), "\n")
+
+		var rows [][][]bool
+		for row := 0; row < grid.rows; row++ {
+			var cells [][]bool
+			blank := true
+			for col := 0; col < grid.cols; col++ {
+				mask, _ := grid.cell(image.Rect(col*cellWidth, row*cellHeight,
+					(col+1)*cellWidth, (row+1)*cellHeight))
+				cells = append(cells, mask)
+				if mask != nil {
+					blank = false
+				}
+			}
+			if !blank {
+				rows = append(rows, cells)
+			}
+		}
+		if len(rows) != len(lines) {
+			return nil, nil, fmt.Errorf("%s has %d rows of text but is labelled with %d lines",
+				sample.Name, len(rows), len(lines))
+		}
+
+		for i, line := range lines {
+			runes := []rune(line)
+			for col, mask := range rows[i] {
+				r := ' '
+				if col < len(runes) {
+					r = runes[col]
+				}
+				switch {
+				case r == ' ' && mask != nil:
+					warn("%s line %d column %d has ink but is labelled as a space", sample.Name, i+1, col+1)
+				case r == ' ':
+				case mask == nil:
+					warn("%s line %d column %d is blank but is labelled %q", sample.Name, i+1, col+1, r)
+				default:
+					if bitmaps[r] == nil {
+						bitmaps[r] = map[string]int{}
+					}
+					bitmaps[r][strings.Join(bitmapFromMask(mask, cellWidth), "\n")]++
+				}
+			}
+			if len(runes) > len(rows[i]) {
+				warn("%s line %d is longer than the grid", sample.Name, i+1)
+			}
+		}
+	}
+
+	atlas := &GlyphAtlas{CellWidth: cellWidth, CellHeight: cellHeight}
+	for r, counts := range bitmaps {
+		best := ""
+		for bitmap, n := range counts {
+			if n > counts[best] || (n == counts[best] && bitmap < best) {
+				best = bitmap
+			}
+		}
+		if len(counts) > 1 {
+			warn("%q has %d different bitmaps, using one seen %d times", r, len(counts), counts[best])
+		}
+		atlas.Glyphs = append(atlas.Glyphs, &Glyph{
+			Rune:   string(r),
+			Bitmap: strings.Split(best, "\n"),
+		})
+	}
+	sort.Slice(atlas.Glyphs, func(i, j int) bool {
+		return atlas.Glyphs[i].Rune < atlas.Glyphs[j].Rune
+	})
+	sort.Strings(warnings)
+
+	err := atlas.validate()
+	if err != nil {
+		return nil, nil, err
+	}
+	return atlas, warnings, nil
+}
@@ -0,0 +1,194 @@
+package ingest
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAtlas = `{
+  "cell_width": 4,
+  "cell_height": 6,
+  "glyphs": [
+    {"rune": "A", "bitmap": [".#..", "#.#.", "###.", "#.#.", "#.#.", "...."]},
+    {"rune": "B", "bitmap": ["##..", "#.#.", "##..", "#.#.", "##..", "...."]},
+    {"rune": ".", "bitmap": ["....", "....", "....", "....", ".#..", "...."]}
+  ]
+}`
+
+var glyphBackground = color.RGBA{20, 30, 60, 255}
+
+func loadTestAtlas(t *testing.T) *GlyphAtlas {
+	atlas, err := ParseGlyphAtlas([]byte(testAtlas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return atlas
+}
+
+// drawGlyphs draws text starting at cell (col, row).
+func drawGlyphs(img *image.RGBA, atlas *GlyphAtlas, col, row int, text string, c color.RGBA) {
+	glyphs := map[string]*Glyph{}
+	for _, g := range atlas.Glyphs {
+		glyphs[g.Rune] = g
+	}
+
+	for i, r := range []rune(text) {
+		g := glyphs[string(r)]
+		if g == nil {
+			continue
+		}
+		for y, bitmapRow := range g.Bitmap {
+			for x, p := range bitmapRow {
+				if p == '#' {
+					img.Set((col+i)*atlas.CellWidth+x, row*atlas.CellHeight+y, c)
+				}
+			}
+		}
+	}
+}
+
+func newGlyphImage(atlas *GlyphAtlas, cols, rows int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, cols*atlas.CellWidth, rows*atlas.CellHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{glyphBackground}, image.ZP, draw.Src)
+	return img
+}
+
+func TestParseGlyphAtlasErrors(t *testing.T) {
+	tests := []string{
+		`{`,
+		`{"cell_width": 0, "cell_height": 2}`,
+		`{"cell_width": 1, "cell_height": 1, "max_mismatch": 2}`,
+		`{"cell_width": 1, "cell_height": 1, "glyphs": [{"rune": "AB", "bitmap": ["#"]}]}`,
+		`{"cell_width": 1, "cell_height": 1, "glyphs": [{"rune": "A", "bitmap": ["#", "#"]}]}`,
+		`{"cell_width": 1, "cell_height": 1, "glyphs": [{"rune": "A", "bitmap": ["##"]}]}`,
+		`{"cell_width": 1, "cell_height": 1, "glyphs": [{"rune": "A", "bitmap": ["x"]}]}`,
+		`{"cell_width": 1, "cell_height": 1, "glyphs": [{"rune": "A", "bitmap": ["#"]}, {"rune": "A", "bitmap": ["."]}]}`,
+	}
+	for _, test := range tests {
+		_, err := ParseGlyphAtlas([]byte(test))
+		assert.Error(t, err, test)
+	}
+}
+
+func TestGlyphEngine(t *testing.T) {
+	atlas := loadTestAtlas(t)
+	img := newGlyphImage(atlas, 8, 4)
+	drawGlyphs(img, atlas, 0, 0, "AB A.", normalColor)
+	drawGlyphs(img, atlas, 1, 2, "BA", blueColor)
+	drawGlyphs(img, atlas, 3, 2, ".", normalColor)
+
+	result, err := NewGlyphEngine(atlas).Recognize(img, PrepInvert, model.LanguageEnglish)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "AB A.\n\nBA.\n\n", result.Text)
+	assert.Equal(t, 2, len(result.Paragraphs))
+	assert.Equal(t, 2, len(result.Lines))
+	assert.Equal(t, image.Rect(4, 12, 16, 18), result.Lines[1].Box)
+
+	var words []string
+	for _, w := range result.Words {
+		words = append(words, w.Text)
+		assert.Equal(t, 100.0, w.Confidence, w.Text)
+	}
+	assert.Equal(t, []string{"AB", "A.", "BA."}, words)
+	assert.Equal(t, image.Rect(12, 0, 20, 6), result.Words[1].Box)
+
+	// The trailing period doesn't change the color of the word.
+	assert.Equal(t, model.KeyphraseTypeNone, colorType(*result.Words[0].Color))
+	assert.Equal(t, model.KeyphraseTypeBlue, colorType(*result.Words[2].Color))
+
+	assert.Equal(t, 7, len(result.Symbols))
+	assert.Equal(t, blueColor, *result.Symbols[4].Color)
+	assert.Equal(t, normalColor, *result.Symbols[6].Color)
+}
+
+func TestGlyphEngineUnknownGlyph(t *testing.T) {
+	atlas := loadTestAtlas(t)
+	img := newGlyphImage(atlas, 2, 1)
+	drawGlyphs(img, atlas, 0, 0, "A", normalColor)
+	draw.Draw(img, image.Rect(4, 0, 8, 6), &image.Uniform{normalColor}, image.ZP, draw.Src)
+
+	result, err := NewGlyphEngine(atlas).Recognize(img, PrepInvert, model.LanguageEnglish)
+	assert.NoError(t, err)
+	assert.Equal(t, "A"+string(utf8.RuneError), result.Words[0].Text)
+	assert.True(t, result.Symbols[1].Confidence < 100*(1-DefaultGlyphMismatch))
+}
+
+func TestGlyphEngineKeyphrases(t *testing.T) {
+	atlas := loadTestAtlas(t)
+	img := newGlyphImage(atlas, 8, 2)
+	drawGlyphs(img, atlas, 0, 0, "AB", normalColor)
+	drawGlyphs(img, atlas, 3, 0, "BA", greenColor)
+	drawGlyphs(img, atlas, 6, 0, "A.", normalColor)
+
+	prev := SetOCREngine(NewGlyphEngine(atlas))
+	defer SetOCREngine(prev)
+
+	record, err := ocrImage("ocr", img, PrepInvert, model.LanguageEnglish)
+	assert.NoError(t, err)
+	assert.Equal(t, "AB BA A.", record.Text)
+	assert.Equal(t, map[model.KeyphraseType][]string{
+		model.KeyphraseTypeGreen: []string{"BA"},
+	}, record.Keyphrases)
+	assert.Equal(t, 1, len(record.Lines))
+}
+
+func TestBuildGlyphAtlas(t *testing.T) {
+	atlas := loadTestAtlas(t)
+
+	img0 := newGlyphImage(atlas, 6, 3)
+	drawGlyphs(img0, atlas, 0, 0, "AB A.", normalColor)
+	drawGlyphs(img0, atlas, 0, 2, "B", blueColor)
+
+	img1 := newGlyphImage(atlas, 6, 1)
+	drawGlyphs(img1, atlas, 0, 0, "BA", normalColor)
+
+	built, warnings, err := BuildGlyphAtlas(4, 6, []*GlyphSample{
+		{Name: "img0", Image: img0, Prep: PrepInvert, Text: "AB A.\nB\n"},
+		{Name: "img1", Image: img1, Prep: PrepInvert, Text: "BA"},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, []string{".", "A", "B"}, glyphRunes(built))
+	for i, g := range built.Glyphs {
+		assert.Equal(t, atlas.Glyphs[(i+2)%3].Bitmap, g.Bitmap, g.Rune)
+	}
+
+	// A mislabelled cell is outvoted and reported.
+	_, warnings, err = BuildGlyphAtlas(4, 6, []*GlyphSample{
+		{Name: "img0", Image: img0, Prep: PrepInvert, Text: "AB A.\nB"},
+		{Name: "img1", Image: img1, Prep: PrepInvert, Text: "BB"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(warnings))
+	assert.True(t, strings.HasPrefix(warnings[0], `'B' has 2 different bitmaps`), warnings[0])
+
+	_, warnings, err = BuildGlyphAtlas(4, 6, []*GlyphSample{
+		{Name: "img1", Image: img1, Prep: PrepInvert, Text: "B A"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(warnings))
+
+	_, _, err = BuildGlyphAtlas(4, 6, []*GlyphSample{
+		{Name: "img0", Image: img0, Prep: PrepInvert, Text: "AB A."},
+	})
+	assert.Error(t, err)
+
+	_, _, err = BuildGlyphAtlas(0, 6, nil)
+	assert.Error(t, err)
+}
+
+func glyphRunes(atlas *GlyphAtlas) []string {
+	var runes []string
+	for _, g := range atlas.Glyphs {
+		runes = append(runes, g.Rune)
+	}
+	return runes
+}
@@ -1,11 +1,9 @@
 package ingest
 
 import (
-	"bytes"
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
 	"regexp"
 	"strconv"
 	"strings"
@@ -14,7 +12,6 @@ import (
 	"github.com/anthonynsimon/bild/transform"
 	"github.com/konkers/lacodex/imageutil"
 	"github.com/konkers/lacodex/model"
-	"github.com/otiai10/gosseract"
 )
 
 const nativeWidth = 640
@@ -25,9 +22,9 @@ const msxContentHeight = 412
 
 const confidenceThreshold = 60
 
-var normalColor = color.RGBA{230, 232, 236, 255}
-var blueColor = color.RGBA{100, 182, 227, 255}
-var greenColor = color.RGBA{96, 229, 147, 255}
+var normalColor = model.KeyphraseTypeNone.Color()
+var blueColor = model.KeyphraseTypeBlue.Color()
+var greenColor = model.KeyphraseTypeGreen.Color()
 
 var newlineRegexp = regexp.MustCompile(`\n+`)
 
@@ -39,31 +36,22 @@ func middleCrop(img image.Image, width int, height int) *image.RGBA {
 	return transform.Crop(img, cropRect)
 }
 
+// CropGameImage finds the game in a screenshot and scales it to the native
+// 640x480.
 func CropGameImage(img image.Image) *image.RGBA {
-	// First calculate the scale and crop.
-	bounds := img.Bounds()
-	scale := bounds.Dy() / nativeHeight
-
-	resizedImg := transform.Resize(img, bounds.Dx()/scale, bounds.Dy()/scale, transform.NearestNeighbor)
-	writeIntermediateImg("resized", resizedImg)
-
-	croppedGameImg := middleCrop(resizedImg, nativeWidth, nativeHeight)
-	writeIntermediateImg("cropped-game", croppedGameImg)
-
-	return croppedGameImg
+	frame := FindGameFrame(img)
+	writeIntermediateJson("frame", frame)
+	return CropGameFrame(img, frame)
 }
 
-// Takes a cropped game image.
-func msxContent(img image.Image) image.Image {
-	croppedContentImg := middleCrop(img, msxContentWidth, msxContentHeight)
-	writeIntermediateImg("cropped-content", croppedContentImg)
-
-	return croppedContentImg
+// CropContentImage crops a game image down to the MSX content area.
+func CropContentImage(img image.Image) *image.RGBA {
+	return middleCrop(img, msxContentWidth, msxContentHeight)
 }
 
 // Takes a cropped msx image
-func ocrPrep(img image.Image, recordType model.RecordType) image.Image {
-	if recordType != model.RecordTypeMailer {
+func ocrPrep(img image.Image, prep PrepMode) image.Image {
+	if prep != PrepGreyscale {
 		img = effect.Invert(img)
 		writeIntermediateImg("ocrprep-inverted", img)
 	}
@@ -71,7 +59,7 @@ func ocrPrep(img image.Image, recordType model.RecordType) image.Image {
 	greyImg := effect.Grayscale(img)
 	writeIntermediateImg("ocrprep-greyscale", greyImg)
 
-	if recordType == model.RecordTypeScanner {
+	if prep == PrepInvertThreshold {
 		b := greyImg.Bounds()
 		for y := 0; y < b.Dy(); y++ {
 			for x := 0; x < b.Dx(); x++ {
@@ -89,41 +77,8 @@ func ocrPrep(img image.Image, recordType model.RecordType) image.Image {
 	return greyImg
 }
 
-// Ignores alpha channel.
-func dominantColor(img *image.RGBA, threshold uint8) color.RGBA {
-	r := uint32(0)
-	g := uint32(0)
-	b := uint32(0)
-	n := uint32(0)
-
-	bounds := img.Bounds()
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := img.RGBAAt(x, y)
-			if c.R > threshold || c.G > threshold || c.B > threshold {
-				r += uint32(c.R)
-				g += uint32(c.G)
-				b += uint32(c.B)
-				n++
-			}
-		}
-	}
-
-	if n == 0 {
-		return color.RGBA{0, 0, 0, 0}
-	}
-
-	r /= n
-	g /= n
-	b /= n
-
-	// We don't need to clamp the uint32 -> uint8 conversions as they'll
-	// always be less than 256 after dividing by n
-	return color.RGBA{uint8(r), uint8(g), uint8(b), 0xff}
-}
-
-func wordType(img *image.RGBA) model.KeyphraseType {
-	c := dominantColor(img, 0xc0)
+// colorType returns the keyphrase type of text in color c.
+func colorType(c color.RGBA) model.KeyphraseType {
 	deltaThreshold := uint32(20)
 	switch {
 	case imageutil.ColorDelta(c, blueColor) < deltaThreshold:
@@ -133,101 +88,220 @@ func wordType(img *image.RGBA) model.KeyphraseType {
 	default:
 		return model.KeyphraseTypeNone
 	}
+}
+
+// Thresholds, as ColorDeltas, that lossy screenshots are cleaned up with.
+// Compression noise in areas of flat color is well within them.
+const (
+	denoiseThreshold  = 48
+	quantizeThreshold = 60
+)
 
+// Colors that cleanImage snaps nearby colors to.
+var cleanPalette = []color.RGBA{
+	{0, 0, 0, 0xff},
+	normalColor,
+	blueColor,
+	greenColor,
 }
 
-func getKeyphrases(client *gosseract.Client, img image.Image) (map[model.KeyphraseType][]string, error) {
-	boxes, err := client.GetBoundingBoxes(gosseract.RIL_WORD)
-	if err != nil {
-		return nil, err
+// cleanImage reduces the noise that lossy formats like JPEG add to a game
+// image so that its colors can be compared against references and keyphrase
+// colors.
+func cleanImage(img image.Image) *image.RGBA {
+	clean := imageutil.Denoise(img, denoiseThreshold)
+	imageutil.Quantize(clean, cleanPalette, quantizeThreshold)
+	return clean
+}
+
+// wordType returns the keyphrase type of the text in a word image.  Each
+// pixel that cleanImage snaps to a text color votes for that color's type.
+// Pixels on the edges of letters that lossy compression smears aren't
+// snapped so they don't pull the result towards another color.
+func wordType(img *image.RGBA) model.KeyphraseType {
+	clean := cleanImage(img)
+	votes := map[model.KeyphraseType]int{}
+	b := clean.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			switch clean.RGBAAt(x, y) {
+			case normalColor:
+				votes[model.KeyphraseTypeNone]++
+			case blueColor:
+				votes[model.KeyphraseTypeBlue]++
+			case greenColor:
+				votes[model.KeyphraseTypeGreen]++
+			}
+		}
 	}
 
+	wordType := model.KeyphraseTypeNone
+	for _, t := range []model.KeyphraseType{model.KeyphraseTypeBlue, model.KeyphraseTypeGreen} {
+		if votes[t] > votes[wordType] {
+			wordType = t
+		}
+	}
+	return wordType
+}
+
+// getWords converts the word boxes an engine found in img into words with
+// keyphrase types.  The type comes from the word's color when the engine
+// reports one.  Otherwise it is guessed from the word's pixels.
+func getWords(boxes []OCRBox, img image.Image) []*model.Word {
 	normalizedImg := imageutil.AsRGBA(img)
-	prevType := model.KeyphraseTypeNone
-	keyphrases := map[model.KeyphraseType][]string{}
+	words := []*model.Word{}
 	for i, box := range boxes {
-		wordImg := transform.Crop(normalizedImg, box.Box)
-		writeIntermediateImg(fmt.Sprintf("ocr-word-%d-%s", i, box.Word), wordImg)
-		wordType := wordType(wordImg)
+		var keyphrase model.KeyphraseType
+		if box.Color != nil {
+			keyphrase = colorType(*box.Color)
+		} else {
+			wordImg := transform.Crop(normalizedImg, box.Box)
+			writeIntermediateImg(fmt.Sprintf("ocr-word-%d-%s", i, box.Text), wordImg)
+			keyphrase = wordType(wordImg)
+		}
+		words = append(words, &model.Word{
+			Text:       box.Text,
+			Box:        model.BoxFromRect(box.Box),
+			Confidence: box.Confidence,
+			Keyphrase:  keyphrase,
+		})
+	}
+	return words
+}
 
-		trimmedWord := strings.TrimRight(box.Word, ".")
+func getKeyphrases(words []*model.Word, lang model.Language) map[model.KeyphraseType][]string {
+	sep := wordSeparator(lang)
+	prevType := model.KeyphraseTypeNone
+	keyphrases := map[model.KeyphraseType][]string{}
+	for _, word := range words {
+		wordType := word.Keyphrase
+		trimmedWord := strings.TrimRight(word.Text, ".。")
 
 		if wordType != model.KeyphraseTypeNone {
 			if wordType == prevType {
 				prevIndex := len(keyphrases[wordType]) - 1
-				keyphrases[wordType][prevIndex] = keyphrases[wordType][prevIndex] + " " + trimmedWord
+				keyphrases[wordType][prevIndex] = keyphrases[wordType][prevIndex] + sep + trimmedWord
 			} else {
 				keyphrases[wordType] = append(keyphrases[wordType], trimmedWord)
 			}
 		}
 
-		if trimmedWord != box.Word {
+		if trimmedWord != word.Text {
 			// Don't aggregate words across sentences.
 			prevType = model.KeyphraseTypeNone
 		} else {
 			prevType = wordType
 		}
 	}
-	return keyphrases, nil
+	return keyphrases
 }
 
-func ocrImage(tag string, img image.Image, recordType model.RecordType) (*model.Record, error) {
-	ocrImg := ocrPrep(img, recordType)
+func boxCenter(b model.Box) image.Point {
+	return image.Pt((b[0]+b[2])/2, (b[1]+b[3])/2)
+}
 
-	// There should be some better way to pass this image into tesseract, but
-	// I can't find one.
-	var b bytes.Buffer
-	err := png.Encode(&b, ocrImg)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to endode image to png buffer: %v ", err)
+// groupLines groups words into the text lines in lineBoxes.  Words that are
+// not inside any of paras are dropped.
+func groupLines(lineBoxes []OCRBox, words []*model.Word, paras []image.Rectangle) []*model.Line {
+	var lines []*model.Line
+	for _, box := range lineBoxes {
+		lines = append(lines, &model.Line{Box: model.BoxFromRect(box.Box)})
 	}
 
-	client := gosseract.NewClient()
-	defer client.Close()
-	client.SetImageFromBytes(b.Bytes())
+L:
+	for _, word := range words {
+		center := boxCenter(word.Box)
 
-	text := ""
-	boxes, err := client.GetBoundingBoxes(gosseract.RIL_PARA)
+		kept := false
+		for _, para := range paras {
+			if center.In(para) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			continue
+		}
+
+		for _, line := range lines {
+			if center.In(line.Box.Rect()) {
+				line.Words = append(line.Words, word)
+				continue L
+			}
+		}
+		lines = append(lines, &model.Line{Box: word.Box, Words: []*model.Word{word}})
+	}
+
+	nonEmpty := []*model.Line{}
+	for _, line := range lines {
+		if len(line.Words) > 0 {
+			nonEmpty = append(nonEmpty, line)
+		}
+	}
+	return nonEmpty
+}
+
+// offsetLines moves lines by p.
+func offsetLines(lines []*model.Line, p image.Point) {
+	offset := func(b *model.Box) {
+		*b = model.BoxFromRect(b.Rect().Add(p))
+	}
+	for _, line := range lines {
+		offset(&line.Box)
+		for _, word := range line.Words {
+			offset(&word.Box)
+		}
+	}
+}
+
+func ocrImage(tag string, img image.Image, prep PrepMode, lang model.Language) (*model.Record, error) {
+	result, err := currentOCREngine().Recognize(img, prep, lang)
 	if err != nil {
 		return nil, err
 	}
-	for _, box := range boxes {
+
+	text := ""
+	var paras []image.Rectangle
+	for _, box := range result.Paragraphs {
 		if box.Confidence > confidenceThreshold {
-			text += box.Word
+			text += box.Text
+			paras = append(paras, box.Box)
 		}
 	}
 	text = strings.TrimSpace(text)
 	text = newlineRegexp.ReplaceAllString(text, "\n")
+	if lang == model.LanguageJapanese {
+		text = removeCJKSpaces(text)
+	}
 	writeIntermediateText(tag, text)
 
 	if text == "" {
 		return nil, fmt.Errorf("No text found in image")
 	}
 
-	if text == "OK" {
+	// The English model reads the ancient glyphs of untranslated tablets as
+	// "OK".
+	if lang == model.LanguageEnglish && text == "OK" {
 		return nil, fmt.Errorf("Image is untranslated glyphs")
 	}
 
-	keyphrases, err := getKeyphrases(client, img)
-	if err != nil {
-		return nil, err
-	}
-
+	words := getWords(result.Words, img)
 	record := &model.Record{
 		Text:       text,
-		Keyphrases: keyphrases,
+		Keyphrases: getKeyphrases(words, lang),
+		Lines:      groupLines(result.Lines, words, paras),
 	}
 	return record, nil
 }
 
-func ocrTextAt(tag string, img image.Image, rect image.Rectangle, recordType model.RecordType) (*model.Record, error) {
+func ocrTextAt(tag string, img image.Image, rect image.Rectangle, prep PrepMode, lang model.Language) (*model.Record, error) {
 	bounds := imageutil.OffsetRect(rect, img.Bounds())
-	return ocrImage(tag, transform.Crop(img, bounds), recordType)
+	return ocrImage(tag, transform.Crop(img, bounds), prep, lang)
 }
 
-func ocrNumbersAt(tag string, img image.Image, rect image.Rectangle, recordType model.RecordType) (*model.Record, error) {
+func ocrNumbersAt(tag string, img image.Image, rect image.Rectangle, prep PrepMode, lang model.Language) (*model.Record, error) {
 	bounds := imageutil.OffsetRect(rect, img.Bounds())
-	record, err := ocrImage(tag, transform.Crop(img, bounds), recordType)
+	record, err := ocrImage(tag, transform.Crop(img, bounds), prep, lang)
 	if err != nil {
 		return nil, err
 	}
@@ -239,6 +313,9 @@ func ocrNumbersAt(tag string, img image.Image, rect image.Rectangle, recordType
 			return '0'
 		case 'l':
 			return '1'
+		case '０', '１', '２', '３', '４', '５', '６', '７', '８', '９':
+			// Full width digits from the Japanese model.
+			return r - '０' + '0'
 		default:
 			return r
 		}
@@ -247,107 +324,116 @@ func ocrNumbersAt(tag string, img image.Image, rect image.Rectangle, recordType
 	return record, nil
 }
 
-func ocrScanner(img image.Image) (*model.Record, error) {
-	contentImg := msxContent(img)
-	record, err := ocrImage("ocr", contentImg, model.RecordTypeScanner)
-	if err != nil {
-		return nil, err
+// ocrScreen extracts a record in lang from img using the regions in screen.
+func ocrScreen(screen *Screen, img image.Image, lang model.Language) (*model.Record, error) {
+	record := &model.Record{
+		Type:       screen.Type,
+		Keyphrases: map[model.KeyphraseType][]string{},
+		Language:   lang,
 	}
-	record.Type = model.RecordTypeScanner
 
-	writeIntermediateJson("record", record)
-	return record, nil
-}
+	for _, region := range screen.Regions {
+		tag := "ocr"
+		if region.Field != FieldText {
+			tag += "-" + string(region.Field)
+		}
 
-func ocrTent(img image.Image) (*model.Record, error) {
-	record, err := ocrTextAt("ocr", img, image.Rect(105, 125, 521, 310),
-		model.RecordTypeTent)
-	if err != nil {
-		return nil, err
+		var regionRecord *model.Record
+		var err error
+		if region.Numeric {
+			regionRecord, err = ocrNumbersAt(tag, img, region.Rect, screen.Prep, lang)
+		} else {
+			regionRecord, err = ocrTextAt(tag, img, region.Rect, screen.Prep, lang)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch region.Field {
+		case FieldText:
+			record.Text = regionRecord.Text
+			record.Keyphrases = regionRecord.Keyphrases
+			// Word boxes are relative to the region.
+			offsetLines(regionRecord.Lines, region.Rect.Min)
+			record.Lines = regionRecord.Lines
+		case FieldSubject:
+			record.Subject = regionRecord.Text
+		case FieldIndex:
+			index, err := strconv.Atoi(regionRecord.Text)
+			if err != nil {
+				return nil, err
+			}
+			record.Index = &index
+		default:
+			return nil, fmt.Errorf("Unknown field %s", region.Field)
+		}
+	}
+
+	if screen.PostProcess != nil {
+		err := screen.PostProcess(record, img)
+		if err != nil {
+			return nil, err
+		}
 	}
-	record.Type = model.RecordTypeTent
 
 	writeIntermediateJson("record", record)
 	return record, nil
 }
 
-func ocrMailer(img image.Image) (*model.Record, error) {
-	record, err := ocrTextAt("ocr", img, image.Rect(18, 178, 622, 446), model.RecordTypeMailer)
-	if err != nil {
-		return nil, err
+func ocr(recordType model.RecordType, img image.Image, lang model.Language) (*model.Record, error) {
+	screen := LookupScreen(recordType)
+	if screen == nil {
+		return nil, fmt.Errorf("Can't handle record type %d", recordType)
 	}
+	return ocrScreen(screen, img, lang)
+}
 
-	indexRecord, err := ocrNumbersAt("ocr-index", img, image.Rect(47, 74, 73, 91), model.RecordTypeMailer)
-	if err != nil {
-		return nil, err
-	}
-	index, err := strconv.Atoi(indexRecord.Text)
-	if err != nil {
-		return nil, err
-	}
-	record.Index = &index
+// Stages of IngestImage reported in IngestError.
+const (
+	StageClassify = "classify"
+	StageOCR      = "ocr"
+)
 
-	subjectRecord, err := ocrTextAt("ocr", img, image.Rect(77, 74, 523, 92), model.RecordTypeMailer)
-	if err != nil {
-		return nil, err
-	}
-	record.Subject = subjectRecord.Text
+// IngestError is returned by IngestImage when an image can't be ingested.
+type IngestError struct {
+	Stage string
 
-	record.Type = model.RecordTypeMailer
+	// Classification is set if the image got as far as being classified.
+	Classification *Classification
 
-	writeIntermediateJson("record", record)
-	return record, nil
+	Err error
 }
 
-// returns a RecordType, confidence tuple.
-func classifyImage(img image.Image) (model.RecordType, float64, error) {
-	types := []model.RecordType{
-		model.RecordTypeTent,
-		model.RecordTypeMailer,
-		model.RecordTypeScanner,
-	}
-
-	var recordType model.RecordType
-	var confidence float64
-	for _, t := range types {
-		nameB, _ := t.MarshalText()
-		name := string(nameB)
-		refImg, err := getReferenceImage(name)
-		if err != nil {
-			return model.RecordTypeTent, 0.0, err
-		}
-
-		c := imageutil.ImageCompare(img, refImg)
-		if c > confidence {
-			confidence = c
-			recordType = t
-		}
-	}
-	return recordType, confidence, nil
+func (e *IngestError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Stage, e.Err)
 }
 
-func ocr(recordType model.RecordType, img image.Image) (*model.Record, error) {
-	switch recordType {
-	case model.RecordTypeTent:
-		return ocrTent(img)
-	case model.RecordTypeScanner:
-		return ocrScanner(img)
-	case model.RecordTypeMailer:
-		return ocrMailer(img)
-	default:
-		return nil, fmt.Errorf("Can't handle record type %d", recordType)
-	}
+// IngestImage classifies and OCRs a screenshot from the lang build of the
+// game.  An empty lang is English.  Errors are returned as *IngestError.
+func IngestImage(img image.Image, lang model.Language) (*model.Record, error) {
+	return IngestGameImage(CropGameImage(img), lang)
 }
 
-func IngestImage(img image.Image) (*model.Record, error) {
-	img = CropGameImage(img)
-	recordType, confidence, err := classifyImage(img)
+// IngestGameImage is like IngestImage but takes a game image that has
+// already been cropped out of a screenshot by CropGameImage or
+// CropGameFrame, like the ones the image database stores.
+func IngestGameImage(img *image.RGBA, lang model.Language) (*model.Record, error) {
+	lang = lang.OrDefault()
+	c, err := classifyImage(img)
 	if err != nil {
-		return nil, err
+		return nil, &IngestError{Stage: StageClassify, Err: err}
 	}
-	if confidence < 0.9 {
-		return nil, fmt.Errorf("Image classification confidence %f is not high enough", confidence)
+	if !c.Matched {
+		return nil, &IngestError{
+			Stage:          StageClassify,
+			Classification: c,
+			Err:            &ClassificationError{Classification: c},
+		}
 	}
 
-	return ocr(recordType, img)
+	record, err := ocr(c.Type, img, lang)
+	if err != nil {
+		return nil, &IngestError{Stage: StageOCR, Classification: c, Err: err}
+	}
+	return record, nil
 }
@@ -1,10 +1,14 @@
 package ingest
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
 	_ "image/png" // Pull in png decoder.
 	"io/ioutil"
 	"os"
@@ -21,6 +25,9 @@ var writeIntermediates bool
 
 func init() {
 	flag.BoolVar(&writeIntermediates, "write-intermediates", false, "Write intermediates?")
+
+	// Recorded with `ingestutil process --record-ocr test_data/ocr`.
+	SetOCREngine(NewFakeOCREngine("test_data/ocr"))
 }
 
 type testImageDesc struct {
@@ -98,14 +105,70 @@ func TestClassifyImage(t *testing.T) {
 	for _, test := range tests {
 		img := loadTestImage(t, test.name)
 		gameImg := CropGameImage(img)
-		recordType, confidence, err := classifyImage(gameImg)
+		c, err := classifyImage(gameImg)
 		if err != nil {
 			t.Errorf("Failed to classify %s: %v", test.name, err)
 			continue
 		}
 
-		assert.Equal(t, recordType, test.t)
-		assert.GreaterOrEqual(t, confidence, 0.9)
+		assert.True(t, c.Matched, test.name)
+		assert.Equal(t, c.Type, test.t)
+		assert.GreaterOrEqual(t, c.Confidence, 0.95)
+	}
+}
+
+// fakeText draws a pattern of glyph sized blocks over rect.  Different seeds
+// give different "text".
+func fakeText(img *image.RGBA, rect image.Rectangle, seed int) {
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if (x/7*31+y/13*17+seed)%3 == 0 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+}
+
+func TestClassifyIgnoresText(t *testing.T) {
+	tests := []struct {
+		name string
+		t    model.RecordType
+
+		// Where the screen shows text.
+		text []image.Rectangle
+	}{
+		{"classify-tent0", model.RecordTypeTent, []image.Rectangle{
+			image.Rect(120, 135, 520, 305),
+		}},
+		{"classify-mailer0", model.RecordTypeMailer, []image.Rectangle{
+			image.Rect(47, 74, 73, 91),
+			image.Rect(77, 74, 523, 92),
+			image.Rect(18, 178, 622, 446),
+		}},
+		{"screenshot1", model.RecordTypeScanner, []image.Rectangle{
+			image.Rect(28, 45, 606, 260),
+		}},
+	}
+
+	for _, test := range tests {
+		var scores []float64
+		for seed := 0; seed < 3; seed++ {
+			gameImg := CropGameImage(loadTestImage(t, test.name))
+			for _, rect := range test.text {
+				fakeText(gameImg, rect, seed)
+			}
+			c, err := classifyImage(gameImg)
+			if err != nil {
+				t.Fatalf("Failed to classify %s: %v", test.name, err)
+			}
+			assert.True(t, c.Matched, test.name)
+			assert.Equal(t, test.t, c.Type, test.name)
+			scores = append(scores, findScore(c, test.t).Score)
+		}
+		assert.Equal(t, scores[0], scores[1], test.name)
+		assert.Equal(t, scores[0], scores[2], test.name)
 	}
 }
 
@@ -124,7 +187,7 @@ func TestIngest(t *testing.T) {
 			intermediatePrefix = "testIngest-" + name
 		}
 		img := loadTestImage(t, name)
-		record, err := IngestImage(img)
+		record, err := IngestImage(img, model.LanguageEnglish)
 		if err != nil {
 			t.Errorf("Failed to classify %s: %v", name, err)
 			return
@@ -142,10 +205,75 @@ func TestIngest(t *testing.T) {
 			continue
 		}
 
+		// Word boxes and confidences depend on the exact version of
+		// tesseract so only check that they are there.
+		assert.NotEmpty(t, record.Lines, name)
+		record.Lines = nil
+
 		assert.Equal(t, &goldRecord, record)
 	}
 }
 
+// jpegImage returns img after a round trip through JPEG at quality.
+func jpegImage(t *testing.T, img image.Image, quality int) image.Image {
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegImg, err := jpeg.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jpegImg
+}
+
+func TestIngestLossy(t *testing.T) {
+	tests := []string{
+		"classify-tent0",
+		"classify-tent1",
+		"screenshot1",
+		"screenshot2",
+		"classify-mailer0",
+		"classify-mailer1",
+		"230700_20190519134140_1",
+	}
+	for _, name := range tests {
+		img := loadTestImage(t, name)
+		record, err := IngestImage(img, model.LanguageEnglish)
+		if err != nil {
+			t.Errorf("Failed to ingest %s: %v", name, err)
+			continue
+		}
+
+		for _, quality := range []int{50, 90} {
+			gameImg := CropGameImage(jpegImage(t, img, quality))
+			c, err := classifyImage(gameImg)
+			if assert.NoError(t, err, name) {
+				assert.True(t, c.Matched, "%s at %d", name, quality)
+				assert.Equal(t, record.Type, c.Type, "%s at %d", name, quality)
+			}
+
+			// Keyphrase colors match the lossless screenshot's.
+			for _, line := range record.Lines {
+				for _, word := range line.Words {
+					wordImg := imageutil.AsRGBA(gameImg.SubImage(word.Box.Rect()))
+					assert.Equal(t, word.Keyphrase, wordType(wordImg),
+						"%s at %d: %s", name, quality, word.Text)
+				}
+			}
+		}
+	}
+}
+
+func TestCleanImageLossless(t *testing.T) {
+	// Flat colors, including ones near the keyphrase colors, are kept.
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{40, 20, 30, 255}}, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(2, 2, 6, 6), &image.Uniform{blueColor}, image.ZP, draw.Src)
+	assert.Equal(t, img, cleanImage(img))
+}
+
 func TestIngestNoRefernce(t *testing.T) {
 	img := loadTestImage(t, "classify-tent0")
 
@@ -154,7 +282,7 @@ func TestIngestNoRefernce(t *testing.T) {
 	defer os.Rename("reference0", "reference")
 
 	clearReferenceImageCache()
-	_, err := IngestImage(img)
+	_, err := IngestImage(img, "")
 	if err == nil {
 		t.Fatal("Expected error.")
 	}
@@ -162,15 +290,63 @@ func TestIngestNoRefernce(t *testing.T) {
 
 func TestIngestNoMatch(t *testing.T) {
 	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
-	_, err := IngestImage(img)
+	_, err := IngestImage(img, "")
 	if err == nil {
 		t.Fatal("Expected error.")
 	}
 }
 
 func TestOcrUnknownRecordType(t *testing.T) {
-	_, err := ocr(model.RecordType(-1), nil)
+	_, err := ocr(model.RecordType(-1), nil, model.LanguageEnglish)
 	if err == nil {
 		t.Fatal("Expected error.")
 	}
 }
+
+func testWord(text string, box model.Box, keyphrase model.KeyphraseType) *model.Word {
+	return &model.Word{Text: text, Box: box, Confidence: 90, Keyphrase: keyphrase}
+}
+
+func TestGetKeyphrases(t *testing.T) {
+	words := []*model.Word{
+		testWord("The", model.Box{}, model.KeyphraseTypeNone),
+		testWord("Ankh", model.Box{}, model.KeyphraseTypeGreen),
+		testWord("Jewel.", model.Box{}, model.KeyphraseTypeGreen),
+		testWord("Ankh", model.Box{}, model.KeyphraseTypeGreen),
+		testWord("and", model.Box{}, model.KeyphraseTypeNone),
+		testWord("guardians", model.Box{}, model.KeyphraseTypeBlue),
+	}
+
+	assert.Equal(t, map[model.KeyphraseType][]string{
+		model.KeyphraseTypeGreen: []string{"Ankh Jewel", "Ankh"},
+		model.KeyphraseTypeBlue:  []string{"guardians"},
+	}, getKeyphrases(words, model.LanguageEnglish))
+}
+
+func TestGroupLines(t *testing.T) {
+	lineBoxes := []OCRBox{
+		{Box: image.Rect(0, 0, 100, 10)},
+		{Box: image.Rect(0, 10, 100, 20)},
+		{Box: image.Rect(0, 20, 100, 30)},
+	}
+	paras := []image.Rectangle{image.Rect(0, 0, 100, 20)}
+
+	offer := testWord("Offer", model.Box{0, 0, 20, 10}, model.KeyphraseTypeNone)
+	lights := testWord("lights", model.Box{25, 0, 50, 10}, model.KeyphraseTypeBlue)
+	heavens := testWord("heavens", model.Box{0, 10, 30, 20}, model.KeyphraseTypeNone)
+	stray := testWord("stray", model.Box{100, 5, 120, 15}, model.KeyphraseTypeNone)
+	dropped := testWord("dropped", model.Box{0, 20, 30, 30}, model.KeyphraseTypeNone)
+	paras = append(paras, image.Rect(100, 0, 120, 20))
+
+	lines := groupLines(lineBoxes, []*model.Word{offer, lights, heavens, stray, dropped}, paras)
+	assert.Equal(t, []*model.Line{
+		{Box: model.Box{0, 0, 100, 10}, Words: []*model.Word{offer, lights}},
+		{Box: model.Box{0, 10, 100, 20}, Words: []*model.Word{heavens}},
+		{Box: model.Box{100, 5, 120, 15}, Words: []*model.Word{stray}},
+	}, lines)
+
+	offsetLines(lines, image.Pt(18, 34))
+	assert.Equal(t, model.Box{18, 34, 118, 44}, lines[0].Box)
+	assert.Equal(t, model.Box{43, 34, 68, 44}, lines[0].Words[1].Box)
+	assert.Equal(t, model.Box{118, 39, 138, 49}, lines[2].Words[0].Box)
+}
@@ -0,0 +1,29 @@
+package ingest
+
+import (
+	"strings"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// removeCJKSpaces removes the spaces tesseract puts between Japanese
+// characters.
+func removeCJKSpaces(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if r == ' ' && i > 0 && i < len(runes)-1 && model.IsUnspacedScript(runes[i-1]) && model.IsUnspacedScript(runes[i+1]) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// wordSeparator returns what goes between words of lang.
+func wordSeparator(lang model.Language) string {
+	if lang == model.LanguageJapanese {
+		return ""
+	}
+	return " "
+}
@@ -0,0 +1,81 @@
+package ingest
+
+import (
+	"errors"
+	"image"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveCJKSpaces(t *testing.T) {
+	assert.Equal(t, "アンクの宝石", removeCJKSpaces("ア ン ク の 宝 石"))
+	assert.Equal(t, "アンクは 8 つ。", removeCJKSpaces("ア ン ク は 8 つ 。"))
+	assert.Equal(t, "There are 8 Ankhs.", removeCJKSpaces("There are 8 Ankhs."))
+}
+
+func TestGetKeyphrasesJapanese(t *testing.T) {
+	words := []*model.Word{
+		testWord("赤い", model.Box{}, model.KeyphraseTypeNone),
+		testWord("アンク", model.Box{}, model.KeyphraseTypeGreen),
+		testWord("ジュエル。", model.Box{}, model.KeyphraseTypeGreen),
+		testWord("アンク", model.Box{}, model.KeyphraseTypeGreen),
+	}
+
+	assert.Equal(t, map[model.KeyphraseType][]string{
+		model.KeyphraseTypeGreen: []string{"アンクジュエル", "アンク"},
+	}, getKeyphrases(words, model.LanguageJapanese))
+}
+
+func TestOcrImageLanguage(t *testing.T) {
+	box := image.Rect(0, 0, 4, 4)
+	engine := &staticOCREngine{result: &OCRResult{
+		Paragraphs: []OCRBox{{Box: box, Text: "OK\n", Confidence: 90}},
+		Words:      []OCRBox{{Box: box, Text: "OK", Confidence: 90}},
+	}}
+	prev := SetOCREngine(engine)
+	defer SetOCREngine(prev)
+
+	img := image.NewRGBA(box)
+	_, err := ocrImage("ocr", img, PrepInvert, model.LanguageEnglish)
+	assert.Error(t, err)
+
+	// "OK" is only a sign of untranslated glyphs with the English model.
+	record, err := ocrImage("ocr", img, PrepInvert, model.LanguageJapanese)
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", record.Text)
+
+	engine.result.Paragraphs[0].Text = "ア ン ク\n"
+	record, err = ocrImage("ocr", img, PrepInvert, model.LanguageJapanese)
+	assert.NoError(t, err)
+	assert.Equal(t, "アンク", record.Text)
+}
+
+func TestIngestImageLanguage(t *testing.T) {
+	prev := SetOCREngine(&staticOCREngine{err: errors.New("no jpn fixtures")})
+	defer SetOCREngine(prev)
+
+	_, err := IngestImage(loadTestImage(t, "screenshot1"), model.LanguageJapanese)
+	assert.Error(t, err)
+
+	// Japanese screenshots are recorded separately from English ones.
+	SetOCREngine(NewFakeOCREngine("test_data/ocr"))
+	_, err = IngestImage(loadTestImage(t, "screenshot1"), model.LanguageJapanese)
+	assert.Error(t, err)
+	record, err := IngestImage(loadTestImage(t, "screenshot1"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, model.LanguageEnglish, record.Language)
+}
+
+func TestGlyphEngineLanguage(t *testing.T) {
+	atlas := loadTestAtlas(t)
+	img := newGlyphImage(atlas, 2, 1)
+
+	_, err := NewGlyphEngine(atlas).Recognize(img, PrepInvert, model.LanguageJapanese)
+	assert.Error(t, err)
+
+	atlas.Language = model.LanguageJapanese
+	_, err = NewGlyphEngine(atlas).Recognize(img, PrepInvert, model.LanguageJapanese)
+	assert.NoError(t, err)
+}
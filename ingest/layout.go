@@ -0,0 +1,210 @@
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// layoutFile is the format of a layout file.  See defaultLayouts for an
+// example.
+type layoutFile struct {
+	Screens []*layoutScreen `json:"screens"`
+}
+
+type layoutScreen struct {
+	Type         model.RecordType `json:"type"`
+	Reference    string           `json:"reference,omitempty"`
+	Mask         string           `json:"mask,omitempty"`
+	ClassifyRect *layoutRect      `json:"classify_rect,omitempty"`
+	Threshold    float64          `json:"threshold,omitempty"`
+	Optional     bool             `json:"optional,omitempty"`
+	Prep         PrepMode         `json:"prep"`
+	Regions      []*layoutRegion  `json:"regions"`
+}
+
+type layoutRegion struct {
+	Field   Field       `json:"field"`
+	Rect    *layoutRect `json:"rect"`
+	Numeric bool        `json:"numeric,omitempty"`
+}
+
+// layoutRect is stored as [x0, y0, x1, y1].
+type layoutRect [4]int
+
+// rect isn't canonicalized so that swapped coordinates are caught as empty
+// by validation.
+func (r *layoutRect) rect() image.Rectangle {
+	if r == nil {
+		return image.Rectangle{}
+	}
+	return image.Rectangle{Min: image.Pt(r[0], r[1]), Max: image.Pt(r[2], r[3])}
+}
+
+func (p PrepMode) MarshalText() ([]byte, error) {
+	switch p {
+	case PrepInvert:
+		return []byte("invert"), nil
+	case PrepGreyscale:
+		return []byte("greyscale"), nil
+	case PrepInvertThreshold:
+		return []byte("invert-threshold"), nil
+	}
+
+	return nil, fmt.Errorf("Unknown PrepMode %v", p)
+}
+
+func (p *PrepMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "invert":
+		*p = PrepInvert
+		return nil
+	case "greyscale":
+		*p = PrepGreyscale
+		return nil
+	case "invert-threshold":
+		*p = PrepInvertThreshold
+		return nil
+	}
+
+	return fmt.Errorf("Unknown PrepMode %s", string(text))
+}
+
+var nativeRect = image.Rect(0, 0, nativeWidth, nativeHeight)
+
+func validateRect(r image.Rectangle) error {
+	if r.Empty() {
+		return fmt.Errorf("rect %v is empty", r)
+	}
+	if !r.In(nativeRect) {
+		return fmt.Errorf("rect %v is outside of %v", r, nativeRect)
+	}
+	return nil
+}
+
+func (s *Screen) validate() error {
+	if _, err := s.Prep.MarshalText(); err != nil {
+		return err
+	}
+
+	if s.Threshold < 0 || s.Threshold > 1 {
+		return fmt.Errorf("threshold %v is outside of 0.0-1.0", s.Threshold)
+	}
+
+	if !s.ClassifyRect.Empty() {
+		if err := validateRect(s.ClassifyRect); err != nil {
+			return fmt.Errorf("classify %v", err)
+		}
+	}
+
+	seen := map[Field]bool{}
+	for i, region := range s.Regions {
+		switch region.Field {
+		case FieldText, FieldSubject, FieldIndex:
+		default:
+			return fmt.Errorf("region %d: unknown field %s", i, region.Field)
+		}
+		if seen[region.Field] {
+			return fmt.Errorf("region %d: duplicate field %s", i, region.Field)
+		}
+		seen[region.Field] = true
+
+		if err := validateRect(region.Rect); err != nil {
+			return fmt.Errorf("region %d: %v", i, err)
+		}
+	}
+
+	if !seen[FieldText] {
+		return fmt.Errorf("no %s region", FieldText)
+	}
+	return nil
+}
+
+// ParseLayouts parses and validates a layout file.
+func ParseLayouts(data []byte) ([]*Screen, error) {
+	var file layoutFile
+	err := json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	var screens []*Screen
+	for i, ls := range file.Screens {
+		s := &Screen{
+			Type:         ls.Type,
+			Reference:    ls.Reference,
+			Mask:         ls.Mask,
+			ClassifyRect: ls.ClassifyRect.rect(),
+			Threshold:    ls.Threshold,
+			Optional:     ls.Optional,
+			Prep:         ls.Prep,
+		}
+		for _, lr := range ls.Regions {
+			s.Regions = append(s.Regions, OCRRegion{
+				Field:   lr.Field,
+				Rect:    lr.Rect.rect(),
+				Numeric: lr.Numeric,
+			})
+		}
+
+		err = s.validate()
+		if err == nil && ls.ClassifyRect != nil {
+			// validate treats an empty ClassifyRect as the whole screen.
+			if rectErr := validateRect(s.ClassifyRect); rectErr != nil {
+				err = fmt.Errorf("classify %v", rectErr)
+			}
+		}
+		if err != nil {
+			name, _ := s.Type.MarshalText()
+			return nil, fmt.Errorf("screen %d (%s): %v", i, name, err)
+		}
+		screens = append(screens, s)
+	}
+	return screens, nil
+}
+
+func loadLayouts(data []byte) error {
+	layouts, err := ParseLayouts(data)
+	if err != nil {
+		return err
+	}
+
+	screensMutex.Lock()
+	defer screensMutex.Unlock()
+
+L:
+	for _, layout := range layouts {
+		for i, existing := range screens {
+			if existing.Type == layout.Type {
+				// Keep things that can't be expressed in a layout file.
+				layout.ReferenceImage = existing.ReferenceImage
+				layout.PostProcess = existing.PostProcess
+				screens[i] = layout
+				continue L
+			}
+		}
+		screens = append(screens, layout)
+	}
+
+	// Cached references are keyed by screen so old entries are dead.
+	clearClassifyCache()
+	return nil
+}
+
+// LoadLayoutFile loads a layout file.  Screens in the file replace the
+// layout of registered screens of the same type.  New types are registered.
+func LoadLayoutFile(fileName string) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	err = loadLayouts(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fileName, err)
+	}
+	return nil
+}
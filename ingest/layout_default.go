@@ -0,0 +1,43 @@
+package ingest
+
+// defaultLayouts are the layouts of the built in screens.  Rects are
+// [x0, y0, x1, y1] in native game coordinates.
+//
+// Screens are classified on static chrome away from the text: the speech
+// bubble border in the tent, the tab and title bars of the mailer and the
+// brick wall behind scanned tablets.  The thresholds sit well above the best
+// score of any other screen in test_data.
+const defaultLayouts = `{
+  "screens": [
+    {
+      "type": "tent",
+      "classify_rect": [96, 116, 546, 326],
+      "threshold": 0.95,
+      "prep": "invert",
+      "regions": [
+        {"field": "text", "rect": [105, 125, 521, 310]}
+      ]
+    },
+    {
+      "type": "mailer",
+      "classify_rect": [16, 32, 624, 72],
+      "threshold": 0.95,
+      "prep": "greyscale",
+      "regions": [
+        {"field": "text", "rect": [18, 178, 622, 446]},
+        {"field": "index", "rect": [47, 74, 73, 91], "numeric": true},
+        {"field": "subject", "rect": [77, 74, 523, 92]}
+      ]
+    },
+    {
+      "type": "scanner",
+      "classify_rect": [16, 34, 624, 447],
+      "threshold": 0.95,
+      "prep": "invert-threshold",
+      "regions": [
+        {"field": "text", "rect": [18, 34, 622, 446]}
+      ]
+    }
+  ]
+}
+`
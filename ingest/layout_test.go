@@ -0,0 +1,131 @@
+package ingest
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepMode(t *testing.T) {
+	values := []struct {
+		val PrepMode
+		enc string
+	}{
+		{PrepInvert, "invert"},
+		{PrepGreyscale, "greyscale"},
+		{PrepInvertThreshold, "invert-threshold"},
+	}
+
+	for _, v := range values {
+		enc, err := v.val.MarshalText()
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, v.enc, string(enc))
+
+		var val PrepMode
+		err = (&val).UnmarshalText([]byte(v.enc))
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, v.val, val)
+	}
+
+	v := PrepMode(-1)
+	_, err := v.MarshalText()
+	assert.Error(t, err)
+	err = (&v).UnmarshalText([]byte(""))
+	assert.Error(t, err)
+}
+
+func TestDefaultLayouts(t *testing.T) {
+	screens, err := ParseLayouts([]byte(defaultLayouts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, screens, 3)
+
+	mailer := screens[1]
+	assert.Equal(t, &Screen{
+		Type:         model.RecordTypeMailer,
+		ClassifyRect: image.Rect(16, 32, 624, 72),
+		Threshold:    0.95,
+		Prep:         PrepGreyscale,
+		Regions: []OCRRegion{
+			{Field: FieldText, Rect: image.Rect(18, 178, 622, 446)},
+			{Field: FieldIndex, Rect: image.Rect(47, 74, 73, 91), Numeric: true},
+			{Field: FieldSubject, Rect: image.Rect(77, 74, 523, 92)},
+		},
+	}, mailer)
+}
+
+func TestParseLayoutsErrors(t *testing.T) {
+	tests := []string{
+		`{`,
+		`{"screens": [{"type": "nope", "prep": "invert", "regions": [{"field": "text", "rect": [0, 0, 10, 10]}]}]}`,
+		`{"screens": [{"type": "tent", "prep": "nope", "regions": [{"field": "text", "rect": [0, 0, 10, 10]}]}]}`,
+		`{"screens": [{"type": "tent", "prep": "invert", "regions": []}]}`,
+		`{"screens": [{"type": "tent", "prep": "invert", "regions": [{"field": "text"}]}]}`,
+		`{"screens": [{"type": "tent", "prep": "invert", "regions": [{"field": "text", "rect": [10, 10, 0, 0]}]}]}`,
+		`{"screens": [{"type": "tent", "prep": "invert", "regions": [{"field": "text", "rect": [0, 0, 641, 10]}]}]}`,
+		`{"screens": [{"type": "tent", "prep": "invert", "regions": [{"field": "nope", "rect": [0, 0, 10, 10]}]}]}`,
+		`{"screens": [{"type": "tent", "prep": "invert", "regions": [{"field": "text", "rect": [0, 0, 10, 10]}, {"field": "text", "rect": [0, 0, 10, 10]}]}]}`,
+		`{"screens": [{"type": "tent", "prep": "invert", "classify_rect": [0, 0, 0, 1000], "regions": [{"field": "text", "rect": [0, 0, 10, 10]}]}]}`,
+	}
+
+	for _, test := range tests {
+		_, err := ParseLayouts([]byte(test))
+		assert.Error(t, err, test)
+	}
+}
+
+func TestLoadLayoutFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "layout.*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"screens": [{"type": "tent", "prep": "greyscale", "classify_rect": [0, 0, 640, 100],
+		"regions": [{"field": "text", "rect": [100, 120, 520, 300]}]}]}`)
+	f.Close()
+
+	original := LookupScreen(model.RecordTypeTent)
+	postProcess := func(record *model.Record, img image.Image) error { return nil }
+	original.PostProcess = postProcess
+	defer func() {
+		original.PostProcess = nil
+		loadLayouts([]byte(defaultLayouts))
+	}()
+
+	err = LoadLayoutFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tent := LookupScreen(model.RecordTypeTent)
+	assert.Equal(t, PrepGreyscale, tent.Prep)
+	assert.Equal(t, image.Rect(0, 0, 640, 100), tent.ClassifyRect)
+	assert.Equal(t, []OCRRegion{{Field: FieldText, Rect: image.Rect(100, 120, 520, 300)}}, tent.Regions)
+	assert.NotNil(t, tent.PostProcess)
+
+	// Other screens are untouched.
+	assert.Len(t, Screens(), 3)
+	assert.Equal(t, PrepGreyscale, LookupScreen(model.RecordTypeMailer).Prep)
+
+	err = LoadLayoutFile("does-not-exist.json")
+	assert.Error(t, err)
+
+	f, err = ioutil.TempFile("", "layout.*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"screens": [{"type": "tent"}]}`)
+	f.Close()
+	err = LoadLayoutFile(f.Name())
+	assert.Error(t, err)
+}
@@ -0,0 +1,59 @@
+package ingest
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// OCRBox is a piece of text found by an OCREngine.  Box is relative to the
+// recognized image.
+type OCRBox struct {
+	Box        image.Rectangle `json:"box"`
+	Text       string          `json:"text"`
+	Confidence float64         `json:"confidence"`
+
+	// Color is the color of the text if the engine knows it.
+	Color *color.RGBA `json:"color,omitempty"`
+}
+
+// OCRResult is the text an OCREngine found in an image.
+type OCRResult struct {
+	Text       string   `json:"text"`
+	Paragraphs []OCRBox `json:"paragraphs"`
+	Lines      []OCRBox `json:"lines"`
+	Words      []OCRBox `json:"words"`
+
+	// Symbols are single characters.  Not all engines report them.
+	Symbols []OCRBox `json:"symbols,omitempty"`
+}
+
+// OCREngine recognizes the text in a region of a game image.
+type OCREngine interface {
+	// Recognize returns the text in img.  prep is how the screen's layout
+	// asks for img to be prepared before recognition.  lang is never empty.
+	Recognize(img image.Image, prep PrepMode, lang model.Language) (*OCRResult, error)
+}
+
+// Guards ocrEngine.  Images are ingested from multiple goroutines.
+var ocrEngineMutex sync.RWMutex
+var ocrEngine OCREngine = TesseractEngine{}
+
+// SetOCREngine sets the engine used by IngestImage and returns the previous
+// one.
+func SetOCREngine(e OCREngine) OCREngine {
+	ocrEngineMutex.Lock()
+	defer ocrEngineMutex.Unlock()
+
+	prev := ocrEngine
+	ocrEngine = e
+	return prev
+}
+
+func currentOCREngine() OCREngine {
+	ocrEngineMutex.RLock()
+	defer ocrEngineMutex.RUnlock()
+	return ocrEngine
+}
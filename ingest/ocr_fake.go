@@ -0,0 +1,81 @@
+package ingest
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/konkers/lacodex/imageutil"
+	"github.com/konkers/lacodex/model"
+)
+
+// ocrFixtureName returns the name of the fixture file holding the result for
+// img prepared with prep and recognized as lang.
+func ocrFixtureName(img image.Image, prep PrepMode, lang model.Language) string {
+	rgba := imageutil.AsRGBA(img)
+	h := sha1.New()
+	fmt.Fprintf(h, "%d %s %dx%d\n", prep, lang, rgba.Bounds().Dx(), rgba.Bounds().Dy())
+	h.Write(rgba.Pix)
+	return fmt.Sprintf("%x.json", h.Sum(nil))
+}
+
+// FakeOCREngine is an OCREngine that returns results from fixture files in
+// Dir.  It lets tests run without tesseract.  Fixtures are written by
+// RecordingOCREngine.
+type FakeOCREngine struct {
+	Dir string
+}
+
+func NewFakeOCREngine(dir string) *FakeOCREngine {
+	return &FakeOCREngine{Dir: dir}
+}
+
+func (e *FakeOCREngine) Recognize(img image.Image, prep PrepMode, lang model.Language) (*OCRResult, error) {
+	path := filepath.Join(e.Dir, ocrFixtureName(img, prep, lang))
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("No OCR fixture for image: %v", err)
+	}
+
+	var result OCRResult
+	err = json.Unmarshal(b, &result)
+	if err != nil {
+		return nil, fmt.Errorf("Can't parse OCR fixture %s: %v", path, err)
+	}
+	return &result, nil
+}
+
+// RecordingOCREngine passes images to Engine and saves its results in Dir as
+// fixtures for FakeOCREngine.
+type RecordingOCREngine struct {
+	Engine OCREngine
+	Dir    string
+}
+
+func (e *RecordingOCREngine) Recognize(img image.Image, prep PrepMode, lang model.Language) (*OCRResult, error) {
+	result, err := e.Engine.Recognize(img, prep, lang)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(e.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(e.Dir, ocrFixtureName(img, prep, lang))
+	err = ioutil.WriteFile(path, b, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Can't write OCR fixture %s: %v", path, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticOCREngine struct {
+	result *OCRResult
+	err    error
+}
+
+func (e *staticOCREngine) Recognize(img image.Image, prep PrepMode, lang model.Language) (*OCRResult, error) {
+	return e.result, e.err
+}
+
+func TestRecordOCRFixture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocr")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	other := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	other.Set(1, 1, color.White)
+
+	result := &OCRResult{
+		Text:       "Offer\n",
+		Paragraphs: []OCRBox{{Box: image.Rect(0, 0, 4, 2), Text: "Offer\n", Confidence: 91}},
+		Lines:      []OCRBox{{Box: image.Rect(0, 0, 4, 2), Text: "Offer\n", Confidence: 91}},
+		Words:      []OCRBox{{Box: image.Rect(0, 0, 4, 2), Text: "Offer", Confidence: 90}},
+	}
+	recorder := &RecordingOCREngine{Engine: &staticOCREngine{result: result}, Dir: dir}
+	r, err := recorder.Recognize(img, PrepInvert, model.LanguageEnglish)
+	assert.NoError(t, err)
+	assert.Equal(t, result, r)
+
+	_, err = recorder.Recognize(other, PrepInvert, model.LanguageEnglish)
+	assert.NoError(t, err)
+
+	fake := NewFakeOCREngine(dir)
+	r, err = fake.Recognize(img, PrepInvert, model.LanguageEnglish)
+	assert.NoError(t, err)
+	assert.Equal(t, result, r)
+
+	// Fixtures are keyed by both the image and how it is prepared.
+	_, err = fake.Recognize(img, PrepGreyscale, model.LanguageEnglish)
+	assert.Error(t, err)
+	_, err = fake.Recognize(image.NewRGBA(image.Rect(0, 0, 4, 5)), PrepInvert, model.LanguageEnglish)
+	assert.Error(t, err)
+}
+
+func TestRecordOCRError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ocr")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recorder := &RecordingOCREngine{Engine: &staticOCREngine{err: errors.New("no tesseract")}, Dir: dir}
+	_, err = recorder.Recognize(image.NewRGBA(image.Rect(0, 0, 4, 4)), PrepInvert, model.LanguageEnglish)
+	assert.Error(t, err)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestOcrImageEngineError(t *testing.T) {
+	prev := SetOCREngine(&staticOCREngine{err: errors.New("no tesseract")})
+	defer SetOCREngine(prev)
+
+	_, err := ocrImage("ocr", image.NewRGBA(image.Rect(0, 0, 4, 4)), PrepInvert, model.LanguageEnglish)
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package ingest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/otiai10/gosseract"
+)
+
+// TesseractEngine is the OCREngine backed by tesseract.  The trained data for
+// each language it is used with needs to be installed.
+type TesseractEngine struct{}
+
+var tesseractLanguages = map[model.Language]string{
+	model.LanguageEnglish:  "eng",
+	model.LanguageJapanese: "jpn",
+}
+
+func tesseractBoxes(client *gosseract.Client, level gosseract.PageIteratorLevel) ([]OCRBox, error) {
+	boxes, err := client.GetBoundingBoxes(level)
+	if err != nil {
+		return nil, err
+	}
+
+	ocrBoxes := []OCRBox{}
+	for _, box := range boxes {
+		ocrBoxes = append(ocrBoxes, OCRBox{
+			Box:        box.Box,
+			Text:       box.Word,
+			Confidence: box.Confidence,
+		})
+	}
+	return ocrBoxes, nil
+}
+
+func (TesseractEngine) Recognize(img image.Image, prep PrepMode, lang model.Language) (*OCRResult, error) {
+	tessLang, ok := tesseractLanguages[lang]
+	if !ok {
+		return nil, fmt.Errorf("Tesseract doesn't support language %s", lang)
+	}
+
+	ocrImg := ocrPrep(img, prep)
+
+	// There should be some better way to pass this image into tesseract, but
+	// I can't find one.
+	var b bytes.Buffer
+	err := png.Encode(&b, ocrImg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to endode image to png buffer: %v ", err)
+	}
+
+	client := gosseract.NewClient()
+	defer client.Close()
+	err = client.SetLanguage(tessLang)
+	if err != nil {
+		return nil, err
+	}
+	err = client.SetImageFromBytes(b.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	result := &OCRResult{}
+	result.Text, err = client.Text()
+	if err != nil {
+		return nil, err
+	}
+	result.Paragraphs, err = tesseractBoxes(client, gosseract.RIL_PARA)
+	if err != nil {
+		return nil, err
+	}
+	result.Lines, err = tesseractBoxes(client, gosseract.RIL_TEXTLINE)
+	if err != nil {
+		return nil, err
+	}
+	result.Words, err = tesseractBoxes(client, gosseract.RIL_WORD)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
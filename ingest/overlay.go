@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/konkers/lacodex/imageutil"
+)
+
+var classifyRectColor = color.RGBA{255, 0, 0, 255}
+
+var fieldColors = map[Field]color.RGBA{
+	FieldText:    color.RGBA{0, 255, 0, 255},
+	FieldSubject: color.RGBA{0, 128, 255, 255},
+	FieldIndex:   color.RGBA{255, 255, 0, 255},
+}
+
+func drawOutline(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for x := r.Min.X; x < r.Max.X; x++ {
+		img.SetRGBA(x, r.Min.Y, c)
+		img.SetRGBA(x, r.Max.Y-1, c)
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		img.SetRGBA(r.Min.X, y, c)
+		img.SetRGBA(r.Max.X-1, y, c)
+	}
+}
+
+// DrawLayout returns a copy of a cropped game image with the classification
+// and OCR regions of screen outlined.  This is useful for checking the
+// alignment of a layout.
+func DrawLayout(img image.Image, screen *Screen) *image.RGBA {
+	out := imageutil.AsRGBA(img)
+
+	if !screen.ClassifyRect.Empty() {
+		drawOutline(out, screen.ClassifyRect, classifyRectColor)
+	}
+	for _, region := range screen.Regions {
+		drawOutline(out, region.Rect, fieldColors[region.Field])
+	}
+	return out
+}
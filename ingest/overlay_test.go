@@ -0,0 +1,29 @@
+package ingest
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrawLayout(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, nativeWidth, nativeHeight))
+	screen := &Screen{
+		ClassifyRect: image.Rect(0, 0, 10, 10),
+		Regions: []OCRRegion{
+			{Field: FieldText, Rect: image.Rect(20, 20, 30, 30)},
+			{Field: FieldIndex, Rect: image.Rect(40, 40, 50, 50)},
+		},
+	}
+
+	out := DrawLayout(img, screen)
+	assert.Equal(t, classifyRectColor, out.RGBAAt(0, 5))
+	assert.Equal(t, fieldColors[FieldText], out.RGBAAt(29, 29))
+	assert.Equal(t, fieldColors[FieldIndex], out.RGBAAt(45, 40))
+	assert.Equal(t, color.RGBA{}, out.RGBAAt(25, 25))
+
+	// The original isn't touched.
+	assert.Equal(t, color.RGBA{}, img.RGBAAt(0, 5))
+}
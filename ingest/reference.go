@@ -6,15 +6,38 @@ import (
 	_ "image/png" // Pull in png decoder.
 	"os"
 	"path/filepath"
+	"sync"
 )
 
+// Guards referenceImageCache.  Images are ingested from multiple goroutines.
+var referenceImageMutex sync.Mutex
 var referenceImageCache = map[string]image.Image{}
 
+// referenceNotFoundError is returned by getReferenceImage when no reference
+// image exists for a name.
+type referenceNotFoundError struct {
+	name  string
+	paths []string
+}
+
+func (e *referenceNotFoundError) Error() string {
+	return fmt.Sprintf("Can't find reference image for %s.  Tried: %#v",
+		e.name, e.paths)
+}
+
 func clearReferenceImageCache() {
+	clearClassifyCache()
+
+	referenceImageMutex.Lock()
+	defer referenceImageMutex.Unlock()
+
 	referenceImageCache = map[string]image.Image{}
 }
 
 func getReferenceImage(name string) (image.Image, error) {
+	referenceImageMutex.Lock()
+	defer referenceImageMutex.Unlock()
+
 	img, ok := referenceImageCache[name]
 	if ok {
 		return img, nil
@@ -44,8 +67,7 @@ func getReferenceImage(name string) (image.Image, error) {
 	}
 
 	if reader == nil {
-		return nil, fmt.Errorf("Can't find reference image for %s.  Tried: %#v",
-			name, paths)
+		return nil, &referenceNotFoundError{name: name, paths: paths}
 	}
 
 	defer reader.Close()
@@ -15,8 +15,8 @@ func TestGetReferenceImageFail(t *testing.T) {
 	defer os.Remove(badFile)
 
 	_, err = getReferenceImage("kdfjlskjfasdf")
-	if err == nil {
-		t.Error("Expected Error")
+	if _, ok := err.(*referenceNotFoundError); !ok {
+		t.Errorf("Expected referenceNotFoundError, got %v", err)
 	}
 
 	_, err = getReferenceImage("bad")
@@ -0,0 +1,176 @@
+package ingest
+
+import (
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// PrepMode selects how a region is preprocessed before OCR.
+type PrepMode int
+
+const (
+	// PrepInvert inverts and greyscales the image.  Used for light text on
+	// dark backgrounds.
+	PrepInvert PrepMode = iota
+
+	// PrepGreyscale only greyscales the image.  Used for dark text on light
+	// backgrounds.
+	PrepGreyscale
+
+	// PrepInvertThreshold is PrepInvert followed by pushing near black and
+	// near white pixels to black and white.
+	PrepInvertThreshold
+)
+
+// Field names the record field that an OCR region fills in.
+type Field string
+
+const (
+	// FieldText fills in Record.Text and Record.Keyphrases.
+	FieldText Field = "text"
+
+	// FieldSubject fills in Record.Subject.
+	FieldSubject Field = "subject"
+
+	// FieldIndex fills in Record.Index.  The region should be Numeric.
+	FieldIndex Field = "index"
+)
+
+// OCRRegion is a region of a screen that is OCRed into a record field.
+type OCRRegion struct {
+	Field Field
+
+	// Rect is in native game coordinates.
+	Rect image.Rectangle
+
+	// Numeric regions have common letter/number confusions fixed up.
+	Numeric bool
+}
+
+// Screen describes a type of screen that can be ingested.
+type Screen struct {
+	Type model.RecordType
+
+	// Reference is the name of the reference image to classify against.  It
+	// defaults to the name of Type.  ReferenceImage can be set instead to
+	// supply the image directly.
+	Reference      string
+	ReferenceImage image.Image
+
+	// Mask is the name of a mask image for Reference.  Pixels with zero
+	// alpha in the mask aren't compared when classifying so that only the
+	// static parts of the screen are looked at.  It defaults to
+	// "<reference>-mask" if that exists.  MaskImage can be set instead to
+	// supply the image directly.
+	Mask      string
+	MaskImage image.Image
+
+	// ClassifyRect restricts classification to a region of the screen.  An
+	// empty rect compares the whole screen.
+	ClassifyRect image.Rectangle
+
+	// Threshold is the classification score needed to match this screen.
+	// Defaults to DefaultClassifyThreshold.
+	Threshold float64
+
+	// Optional screens are skipped by the classifier if their reference
+	// image can't be found.
+	Optional bool
+
+	Prep    PrepMode
+	Regions []OCRRegion
+
+	// PostProcess, if not nil, is called with the record after OCR and can
+	// modify it.
+	PostProcess func(record *model.Record, img image.Image) error
+}
+
+var screensMutex sync.RWMutex
+var screens []*Screen
+
+func (s *Screen) referenceName() (string, error) {
+	if s.Reference != "" {
+		return s.Reference, nil
+	}
+
+	name, err := s.Type.MarshalText()
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+func (s *Screen) referenceImage() (image.Image, error) {
+	if s.ReferenceImage != nil {
+		return s.ReferenceImage, nil
+	}
+
+	name, err := s.referenceName()
+	if err != nil {
+		return nil, err
+	}
+	return getReferenceImage(name)
+}
+
+// RegisterScreen adds a screen type to the set that IngestImage recognizes.
+func RegisterScreen(s *Screen) error {
+	screensMutex.Lock()
+	defer screensMutex.Unlock()
+
+	for _, existing := range screens {
+		if existing.Type == s.Type {
+			return fmt.Errorf("Screen for RecordType %d already registered", s.Type)
+		}
+	}
+
+	err := s.validate()
+	if err != nil {
+		return fmt.Errorf("Screen for RecordType %d: %v", s.Type, err)
+	}
+
+	screens = append(screens, s)
+	return nil
+}
+
+func unregisterScreen(t model.RecordType) {
+	screensMutex.Lock()
+	defer screensMutex.Unlock()
+
+	for i, s := range screens {
+		if s.Type == t {
+			screens = append(screens[:i], screens[i+1:]...)
+			return
+		}
+	}
+}
+
+// Screens returns all registered screens in the order they were registered.
+func Screens() []*Screen {
+	screensMutex.RLock()
+	defer screensMutex.RUnlock()
+
+	return append([]*Screen(nil), screens...)
+}
+
+// LookupScreen returns the registered screen for t or nil if there isn't one.
+func LookupScreen(t model.RecordType) *Screen {
+	screensMutex.RLock()
+	defer screensMutex.RUnlock()
+
+	for _, s := range screens {
+		if s.Type == t {
+			return s
+		}
+	}
+	return nil
+}
+
+func init() {
+	err := loadLayouts([]byte(defaultLayouts))
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,74 @@
+package ingest
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinScreens(t *testing.T) {
+	types := []model.RecordType{}
+	for _, s := range Screens() {
+		types = append(types, s.Type)
+	}
+	assert.Equal(t, []model.RecordType{
+		model.RecordTypeTent,
+		model.RecordTypeMailer,
+		model.RecordTypeScanner,
+	}, types)
+}
+
+func TestRegisterScreenErrors(t *testing.T) {
+	err := RegisterScreen(&Screen{
+		Type:    model.RecordTypeTent,
+		Regions: []OCRRegion{{Field: FieldText}},
+	})
+	assert.Error(t, err)
+
+	err = RegisterScreen(&Screen{
+		Type:    model.RecordType(2000),
+		Regions: []OCRRegion{{Field: FieldSubject}},
+	})
+	assert.Error(t, err)
+	assert.Nil(t, LookupScreen(model.RecordType(2000)))
+}
+
+func TestRegisterCustomScreen(t *testing.T) {
+	refImg := image.NewRGBA(image.Rect(0, 0, nativeWidth, nativeHeight))
+	draw.Draw(refImg, refImg.Bounds(), &image.Uniform{color.RGBA{255, 0, 255, 255}}, image.ZP, draw.Src)
+
+	custom := &Screen{
+		Type:           model.RecordType(2001),
+		ReferenceImage: refImg,
+		ClassifyRect:   image.Rect(0, 0, 100, 100),
+		Regions:        []OCRRegion{{Field: FieldText, Rect: image.Rect(0, 0, 100, 100)}},
+	}
+	err := RegisterScreen(custom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unregisterScreen(custom.Type)
+
+	assert.Equal(t, custom, LookupScreen(custom.Type))
+
+	c, err := classifyImage(refImg)
+	assert.NoError(t, err)
+	assert.Equal(t, custom.Type, c.Type)
+	assert.InDelta(t, 1.0, c.Confidence, 1e-9)
+
+	// Built in screens still classify.
+	gameImg := CropGameImage(loadTestImage(t, "classify-tent0"))
+	c, err = classifyImage(gameImg)
+	assert.NoError(t, err)
+	assert.Equal(t, model.RecordTypeTent, c.Type)
+}
+
+func TestScreenReferenceImageUnknownType(t *testing.T) {
+	s := &Screen{Type: model.RecordType(2002)}
+	_, err := s.referenceImage()
+	assert.Error(t, err)
+}
@@ -0,0 +1,313 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/go-zoo/bone"
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/imagedb"
+	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/model"
+)
+
+// JobStatus is the state of an ingestion job.
+type JobStatus int
+
+const (
+	// JobQueued means the image is waiting for a worker.
+	JobQueued JobStatus = iota
+
+	// JobRunning means the image is being ingested.
+	JobRunning
+
+	// JobDone means the image was ingested and linked to a record.
+	JobDone
+
+	// JobFailed means the image couldn't be ingested.  If it was stored it
+	// is in the failure log and can be retried.
+	JobFailed
+)
+
+func (s JobStatus) MarshalText() ([]byte, error) {
+	switch s {
+	case JobQueued:
+		return []byte("queued"), nil
+	case JobRunning:
+		return []byte("running"), nil
+	case JobDone:
+		return []byte("done"), nil
+	case JobFailed:
+		return []byte("failed"), nil
+	}
+
+	return nil, fmt.Errorf("Unknown JobStatus %d", s)
+}
+
+func (s *JobStatus) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "queued":
+		*s = JobQueued
+	case "running":
+		*s = JobRunning
+	case "done":
+		*s = JobDone
+	case "failed":
+		*s = JobFailed
+	default:
+		return fmt.Errorf("Unknown JobStatus %s", string(text))
+	}
+	return nil
+}
+
+// Job is an image waiting to be, or that has been, ingested.
+type Job struct {
+	Id       int       `json:"id"`
+	FileName string    `json:"file_name"`
+	Status   JobStatus `json:"status"`
+
+	// Language is the game build the image is from.
+	Language model.Language `json:"language"`
+
+	// Record is the record the image was linked to once the job is done.
+	Record int `json:"record"`
+
+	// Error is why the job failed.
+	Error string `json:"error,omitempty"`
+
+	img     image.Image
+	capture *imagedb.Capture
+}
+
+// Size of the ingest queue.  Uploads are rejected when it is full.  Queued
+// images are kept in memory as they were uploaded.
+var jobQueueSize = 64
+
+// Number of finished jobs that are kept around for status queries.
+var maxFinishedJobs = 1000
+
+var errJobQueueFull = errors.New("Ingest queue is full")
+
+type jobQueue struct {
+	l *LaCodex
+
+	mutex    sync.Mutex
+	nextId   int
+	jobs     map[int]*Job
+	finished []int
+
+	queue    chan *Job
+	quit     chan struct{}
+	stopOnce sync.Once
+	workers  sync.WaitGroup
+}
+
+func newJobQueue(l *LaCodex, workers int) *jobQueue {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	q := &jobQueue{
+		l:      l,
+		nextId: 1,
+		jobs:   map[int]*Job{},
+		queue:  make(chan *Job, jobQueueSize),
+		quit:   make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go q.worker()
+	}
+	return q
+}
+
+// stop stops the workers after their current jobs are finished.  Jobs that
+// are still queued are dropped.
+func (q *jobQueue) stop() {
+	q.stopOnce.Do(func() {
+		close(q.quit)
+	})
+	q.workers.Wait()
+}
+
+// newJob returns a new job along with a copy of it that is safe to hand out.
+// capture may be nil, see addImage.
+func (q *jobQueue) newJob(img image.Image, fileName string, lang model.Language, capture *imagedb.Capture) (*Job, *Job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job := &Job{
+		Id:       q.nextId,
+		FileName: fileName,
+		Status:   JobQueued,
+		Language: lang.OrDefault(),
+		img:      img,
+		capture:  capture,
+	}
+	q.nextId++
+	q.jobs[job.Id] = job
+	return job, copyJob(job)
+}
+
+func (q *jobQueue) forget(job *Job) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.jobs, job.Id)
+}
+
+// add queues an image for ingestion.  Returns errJobQueueFull if the queue
+// is full.
+func (q *jobQueue) add(img image.Image, fileName string, lang model.Language, capture *imagedb.Capture) (*Job, error) {
+	job, c := q.newJob(img, fileName, lang, capture)
+	select {
+	case q.queue <- job:
+	default:
+		q.forget(job)
+		return nil, errJobQueueFull
+	}
+	q.publish(c)
+	return c, nil
+}
+
+// addWait is like add but waits for room in the queue.  Returns nil if the
+// queue is stopped.
+func (q *jobQueue) addWait(img image.Image, fileName string, lang model.Language, capture *imagedb.Capture) *Job {
+	job, c := q.newJob(img, fileName, lang, capture)
+	select {
+	case q.queue <- job:
+	case <-q.quit:
+		q.forget(job)
+		return nil
+	}
+	q.publish(c)
+	return c
+}
+
+// copyJob must be called with mutex held.
+func copyJob(job *Job) *Job {
+	c := *job
+	c.img = nil
+	c.capture = nil
+	return &c
+}
+
+// get returns a copy of the job with id or nil if there isn't one.
+func (q *jobQueue) get(id int) *Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil
+	}
+	return copyJob(job)
+}
+
+// list returns copies of all jobs ordered by Id.
+func (q *jobQueue) list() []*Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	jobs := []*Job{}
+	for _, job := range q.jobs {
+		jobs = append(jobs, copyJob(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Id < jobs[j].Id })
+	return jobs
+}
+
+// publish sends a copy of a job to "job" subscribers.
+func (q *jobQueue) publish(c *Job) {
+	q.l.ps.Pub(c, "job")
+}
+
+func (q *jobQueue) setStatus(job *Job, status JobStatus, record int, err error) {
+	q.mutex.Lock()
+	job.Status = status
+	job.Record = record
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if status == JobDone || status == JobFailed {
+		job.img = nil
+		q.finished = append(q.finished, job.Id)
+		for len(q.finished) > maxFinishedJobs {
+			delete(q.jobs, q.finished[0])
+			q.finished = q.finished[1:]
+		}
+	}
+	c := copyJob(job)
+	q.mutex.Unlock()
+
+	q.publish(c)
+}
+
+func (q *jobQueue) run(job *Job) {
+	q.setStatus(job, JobRunning, 0, nil)
+
+	// The game is found in the screenshot here rather than when the job is
+	// queued so that uploads don't wait on it.
+	frame := ingest.FindGameFrame(job.img)
+	glog.V(1).Infof("%s: game frame %v", job.FileName, frame)
+	gameImg := ingest.CropGameFrame(job.img, frame)
+
+	record, err := q.l.addImage(gameImg, job.FileName, job.Language, job.capture)
+	if err != nil {
+		glog.Warningf("Can't add %s: %v", job.FileName, err)
+		q.setStatus(job, JobFailed, 0, err)
+		return
+	}
+	q.setStatus(job, JobDone, record, nil)
+}
+
+func (q *jobQueue) worker() {
+	defer q.workers.Done()
+
+	for {
+		// Check quit first so that a full queue doesn't keep the worker
+		// from stopping.
+		select {
+		case <-q.quit:
+			return
+		default:
+		}
+
+		select {
+		case job := <-q.queue:
+			q.run(job)
+		case <-q.quit:
+			return
+		}
+	}
+}
+
+func (l *LaCodex) listJobs(w io.Writer) error {
+	return json.NewEncoder(w).Encode(l.jobs.list())
+}
+
+// jobHandler serves GET /job/:id
+func (l *LaCodex) jobHandler(w http.ResponseWriter, r *http.Request) {
+	idStr := bone.GetValue(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Bad job id %s: %v", idStr, err)
+		return
+	}
+
+	job := l.jobs.get(id)
+	if job == nil {
+		httpError(w, http.StatusNotFound, "No job %d", id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
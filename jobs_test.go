@@ -0,0 +1,131 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"testing"
+
+	"github.com/cskr/pubsub"
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobStatus(t *testing.T) {
+	values := []struct {
+		val JobStatus
+		enc string
+	}{
+		{JobQueued, "queued"},
+		{JobRunning, "running"},
+		{JobDone, "done"},
+		{JobFailed, "failed"},
+	}
+
+	for _, v := range values {
+		enc, err := v.val.MarshalText()
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, v.enc, string(enc))
+
+		var val JobStatus
+		err = (&val).UnmarshalText([]byte(v.enc))
+		if err != nil {
+			t.Error(err)
+		}
+		assert.Equal(t, v.val, val)
+	}
+
+	v := JobStatus(-1)
+	_, err := v.MarshalText()
+	assert.Error(t, err)
+	err = (&v).UnmarshalText([]byte(""))
+	assert.Error(t, err)
+}
+
+func TestUploadJob(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	status := tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	assert.Equal(t, http.StatusAccepted, status)
+	assert.Equal(t, &Job{
+		Id:       1,
+		FileName: "230700_20190519134140_1.png",
+		Status:   JobDone,
+		Language: model.LanguageEnglish,
+		Record:   1,
+	}, tlc.GetJob(t, 1))
+
+	// Images that can't be ingested fail with the ingestion error.
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+	job := tlc.GetJob(t, 2)
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, 0, job.Record)
+	assert.Contains(t, job.Error, "doesn't match any screen type")
+
+	// Adding it again fails too.
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+	job = tlc.GetJob(t, 3)
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, "230700_20190517185334_1.png was already added and couldn't be ingested", job.Error)
+
+	url := fmt.Sprintf("http://%s/job/", tlc.l.config.ListenAddr)
+	testBadGet(t, url+"4")
+	testBadGet(t, url+"x")
+
+	assert.Equal(t, "{\"id\":1,\"file_name\":\"230700_20190519134140_1.png\",\"status\":\"done\",\"language\":\"en\",\"record\":1}\n",
+		testGet(t, url+"1"))
+	var jobs []*Job
+	err := json.Unmarshal([]byte(testGet(t, url+"list")), &jobs)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 3)
+}
+
+// newIdleJobQueue returns a queue without any workers.
+func newIdleJobQueue(size int) *jobQueue {
+	return &jobQueue{
+		l:      &LaCodex{ps: pubsub.New(0)},
+		nextId: 1,
+		jobs:   map[int]*Job{},
+		queue:  make(chan *Job, size),
+		quit:   make(chan struct{}),
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	q := newIdleJobQueue(1)
+
+	job, err := q.add(img, "a.png", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, JobQueued, job.Status)
+
+	_, err = q.add(img, "b.png", "", nil)
+	assert.Equal(t, errJobQueueFull, err)
+	assert.Len(t, q.list(), 1)
+
+	q.stop()
+	assert.Nil(t, q.addWait(img, "c.png", "", nil))
+	assert.Len(t, q.list(), 1)
+}
+
+func TestJobPruning(t *testing.T) {
+	maxFinishedJobs = 2
+	defer func() { maxFinishedJobs = 1000 }()
+
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	q := newIdleJobQueue(3)
+	for i := 0; i < 3; i++ {
+		q.add(img, fmt.Sprintf("%d.png", i), "", nil)
+	}
+	for i := 0; i < 3; i++ {
+		q.setStatus(<-q.queue, JobDone, 0, nil)
+	}
+
+	assert.Nil(t, q.get(1))
+	assert.NotNil(t, q.get(2))
+	assert.NotNil(t, q.get(3))
+}
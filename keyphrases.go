@@ -0,0 +1,201 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-zoo/bone"
+	"github.com/konkers/lacodex/model"
+)
+
+// Keyphrase is a glossary entry for a blue or green keyphrase.
+type Keyphrase struct {
+	Phrase string `json:"phrase"`
+
+	// Types are the colors the phrase is highlighted in.  Usually only one.
+	Types []model.KeyphraseType `json:"types"`
+
+	// Records are the Ids of records that have the phrase as a keyphrase.
+	Records []int `json:"records"`
+
+	// Mentions are the Ids of other records whose text contains the phrase.
+	Mentions []int `json:"mentions"`
+}
+
+// containsPhrase returns true if text contains phrase as whole words.  Both
+// must be normalized with normalizeText.
+func containsPhrase(text, phrase string) bool {
+	if strings.IndexFunc(phrase, model.IsUnspacedScript) >= 0 {
+		return strings.Contains(text, phrase)
+	}
+	return strings.Contains(" "+text+" ", " "+phrase+" ")
+}
+
+func hasKeyphraseType(types []model.KeyphraseType, t model.KeyphraseType) bool {
+	for _, existing := range types {
+		if existing == t {
+			return true
+		}
+	}
+	return false
+}
+
+// buildGlossary returns every distinct keyphrase in records ordered by
+// phrase.  Phrases are matched case insensitively and across line breaks.
+func buildGlossary(records []*model.Record) []*Keyphrase {
+	entries := map[string]*Keyphrase{}
+	var keys []string
+	for _, record := range records {
+		for _, t := range []model.KeyphraseType{model.KeyphraseTypeBlue, model.KeyphraseTypeGreen} {
+			for _, phrase := range record.Keyphrases[t] {
+				key := normalizeText(phrase)
+				if key == "" {
+					continue
+				}
+				e, ok := entries[key]
+				if !ok {
+					e = &Keyphrase{
+						Phrase:   strings.Join(strings.Fields(phrase), " "),
+						Records:  []int{},
+						Mentions: []int{},
+					}
+					entries[key] = e
+					keys = append(keys, key)
+				}
+				e.Records = addId(e.Records, record.Id)
+				if !hasKeyphraseType(e.Types, t) {
+					e.Types = append(e.Types, t)
+				}
+			}
+		}
+	}
+
+	texts := make([]string, len(records))
+	for i, record := range records {
+		texts[i] = normalizeText(record.Subject + "\n" + record.Text)
+	}
+	for _, key := range keys {
+		e := entries[key]
+		sort.Ints(e.Records)
+		sort.Slice(e.Types, func(i, j int) bool { return e.Types[i] < e.Types[j] })
+		for i, record := range records {
+			j := sort.SearchInts(e.Records, record.Id)
+			if j < len(e.Records) && e.Records[j] == record.Id {
+				continue
+			}
+			if containsPhrase(texts[i], key) {
+				e.Mentions = append(e.Mentions, record.Id)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	glossary := make([]*Keyphrase, len(keys))
+	for i, key := range keys {
+		glossary[i] = entries[key]
+	}
+	return glossary
+}
+
+// glossaryCache holds the glossary until records change.
+type glossaryCache struct {
+	mutex    sync.Mutex
+	gen      int
+	glossary []*Keyphrase
+}
+
+// invalidate drops the cached glossary.  Glossaries being built from records
+// read before the call aren't cached.
+func (c *glossaryCache) invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.gen++
+	c.glossary = nil
+}
+
+// glossary returns the glossary of all records.  It is only rebuilt after
+// publishUpdate.  The result is shared and must not be modified.
+func (l *LaCodex) glossary() ([]*Keyphrase, error) {
+	c := l.glossaryCache
+	c.mutex.Lock()
+	glossary, gen := c.glossary, c.gen
+	c.mutex.Unlock()
+	if glossary != nil {
+		return glossary, nil
+	}
+
+	records, err := l.allRecords()
+	if err != nil {
+		return nil, err
+	}
+	glossary = buildGlossary(records)
+
+	c.mutex.Lock()
+	if c.gen == gen {
+		c.glossary = glossary
+	}
+	c.mutex.Unlock()
+	return glossary, nil
+}
+
+// errNoKeyphrase is returned by keyphrase when no record has the phrase.
+var errNoKeyphrase = errors.New("No such keyphrase")
+
+// keyphrase returns the glossary entry for phrase.
+func (l *LaCodex) keyphrase(phrase string) (*Keyphrase, error) {
+	glossary, err := l.glossary()
+	if err != nil {
+		return nil, err
+	}
+	key := normalizeText(phrase)
+	for _, e := range glossary {
+		if normalizeText(e.Phrase) == key {
+			return e, nil
+		}
+	}
+	return nil, errNoKeyphrase
+}
+
+func (l *LaCodex) listKeyphrases(w io.Writer) error {
+	glossary, err := l.glossary()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(glossary)
+}
+
+// keyphraseHandler serves GET /keyphrase/:phrase[?async]
+func (l *LaCodex) keyphraseHandler(w http.ResponseWriter, r *http.Request) {
+	phrase := bone.GetValue(r, "phrase")
+
+	e, err := l.keyphrase(phrase)
+	if err == errNoKeyphrase {
+		httpError(w, http.StatusNotFound, "No keyphrase %s", phrase)
+		return
+	}
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't build glossary: %v", err)
+		return
+	}
+
+	// The first result is the entry looked up above.  Updates look it up
+	// again and end the stream if it's gone.
+	first := e
+	WsHandler(l.ps, func(w io.Writer) error {
+		e := first
+		first = nil
+		if e == nil {
+			var err error
+			e, err = l.keyphrase(phrase)
+			if err != nil {
+				return err
+			}
+		}
+		return json.NewEncoder(w).Encode(e)
+	})(w, r)
+}
@@ -0,0 +1,125 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func keyphraseRecord(id int, text string, blue []string, green []string) *model.Record {
+	return &model.Record{
+		Id:   id,
+		Type: model.RecordTypeScanner,
+		Text: text,
+		Keyphrases: map[model.KeyphraseType][]string{
+			model.KeyphraseTypeBlue:  blue,
+			model.KeyphraseTypeGreen: green,
+		},
+	}
+}
+
+func glossaryTestRecords() []*model.Record {
+	return []*model.Record{
+		keyphraseRecord(1, "Offer 3 lights to the heavens.", []string{"heavens"}, nil),
+		keyphraseRecord(2, "The Eye of\nTruth sees all.", nil, []string{"Eye of Truth"}),
+		keyphraseRecord(3, "Look to the Heavens with the eye of truth.", nil, nil),
+		keyphraseRecord(4, "The heavenly eye of truthful.", []string{"eye of  truth"}, nil),
+		{
+			Id:         5,
+			Type:       model.RecordTypeScanner,
+			Text:       "天に光を捧げよ",
+			Language:   model.LanguageJapanese,
+			Keyphrases: map[model.KeyphraseType][]string{model.KeyphraseTypeBlue: {"光"}},
+		},
+		keyphraseRecord(6, "光と影", nil, nil),
+	}
+}
+
+func TestBuildGlossary(t *testing.T) {
+	assert.Equal(t, []*Keyphrase{
+		{
+			Phrase:   "Eye of Truth",
+			Types:    []model.KeyphraseType{model.KeyphraseTypeBlue, model.KeyphraseTypeGreen},
+			Records:  []int{2, 4},
+			Mentions: []int{3},
+		},
+		{
+			Phrase:   "heavens",
+			Types:    []model.KeyphraseType{model.KeyphraseTypeBlue},
+			Records:  []int{1},
+			Mentions: []int{3},
+		},
+		{
+			Phrase:   "光",
+			Types:    []model.KeyphraseType{model.KeyphraseTypeBlue},
+			Records:  []int{5},
+			Mentions: []int{6},
+		},
+	}, buildGlossary(glossaryTestRecords()))
+
+	assert.Empty(t, buildGlossary(nil))
+}
+
+func (tlc *testLC) GetKeyphrase(t *testing.T, phrase string) (int, *Keyphrase) {
+	url := fmt.Sprintf("http://%s/keyphrase/%s", tlc.l.config.ListenAddr, phrase)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	var e Keyphrase
+	err = json.NewDecoder(resp.Body).Decode(&e)
+	assert.NoError(t, err)
+	return resp.StatusCode, &e
+}
+
+func TestKeyphraseHandlers(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	for _, record := range glossaryTestRecords() {
+		record.Id = 0
+		assert.NoError(t, tlc.l.records.Save(record))
+	}
+
+	url := fmt.Sprintf("http://%s/keyphrase/list", tlc.l.config.ListenAddr)
+	var glossary []*Keyphrase
+	err := json.Unmarshal([]byte(testGet(t, url)), &glossary)
+	assert.NoError(t, err)
+	if assert.Len(t, glossary, 3) {
+		assert.Equal(t, "Eye of Truth", glossary[0].Phrase)
+	}
+
+	status, e := tlc.GetKeyphrase(t, "eye%20of%20truth")
+	assert.Equal(t, http.StatusOK, status)
+	if assert.NotNil(t, e) {
+		assert.Equal(t, []int{2, 4}, e.Records)
+		assert.Equal(t, []int{3}, e.Mentions)
+	}
+
+	status, _ = tlc.GetKeyphrase(t, "nothing")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// The glossary is kept until an update is published.
+	err = tlc.l.records.Save(keyphraseRecord(0, "The Ankh Jewel.", nil, []string{"Ankh Jewel"}))
+	assert.NoError(t, err)
+	status, _ = tlc.GetKeyphrase(t, "ankh%20jewel")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Corrections are included.
+	status, _ = tlc.RecordRequest(t, "PATCH", "6", `{"keyphrases": {"green": ["heavens"]}}`)
+	assert.Equal(t, http.StatusOK, status)
+	_, e = tlc.GetKeyphrase(t, "heavens")
+	if assert.NotNil(t, e) {
+		assert.Equal(t, []int{1, 6}, e.Records)
+	}
+	status, _ = tlc.GetKeyphrase(t, "ankh%20jewel")
+	assert.Equal(t, http.StatusOK, status)
+}
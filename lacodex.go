@@ -1,11 +1,19 @@
 package lacodex
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"image"
+	_ "image/jpeg" // Pull in jpeg decoder.
 	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/cskr/pubsub"
 
@@ -14,75 +22,262 @@ import (
 	"github.com/golang/glog"
 	"github.com/konkers/lacodex/ingest"
 	"github.com/konkers/lacodex/model"
+	"github.com/konkers/lacodex/search"
 
 	"github.com/asdine/storm"
 	"github.com/konkers/lacodex/imagedb"
+
+	_ "golang.org/x/image/bmp"  // Pull in bmp decoder.
+	_ "golang.org/x/image/webp" // Pull in webp decoder.
 )
 
 // Config contains the configuration for LaCodex.
 type Config struct {
 	DbPath     string `json:"db"`
 	ListenAddr string `json:"listen"`
+
+	// ScreenshotDirs are directories that are watched for new screenshots.
+	// e.g. .../userdata/<id>/760/remote/230700/screenshots
+	ScreenshotDirs []string `json:"screenshot_dirs"`
+
+	// ScreenshotPatterns are regexps with year, month, day, hour, minute,
+	// second and ampm groups that capture times are read from file names
+	// with.  They are tried before the built in patterns.
+	ScreenshotPatterns []string `json:"screenshot_patterns"`
+
+	// DedupThreshold is the text similarity (0.0-1.0) above which a new
+	// record is merged into an existing one.  Defaults to 0.9.
+	DedupThreshold float64 `json:"dedup_threshold"`
+
+	// LayoutFile, if set, is loaded over the built in OCR screen layouts.
+	LayoutFile string `json:"layout"`
+
+	// GlyphAtlas, if set, is a glyph atlas that text is recognized with
+	// instead of tesseract.
+	GlyphAtlas string `json:"glyph_atlas"`
+
+	// Language is the game build that screenshots are from unless an upload
+	// says otherwise.  Defaults to English.
+	Language model.Language `json:"language"`
+
+	// BackupBeforeMigrate copies the database to <db>.v<version>.bak before
+	// it is migrated to a newer schema version.
+	BackupBeforeMigrate bool `json:"backup_before_migrate"`
+
+	// IngestWorkers is the number of images that are ingested in parallel.
+	// Defaults to the number of CPUs.
+	IngestWorkers int `json:"ingest_workers"`
 }
 
 // LaCodex is an instance of LaCodex.
 type LaCodex struct {
-	config  *Config
-	db      *storm.DB
-	idb     *imagedb.ImageDB
-	records storm.Node
+	config      *Config
+	db          *storm.DB
+	idb         *imagedb.ImageDB
+	records     storm.Node
+	corrections storm.Node
+	failures    storm.Node
+	search      *search.Index
+	jobs        *jobQueue
+
+	// Serializes changes to records between uploads, the directory watcher
+	// and edits.
+	recordMutex sync.Mutex
 
 	ps       *pubsub.PubSub
 	shutdown chan struct{}
+
+	glossaryCache *glossaryCache
 }
 
 // NewLaCodex creates a new LaCodex instance.
 func NewLaCodex(config *Config) (*LaCodex, error) {
+	if config.LayoutFile != "" {
+		err := ingest.LoadLayoutFile(config.LayoutFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.GlyphAtlas != "" {
+		atlas, err := ingest.LoadGlyphAtlas(config.GlyphAtlas)
+		if err != nil {
+			return nil, err
+		}
+		ingest.SetOCREngine(ingest.NewGlyphEngine(atlas))
+	}
+
+	// Databases that storm is about to create have nothing to migrate.
+	fresh := true
+	if info, err := os.Stat(config.DbPath); err == nil && info.Size() > 0 {
+		fresh = false
+	}
+
 	db, err := storm.Open(config.DbPath)
 	if err != nil {
 		return nil, err
 	}
 
-	idb := imagedb.NewImageDB(db.From("imagedb"))
+	idb := imagedb.NewImageDB(nil)
+	err = idb.SetFileNamePatterns(config.ScreenshotPatterns)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	return &LaCodex{
+	l := &LaCodex{
 		config:   config,
 		db:       db,
 		idb:      idb,
-		records:  db.From("records"),
+		search:   search.NewIndex(),
 		ps:       pubsub.New(0),
 		shutdown: make(chan struct{}),
-	}, nil
+
+		glossaryCache: &glossaryCache{},
+	}
+	l.useNode(db)
+
+	err = l.migrate(fresh)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	records, err := l.allRecords()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, record := range records {
+		l.search.Update(record)
+	}
+
+	l.jobs = newJobQueue(l, config.IngestWorkers)
+
+	return l, nil
+}
+
+// publishUpdate tells clients that records or images changed.  Data derived
+// from the records, like the glossary, is dropped first so that clients see
+// the change when they reload.
+func (l *LaCodex) publishUpdate() {
+	l.glossaryCache.invalidate()
+	l.ps.Pub(nil, "update")
+}
+
+// useNode points l at the buckets in node.
+func (l *LaCodex) useNode(node storm.Node) {
+	l.idb = l.idb.WithNode(node.From("imagedb"))
+	l.records = node.From("records")
+	l.corrections = node.From("corrections")
+	l.failures = node.From("failures")
+}
+
+// existingImage returns the record an image that was already added is
+// linked to.  Returns an error if it couldn't be ingested.
+func (l *LaCodex) existingImage(meta *model.ImageMetadata) (int, error) {
+	glog.V(2).Infof("already have %s", meta.FileName)
+	if meta.Record == 0 {
+		return 0, fmt.Errorf("%s was already added and couldn't be ingested", meta.FileName)
+	}
+	return meta.Record, nil
 }
 
-func (l *LaCodex) addImage(img image.Image, fileName string) error {
+// addImage ingests gameImg, a game image cropped out of a screenshot, and
+// links it to a record.  Returns the record's Id.  Images that can't be
+// ingested are still stored, and logged as failures, but the ingestion error
+// is returned.  capture is when the screenshot was captured and, if nil, is
+// worked out from fileName.
+func (l *LaCodex) addImage(gameImg *image.RGBA, fileName string, lang model.Language, capture *imagedb.Capture) (int, error) {
 	if meta, _ := l.idb.LookupFile(fileName); meta != nil {
-		glog.V(2).Infof("already have %s", fileName)
-		return nil
+		return l.existingImage(meta)
 	}
 
+	// OCR is slow so it is done before taking recordMutex.
 	glog.Infof("adding %s", fileName)
-	gameImg := ingest.CropGameImage(img)
-	record, err := ingest.IngestImage(gameImg)
-	glog.Infof("%#v %v", record, err)
-	if err == nil {
-		err = l.records.Save(record)
+	record, ingestErr := ingest.IngestGameImage(gameImg, lang)
+	glog.Infof("%#v %v", record, ingestErr)
+
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	// The same file may have been added while we were ingesting it.
+	if meta, _ := l.idb.LookupFile(fileName); meta != nil {
+		return l.existingImage(meta)
+	}
+
+	var err error
+	if ingestErr == nil {
+		record, err = l.saveNewRecord(record, fileName)
 		if err != nil {
-			return err
+			return 0, err
 		}
 	} else {
 		record = &model.Record{Id: 0}
 	}
 
-	err = l.idb.ImportScreenshot(fileName, record.Id, gameImg)
+	if capture == nil {
+		capture, err = l.idb.CaptureTime(&imagedb.CaptureInfo{FileName: fileName})
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = l.idb.ImportScreenshot(fileName, capture, record.Id, gameImg)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	l.ps.Pub(nil, "update")
 
-	return nil
+	if ingestErr != nil {
+		meta, err := l.idb.LookupFile(fileName)
+		if err != nil {
+			return 0, err
+		}
+		err = l.saveFailure(meta, lang, ingestErr)
+		if err != nil {
+			return 0, err
+		}
+		l.publishUpdate()
+		return 0, ingestErr
+	}
+	l.publishUpdate()
+
+	return record.Id, nil
 }
 
+// saveNewRecord saves a freshly ingested record unless it duplicates an
+// existing one.  Returns the record that images should be linked to.  Must
+// be called with recordMutex held.
+func (l *LaCodex) saveNewRecord(record *model.Record, fileName string) (*model.Record, error) {
+	dup, err := l.findDuplicate(record)
+	if err != nil {
+		return nil, err
+	}
+	if dup != nil {
+		glog.Infof("%s is a duplicate of record %d", fileName, dup.Id)
+		return dup, nil
+	}
+
+	err = l.records.Save(record)
+	if err != nil {
+		return nil, err
+	}
+	l.search.Update(record)
+
+	err = l.autoLinkTranslations(record)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// imageUploadHandler serves PUT /image/upload
+//
+// The image, a PNG, JPEG, WebP or BMP, is queued for ingestion and the job is
+// returned with a 202.  Its progress can be followed at /job/<id>.
+//
+// The optional "language" field is the game build the screenshot is from and
+// defaults to the configured one.  The optional "capturedAt" field is an RFC
+// 3339 capture time that is used if the file name and image don't have one.
 func (l *LaCodex) imageUploadHandler(w http.ResponseWriter, r *http.Request) {
 	r.ParseMultipartForm(10 << 20)
 
@@ -93,17 +288,51 @@ func (l *LaCodex) imageUploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	img, _, err := image.Decode(file)
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Error reading image: %v", err)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		httpError(w, http.StatusBadRequest, "Error decoding image: %v", err)
 		return
 	}
 
-	err = l.addImage(img, handler.Filename)
+	lang := l.config.Language
+	if s := r.FormValue("language"); s != "" {
+		err = lang.UnmarshalText([]byte(s))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+
+	info := &imagedb.CaptureInfo{FileName: handler.Filename, Data: data}
+	if s := r.FormValue("capturedAt"); s != "" {
+		info.CapturedAt, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "Bad capturedAt %q: %v", s, err)
+			return
+		}
+	}
+	capture, err := l.idb.CaptureTime(info)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	job, err := l.jobs.add(img, handler.Filename, lang, capture)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, "Error adding image: %v", err)
+		httpError(w, http.StatusServiceUnavailable, "Error adding image: %v", err)
 		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/job/%d", job.Id))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
 }
 
 func (l *LaCodex) listImages(w io.Writer) error {
@@ -115,14 +344,73 @@ func (l *LaCodex) listImages(w io.Writer) error {
 	return nil
 }
 
-func (l *LaCodex) listRecords(w io.Writer) error {
-	var records []*model.Record
-	err := l.records.All(&records)
+// searchHandler serves
+// /record/search?q=<query>[&color=blue|green][&lang=en|ja][&limit=n]
+//
+// color restricts results to records with keyphrases of that color.  lang
+// returns records in that language, swapping in translations where a match
+// is in another language.  Only the best matching of a set of translations
+// is returned.
+func (l *LaCodex) searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := 0
+	if s := query.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "Bad limit %s: %v", s, err)
+			return
+		}
+	}
+
+	var colors []model.KeyphraseType
+	for _, s := range query["color"] {
+		var c model.KeyphraseType
+		err := c.UnmarshalText([]byte(s))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		colors = append(colors, c)
+	}
+
+	var lang model.Language
+	err := lang.UnmarshalText([]byte(query.Get("lang")))
 	if err != nil {
-		return err
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
 	}
-	json.NewEncoder(w).Encode(records)
-	return nil
+
+	hits, err := l.collapseTranslations(l.search.Search(query.Get("q"), 0), lang)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't search: %v", err)
+		return
+	}
+	if len(colors) > 0 {
+		filtered := []*search.Hit{}
+	L:
+		for _, hit := range hits {
+			for _, c := range colors {
+				if len(hit.Record.Keyphrases[c]) == 0 {
+					continue L
+				}
+			}
+			filtered = append(filtered, hit)
+		}
+		hits = filtered
+	}
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i, hit := range hits {
+		h := *hit
+		h.Record = withoutLines(hit.Record)
+		hits[i] = &h
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hits)
 }
 
 func (l *LaCodex) Run() error {
@@ -130,7 +418,32 @@ func (l *LaCodex) Run() error {
 
 	mux.Put("/image/upload", http.HandlerFunc(l.imageUploadHandler))
 	mux.Get("/image/list", WsHandler(l.ps, l.listImages))
+	mux.Get("/image/failed", WsHandler(l.ps, l.listFailures))
+	mux.Delete("/image/file/:name", http.HandlerFunc(l.imageDeleteHandler))
+	mux.Get("/image/:hash", http.HandlerFunc(l.imageHandler))
+	mux.Post("/image/:hash/retry", http.HandlerFunc(l.retryHandler))
+	mux.Get("/image/:hash/similar", http.HandlerFunc(l.similarHandler))
 	mux.Get("/record/list", WsHandler(l.ps, l.listRecords))
+	mux.Get("/record/search", http.HandlerFunc(l.searchHandler))
+	mux.Get("/record/:id", http.HandlerFunc(l.recordGetHandler))
+	mux.Put("/record/:id", http.HandlerFunc(l.recordPutHandler))
+	mux.Patch("/record/:id", http.HandlerFunc(l.recordPatchHandler))
+	mux.Delete("/record/:id", http.HandlerFunc(l.recordDeleteHandler))
+	mux.Get("/record/:id/translations/candidates", http.HandlerFunc(l.translationCandidatesHandler))
+	mux.Put("/record/:id/translations/:other", http.HandlerFunc(l.translationPutHandler))
+	mux.Delete("/record/:id/translations/:other", http.HandlerFunc(l.translationDeleteHandler))
+	mux.Post("/admin/reprocess", http.HandlerFunc(l.reprocessHandler))
+	mux.Post("/admin/dedup", http.HandlerFunc(l.dedupHandler))
+	mux.Post("/admin/gc", http.HandlerFunc(l.gcHandler))
+	mux.Get("/job/list", WsTopicHandler(l.ps, "job", l.listJobs))
+	mux.Get("/job/:id", http.HandlerFunc(l.jobHandler))
+	mux.Get("/keyphrase/list", WsHandler(l.ps, l.listKeyphrases))
+	mux.Get("/keyphrase/:phrase", http.HandlerFunc(l.keyphraseHandler))
+	mux.Get("/export", http.HandlerFunc(l.exportHandler))
+
+	if len(l.config.ScreenshotDirs) > 0 {
+		go newWatcher(l, l.config.ScreenshotDirs).run(l.shutdown)
+	}
 
 	glog.Infof("Serving at http://%s/ ...", l.config.ListenAddr)
 
@@ -147,6 +460,7 @@ func (l *LaCodex) Run() error {
 		glog.Warningf("HTTP server ListenAndServe: %v", err)
 	}
 
+	l.jobs.stop()
 	l.ps.Pub(nil, "exit")
 
 	return nil
@@ -155,3 +469,10 @@ func (l *LaCodex) Run() error {
 func (l *LaCodex) Shutdown() {
 	close(l.shutdown)
 }
+
+// Close closes the database.  It is only needed when LaCodex is used without
+// Run.
+func (l *LaCodex) Close() error {
+	l.jobs.stop()
+	return l.db.Close()
+}
@@ -2,11 +2,15 @@ package lacodex
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"image/color"
+	"image/jpeg"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -14,13 +18,22 @@ import (
 	"testing"
 	"time"
 
+	"github.com/konkers/lacodex/ingest"
 	"github.com/konkers/lacodex/model"
+	"github.com/konkers/lacodex/search"
+	"github.com/konkers/lacodex/testutil"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/phayes/freeport"
+	"golang.org/x/image/bmp"
 )
 
+func init() {
+	// Recorded with `ingestutil process --record-ocr ingest/test_data/ocr`.
+	ingest.SetOCREngine(ingest.NewFakeOCREngine("ingest/test_data/ocr"))
+}
+
 type testLC struct {
 	l          *LaCodex
 	exitC      chan struct{}
@@ -28,6 +41,12 @@ type testLC struct {
 }
 
 func newTestLC(t *testing.T) *testLC {
+	return newTestLCWithConfig(t, &Config{})
+}
+
+// newTestLCWithConfig starts a LaCodex with config.  DbPath and ListenAddr
+// are filled in automatically.
+func newTestLCWithConfig(t *testing.T, config *Config) *testLC {
 	dbFile, err := ioutil.TempFile("", "*.db")
 	assert.NoError(t, err, "Can't get tempFile")
 	defer os.Remove(dbFile.Name())
@@ -36,10 +55,9 @@ func newTestLC(t *testing.T) *testLC {
 	assert.NoError(t, err, "Can't get free port")
 	host := "localhost:" + strconv.Itoa(port)
 
-	l, err := NewLaCodex(&Config{
-		DbPath:     dbFile.Name(),
-		ListenAddr: host,
-	})
+	config.DbPath = dbFile.Name()
+	config.ListenAddr = host
+	l, err := NewLaCodex(config)
 	assert.NoError(t, err, "Can't create new LaCodex")
 
 	exitC := make(chan struct{})
@@ -47,6 +65,17 @@ func newTestLC(t *testing.T) *testLC {
 		l.Run()
 		close(exitC)
 	}()
+
+	// Wait for the server so that requests made straight away don't race
+	// with it starting to listen.
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", host)
+		if err == nil {
+			conn.Close()
+			break
+		}
+	}
+
 	return &testLC{
 		l:          l,
 		exitC:      exitC,
@@ -60,18 +89,28 @@ func (tlc *testLC) Shutdown() {
 }
 
 func (tlc *testLC) PutImage(t *testing.T, filename string) int {
+	return tlc.PutImageAs(t, filename, filepath.Base(filename), nil)
+}
+
+// PutImageAs uploads filename as name along with the form fields in fields.
+func (tlc *testLC) PutImageAs(t *testing.T, filename string, name string, fields map[string]string) int {
 	file, err := os.Open(filename)
 	assert.NoError(t, err, "Can't open %s", filename)
 	defer file.Close()
 
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile(tlc.imageField, filepath.Base(filename))
+	part, err := writer.CreateFormFile(tlc.imageField, name)
 	assert.NoError(t, err, "Can't create part")
 
 	_, err = io.Copy(part, file)
 	assert.NoError(t, err, "Can't copy file part")
 
+	for k, v := range fields {
+		err = writer.WriteField(k, v)
+		assert.NoError(t, err, "Can't write field %s", k)
+	}
+
 	err = writer.Close()
 	assert.NoError(t, err, "Can't close writer")
 
@@ -83,10 +122,41 @@ func (tlc *testLC) PutImage(t *testing.T, filename string) int {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	assert.NoError(t, err, "Can't process request")
-	resp.Body.Close()
+	defer resp.Body.Close()
+
+	// Uploads are ingested in the background.  Wait for them so tests can
+	// check the results.
+	if resp.StatusCode == http.StatusAccepted {
+		var job Job
+		err = json.NewDecoder(resp.Body).Decode(&job)
+		assert.NoError(t, err, "Can't decode job")
+		tlc.WaitForJob(t, job.Id)
+	}
 	return resp.StatusCode
 }
 
+func (tlc *testLC) GetJob(t *testing.T, id int) *Job {
+	url := fmt.Sprintf("http://%s/job/%d", tlc.l.config.ListenAddr, id)
+	r := testGet(t, url)
+	var job Job
+	err := json.Unmarshal([]byte(r), &job)
+	assert.NoError(t, err, "Can't decode json: %s", r)
+	return &job
+}
+
+// WaitForJob waits for a job to be done or failed and returns it.
+func (tlc *testLC) WaitForJob(t *testing.T, id int) *Job {
+	for i := 0; i < 600; i++ {
+		job := tlc.GetJob(t, id)
+		if job.Status == JobDone || job.Status == JobFailed {
+			return job
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for job %d", id)
+	return nil
+}
+
 func (tlc *testLC) GetImages(t *testing.T) []*model.ImageMetadata {
 	url := fmt.Sprintf("http://%s/image/list", tlc.l.config.ListenAddr)
 	r := testGet(t, url)
@@ -150,20 +220,25 @@ func TestSameContentFileUpload(t *testing.T) {
 	imgs := tlc.GetImages(t)
 	assert.Equal(t, []*model.ImageMetadata{
 		&model.ImageMetadata{
-			Id:         1,
-			Hash:       "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
-			CapturedAt: testTimeParse(t, "2019-05-19 13:41:40 -0700 PDT"),
-			FileName:   "230700_20190519134140_1.png",
-			Record:     1,
+			Id:             1,
+			Hash:           "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
+			CapturedAt:     testTimeParse(t, "2019-05-19 13:41:40 -0700 PDT"),
+			FileName:       "230700_20190519134140_1.png",
+			Record:         1,
+			CaptureSource:  model.CaptureSourceFileName,
+			PerceptualHash: "dhash-8100d7005300df00df009f008e008c0080008000800080008000800080008200",
 		},
 		&model.ImageMetadata{
-			Id:         2,
-			Hash:       "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
-			CapturedAt: testTimeParse(t, "2019-05-19 13:41:45 -0700 PDT"),
-			FileName:   "230700_20190519134145_1.png",
-			Record:     2,
+			Id:             2,
+			Hash:           "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
+			CapturedAt:     testTimeParse(t, "2019-05-19 13:41:45 -0700 PDT"),
+			FileName:       "230700_20190519134145_1.png",
+			Record:         1,
+			CaptureSource:  model.CaptureSourceFileName,
+			PerceptualHash: "dhash-8100d7005300df00df009f008e008c0080008000800080008000800080008200",
 		},
 	}, imgs)
+	assert.Len(t, tlc.GetRecords(t), 1)
 
 	tlc.Shutdown()
 }
@@ -176,11 +251,13 @@ func TestSameFileUpload(t *testing.T) {
 	imgs := tlc.GetImages(t)
 	assert.Equal(t, []*model.ImageMetadata{
 		&model.ImageMetadata{
-			Id:         1,
-			Hash:       "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
-			CapturedAt: testTimeParse(t, "2019-05-19 13:41:40 -0700 PDT"),
-			FileName:   "230700_20190519134140_1.png",
-			Record:     1,
+			Id:             1,
+			Hash:           "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
+			CapturedAt:     testTimeParse(t, "2019-05-19 13:41:40 -0700 PDT"),
+			FileName:       "230700_20190519134140_1.png",
+			Record:         1,
+			CaptureSource:  model.CaptureSourceFileName,
+			PerceptualHash: "dhash-8100d7005300df00df009f008e008c0080008000800080008000800080008200",
 		},
 	}, imgs)
 
@@ -212,18 +289,22 @@ func TestMapImageUpload(t *testing.T) {
 	imgs := tlc.GetImages(t)
 	assert.Equal(t, []*model.ImageMetadata{
 		&model.ImageMetadata{
-			Id:         1,
-			Hash:       "sha256-c1d85db281056ffb2f43214219dafba0aba67032d7b05fae393d4c1d0f22fe59",
-			CapturedAt: testTimeParse(t, "2019-05-17 18:53:34 -0700 PDT"),
-			FileName:   "230700_20190517185334_1.png",
-			Record:     0,
+			Id:             1,
+			Hash:           "sha256-c1d85db281056ffb2f43214219dafba0aba67032d7b05fae393d4c1d0f22fe59",
+			CapturedAt:     testTimeParse(t, "2019-05-17 18:53:34 -0700 PDT"),
+			FileName:       "230700_20190517185334_1.png",
+			Record:         0,
+			CaptureSource:  model.CaptureSourceFileName,
+			PerceptualHash: "dhash-53d64ede3adc70d92b59e2dc33cfb395b3949324d2ec73ac53c153b453d59b52",
 		},
 		&model.ImageMetadata{
-			Id:         2,
-			Hash:       "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
-			CapturedAt: testTimeParse(t, "2019-05-19 13:41:40 -0700 PDT"),
-			FileName:   "230700_20190519134140_1.png",
-			Record:     1,
+			Id:             2,
+			Hash:           "sha256-8acc37faaea0c3ff4ea847288a76e5f713d5857f14bf6dbdeffe7dbedd3234db",
+			CapturedAt:     testTimeParse(t, "2019-05-19 13:41:40 -0700 PDT"),
+			FileName:       "230700_20190519134140_1.png",
+			Record:         1,
+			CaptureSource:  model.CaptureSourceFileName,
+			PerceptualHash: "dhash-8100d7005300df00df009f008e008c0080008000800080008000800080008200",
 		},
 	}, imgs)
 
@@ -237,8 +318,156 @@ func TestMapImageUpload(t *testing.T) {
 				Subject:    "",
 				Index:      nil,
 				Keyphrases: map[model.KeyphraseType][]string{},
+				Language:   model.LanguageEnglish,
 			},
 		}, recs)
 
 	tlc.Shutdown()
 }
+
+func TestCapturedAtUpload(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	// Neither the name nor the image say when it was captured.
+	status := tlc.PutImageAs(t, "testdata/screenshots/230700_20190519134140_1.png", "tablet.png", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status = tlc.PutImageAs(t, "testdata/screenshots/230700_20190519134140_1.png", "tablet.png",
+		map[string]string{"capturedAt": "yesterday"})
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status = tlc.PutImageAs(t, "testdata/screenshots/230700_20190519134140_1.png", "tablet.png",
+		map[string]string{"capturedAt": "2019-05-19T13:41:40-07:00"})
+	assert.Equal(t, http.StatusAccepted, status)
+
+	// The file name wins over capturedAt.
+	status = tlc.PutImageAs(t, "testdata/screenshots/230700_20190519134145_1.png",
+		"LaMulana 5_19_2019 1_41_45 PM.png",
+		map[string]string{"capturedAt": "2019-05-19T13:41:40-07:00"})
+	assert.Equal(t, http.StatusAccepted, status)
+
+	imgs := tlc.GetImages(t)
+	if assert.Len(t, imgs, 2) {
+		assert.Equal(t, testTimeParse(t, "2019-05-19 13:41:40 -0700 PDT").Unix(), imgs[0].CapturedAt.Unix())
+		assert.Equal(t, model.CaptureSourceUpload, imgs[0].CaptureSource)
+		assert.Equal(t, testTimeParse(t, "2019-05-19 13:41:45 -0700 PDT").Unix(), imgs[1].CapturedAt.Unix())
+		assert.Equal(t, model.CaptureSourceFileName, imgs[1].CaptureSource)
+	}
+}
+
+// solidWebP returns a lossless WebP file of a single color.  There is no
+// WebP encoder to make test files with but a single color image is only a
+// few bits.
+func solidWebP(width, height int, c color.RGBA) []byte {
+	var data []byte
+	n := uint(0)
+	put := func(v uint32, bits uint) {
+		for i := uint(0); i < bits; i++ {
+			if n%8 == 0 {
+				data = append(data, 0)
+			}
+			data[n/8] |= byte((v>>i)&1) << (n % 8)
+			n++
+		}
+	}
+	put(0x2f, 8) // Signature
+	put(uint32(width-1), 14)
+	put(uint32(height-1), 14)
+	put(0, 4) // Alpha hint and version
+	put(0, 3) // No transform, color cache or meta prefix codes
+	for _, v := range []uint8{c.G, c.R, c.B, c.A, 0} {
+		// Simple prefix code with one 8 bit symbol so pixels take no bits.
+		put(1, 1)
+		put(0, 1)
+		put(1, 1)
+		put(uint32(v), 8)
+	}
+	if len(data)%2 == 1 {
+		data = append(data, 0)
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("RIFF")
+	binary.Write(buf, binary.LittleEndian, uint32(12+len(data)))
+	buf.WriteString("WEBPVP8L")
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestImageFormatUpload(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	dir, err := ioutil.TempDir("", "lacodex-formats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	img := testutil.LoadTestImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	files := map[string]func(w io.Writer) error{
+		"230700_20190519134140_1.jpg": func(w io.Writer) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: 90})
+		},
+		"2019-05-19 13-41-45.bmp": func(w io.Writer) error {
+			return bmp.Encode(w, img)
+		},
+		"2019-05-19 13-41-50.webp": func(w io.Writer) error {
+			_, err := w.Write(solidWebP(640, 480, color.RGBA{40, 20, 30, 255}))
+			return err
+		},
+	}
+	for name, encode := range files {
+		buf := &bytes.Buffer{}
+		err = encode(buf)
+		assert.NoError(t, err, name)
+		path := filepath.Join(dir, name)
+		err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+		assert.NoError(t, err, name)
+
+		status := tlc.PutImage(t, path)
+		assert.Equal(t, http.StatusAccepted, status, name)
+	}
+
+	records := map[string]int{}
+	for _, meta := range tlc.GetImages(t) {
+		records[meta.FileName] = meta.Record
+	}
+	// The JPEG is classified but there's no OCR fixture for its noisy
+	// pixels.  The WebP isn't a game screen.
+	assert.Equal(t, map[string]int{
+		"230700_20190519134140_1.jpg": 0,
+		"2019-05-19 13-41-45.bmp":     1,
+		"2019-05-19 13-41-50.webp":    0,
+	}, records)
+}
+
+func (tlc *testLC) Search(t *testing.T, query string) []*search.Hit {
+	url := fmt.Sprintf("http://%s/record/search?%s", tlc.l.config.ListenAddr, query)
+	r := testGet(t, url)
+	var hits []*search.Hit
+	err := json.Unmarshal([]byte(r), &hits)
+	assert.NoError(t, err, "Can't decode json: %s", r)
+	return hits
+}
+
+func TestSearch(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	hits := tlc.Search(t, "q=heavens")
+	assert.Len(t, hits, 1)
+	assert.Equal(t, 1, hits[0].Record.Id)
+	assert.Equal(t, "Offer 3 lights to the <em>heavens</em>.\nOK\ni", hits[0].Snippet)
+
+	assert.Len(t, tlc.Search(t, "q=%22offer+3+lights%22&limit=1"), 1)
+	assert.Len(t, tlc.Search(t, "q=%22lights+offer%22"), 0)
+	assert.Len(t, tlc.Search(t, "q=heavens&color=blue"), 0)
+
+	base := fmt.Sprintf("http://%s/record/search?q=heavens", tlc.l.config.ListenAddr)
+	testBadGet(t, base+"&limit=x")
+	testBadGet(t, base+"&color=purple")
+}
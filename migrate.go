@@ -0,0 +1,188 @@
+package lacodex
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/asdine/storm"
+	"github.com/golang/glog"
+)
+
+// metadataBucket holds database wide settings such as the schema version.
+const metadataBucket = "__metadata__"
+
+const schemaVersionKey = "schema_version"
+
+// legacyMigrationsBucket marks one-off migrations that were run before the
+// schema was versioned.
+const legacyMigrationsBucket = "__migrations__"
+
+// migration upgrades the database by one schema version.  It is run in a
+// transaction and must only use the database through l.
+type migration struct {
+	name string
+	run  func(l *LaCodex) error
+}
+
+// migrations[i] upgrades the database from schema version i to i+1.  Only
+// ever append to this list.
+var migrations = []migration{
+	{"merge duplicate records", migrateMergeDuplicates},
+	{"calculate perceptual hashes", migratePerceptualHashes},
+	{"record capture sources", migrateCaptureSources},
+}
+
+// SchemaVersion returns the database schema version this version of lacodex
+// uses.
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+func migrateMergeDuplicates(l *LaCodex) error {
+	merged, err := l.MergeDuplicates()
+	if err != nil {
+		return err
+	}
+	glog.Infof("merged %d duplicate records", merged)
+	return nil
+}
+
+func migratePerceptualHashes(l *LaCodex) error {
+	n, err := l.idb.BackfillPerceptualHashes()
+	if err != nil {
+		return err
+	}
+	glog.Infof("calculated perceptual hashes of %d images", n)
+	return nil
+}
+
+func migrateCaptureSources(l *LaCodex) error {
+	n, err := l.idb.BackfillCaptureSources()
+	if err != nil {
+		return err
+	}
+	glog.Infof("set capture sources of %d images", n)
+	return nil
+}
+
+// schemaVersion returns the schema version of db.  Databases from before the
+// schema was versioned are version 0, or 1 if their duplicates were merged.
+func schemaVersion(db storm.Node) (int, error) {
+	var version int
+	err := db.Get(metadataBucket, schemaVersionKey, &version)
+	if err == nil {
+		return version, nil
+	}
+	if err != storm.ErrNotFound {
+		return 0, err
+	}
+
+	merged, _ := db.KeyExists(legacyMigrationsBucket, "merge-duplicates")
+	if merged {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// withTx returns a LaCodex that uses the database through tx, a write
+// transaction that the caller commits.
+func (l *LaCodex) withTx(tx storm.Node) *LaCodex {
+	c := &LaCodex{
+		config:   l.config,
+		idb:      l.idb,
+		search:   l.search,
+		ps:       l.ps,
+		shutdown: l.shutdown,
+
+		glossaryCache: l.glossaryCache,
+	}
+	c.useNode(tx)
+	c.idb = l.idb.WithTransaction(tx.From("imagedb"))
+	return c
+}
+
+// backupDb copies the database file before it is migrated from version.
+// Nothing else writes to the database during startup so the copy is
+// consistent.  Returns the path of the copy.
+func (l *LaCodex) backupDb(version int) (string, error) {
+	path := fmt.Sprintf("%s.v%d.bak", l.config.DbPath, version)
+
+	in, err := os.Open(l.config.DbPath)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// runMigration runs m and records that the database is at version in a
+// single transaction.
+func (l *LaCodex) runMigration(m migration, version int) error {
+	tx, err := l.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.run(l.withTx(tx))
+	if err != nil {
+		return err
+	}
+	err = tx.Set(metadataBucket, schemaVersionKey, version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrate brings the database up to SchemaVersion.  New databases have
+// nothing to migrate and are marked as current.
+func (l *LaCodex) migrate(fresh bool) error {
+	latest := SchemaVersion()
+	if fresh {
+		return l.db.Set(metadataBucket, schemaVersionKey, latest)
+	}
+
+	version, err := schemaVersion(l.db)
+	if err != nil {
+		return err
+	}
+	if version > latest {
+		return fmt.Errorf("Database schema version %d is newer than the latest supported, %d", version, latest)
+	}
+	if version == latest {
+		return nil
+	}
+
+	if l.config.BackupBeforeMigrate {
+		path, err := l.backupDb(version)
+		if err != nil {
+			return fmt.Errorf("Can't back up database: %v", err)
+		}
+		glog.Infof("backed up schema version %d database to %s", version, path)
+	}
+
+	for ; version < latest; version++ {
+		m := migrations[version]
+		glog.Infof("migrating database to schema version %d: %s", version+1, m.name)
+		err = l.runMigration(m, version+1)
+		if err != nil {
+			return fmt.Errorf("Migration to schema version %d (%s) failed: %v", version+1, m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package lacodex
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/asdine/storm"
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFixtureDb copies testdata/fixtures/<name>.db to a new file and returns
+// its path.  The fixtures were written by the versions that used each
+// schema: schema0 by one from before duplicates were merged, schema1 by the
+// first that merged them at startup and schema2 by the first versioned one
+// with only its first two migrations.  schema99 is an empty database marked
+// with a future version.
+func newFixtureDb(t *testing.T, name string) string {
+	in, err := os.Open("testdata/fixtures/" + name + ".db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile("", "*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.Name()
+}
+
+func openFixtureDb(t *testing.T, path string, config *Config) (*LaCodex, error) {
+	config.DbPath = path
+	return NewLaCodex(config)
+}
+
+func assertSchemaVersion(t *testing.T, l *LaCodex) {
+	version, err := schemaVersion(l.db)
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion(), version)
+}
+
+func TestMigrateFresh(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	assertSchemaVersion(t, tlc.l)
+}
+
+func TestMigrateSchema0(t *testing.T) {
+	path := newFixtureDb(t, "schema0")
+	defer os.Remove(path)
+	defer os.Remove(path + ".v0.bak")
+
+	l, err := openFixtureDb(t, path, &Config{BackupBeforeMigrate: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assertSchemaVersion(t, l)
+
+	// Duplicates were merged, keeping the correction.
+	records, err := l.allRecords()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, 1, records[0].Id)
+		assert.Equal(t, "Offering", records[0].Subject)
+		assert.Equal(t, 3, records[1].Id)
+	}
+	assert.Len(t, l.search.Search("offering", 0), 1)
+
+	metas, err := l.idb.ListImages()
+	assert.NoError(t, err)
+	for _, meta := range metas {
+		assert.Equal(t, 1, meta.Record)
+		assert.Equal(t, model.CaptureSourceFileName, meta.CaptureSource)
+		assert.Equal(t, "dhash-8100d7005300df00df009f008e008c0080008000800080008000800080008200",
+			meta.PerceptualHash)
+	}
+	assert.NoError(t, l.Close())
+
+	_, err = os.Stat(path + ".v0.bak")
+	assert.NoError(t, err)
+
+	// The backup is the database before migration.
+	backup, err := storm.Open(path + ".v0.bak")
+	if assert.NoError(t, err) {
+		var raw []*model.Record
+		assert.NoError(t, backup.From("records").All(&raw))
+		assert.Len(t, raw, 3)
+		version, err := schemaVersion(backup)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, version)
+		assert.NoError(t, backup.Close())
+	}
+
+	// Nothing to migrate the second time.
+	l, err = openFixtureDb(t, path, &Config{BackupBeforeMigrate: true})
+	if assert.NoError(t, err) {
+		assert.NoError(t, l.Close())
+	}
+	_, err = os.Stat(path + ".v3.bak")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMigrateLegacyMergeDuplicates(t *testing.T) {
+	path := newFixtureDb(t, "schema1")
+	defer os.Remove(path)
+
+	l, err := openFixtureDb(t, path, &Config{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	assertSchemaVersion(t, l)
+
+	// Duplicates were merged by an older version and aren't merged again.
+	records, err := l.allRecords()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	metas, err := l.idb.ListImages()
+	assert.NoError(t, err)
+	for _, meta := range metas {
+		assert.NotEmpty(t, meta.PerceptualHash)
+		assert.Equal(t, model.CaptureSourceFileName, meta.CaptureSource)
+	}
+}
+
+func TestMigrateSchema2(t *testing.T) {
+	path := newFixtureDb(t, "schema2")
+	defer os.Remove(path)
+
+	l, err := openFixtureDb(t, path, &Config{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	assertSchemaVersion(t, l)
+
+	records, err := l.allRecords()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	metas, err := l.idb.ListImages()
+	assert.NoError(t, err)
+	if assert.Len(t, metas, 2) {
+		assert.Equal(t, model.CaptureSourceFileName, metas[0].CaptureSource)
+		assert.Equal(t, model.CaptureSourceUpload, metas[1].CaptureSource)
+	}
+}
+
+func TestMigrateTooNew(t *testing.T) {
+	path := newFixtureDb(t, "schema99")
+	defer os.Remove(path)
+
+	_, err := openFixtureDb(t, path, &Config{})
+	assert.EqualError(t, err, "Database schema version 99 is newer than the latest supported, 3")
+}
+
+func TestMigrationFailure(t *testing.T) {
+	path := newFixtureDb(t, "schema0")
+	defer os.Remove(path)
+
+	saved := migrations
+	defer func() { migrations = saved }()
+	migrations = append(migrations[:len(migrations):len(migrations)], migration{"fail",
+		func(l *LaCodex) error {
+			// Changes are rolled back.
+			err := l.records.DeleteStruct(&model.Record{Id: 3})
+			if err != nil {
+				return err
+			}
+			return errors.New("oops")
+		},
+	})
+
+	_, err := openFixtureDb(t, path, &Config{})
+	assert.EqualError(t, err, "Migration to schema version 4 (fail) failed: oops")
+
+	// The migrations before it were kept.
+	migrations = saved
+	l, err := openFixtureDb(t, path, &Config{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	records, err := l.allRecords()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+}
@@ -0,0 +1,157 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RecordCorrection holds manual corrections to a Record.
+//
+// Corrections are stored separately from the ingested Record, keyed by the
+// Record's Id, so that re-running ingestion doesn't overwrite them.  Nil
+// fields leave the ingested value alone.  Index can't tell an unset field
+// from a removed one so NoIndex removes the ingested index.
+type RecordCorrection struct {
+	Id         int                        `storm:"id" json:"id"`
+	Type       *RecordType                `json:"type,omitempty"`
+	Text       *string                    `json:"text,omitempty"`
+	Subject    *string                    `json:"subject,omitempty"`
+	Index      *int                       `json:"index,omitempty"`
+	NoIndex    bool                       `json:"no_index,omitempty"`
+	Keyphrases map[KeyphraseType][]string `json:"keyphrases"`
+	Language   *Language                  `json:"language,omitempty"`
+	Deleted    bool                       `json:"deleted,omitempty"`
+}
+
+// CorrectionFromRecord returns a correction that replaces every field of a
+// record with the ones in record, including removing the index if record
+// doesn't have one.  Language is only replaced if it is set.
+func CorrectionFromRecord(record *Record) *RecordCorrection {
+	recordType := record.Type
+	text := record.Text
+	subject := record.Subject
+	keyphrases := record.Keyphrases
+	if keyphrases == nil {
+		keyphrases = map[KeyphraseType][]string{}
+	}
+
+	c := &RecordCorrection{
+		Id:         record.Id,
+		Type:       &recordType,
+		Text:       &text,
+		Subject:    &subject,
+		Keyphrases: keyphrases,
+	}
+	// Clients that don't know about languages leave the ingested one alone.
+	if record.Language != "" {
+		language := record.Language
+		c.Language = &language
+	}
+	if record.Index != nil {
+		index := *record.Index
+		c.Index = &index
+	} else {
+		c.NoIndex = true
+	}
+	return c
+}
+
+// DecodePatch decodes a correction that changes only the fields in data.
+// Fields that are null are cleared: the index is removed and the text,
+// subject and keyphrases are emptied.  The type and language can't be
+// cleared.
+func DecodePatch(data []byte) (*RecordCorrection, error) {
+	var patch RecordCorrection
+	err := json.Unmarshal(data, &patch)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		if string(value) != "null" {
+			continue
+		}
+		switch name {
+		case "index":
+			patch.NoIndex = true
+		case "text":
+			empty := ""
+			patch.Text = &empty
+		case "subject":
+			empty := ""
+			patch.Subject = &empty
+		case "keyphrases":
+			patch.Keyphrases = map[KeyphraseType][]string{}
+		case "type", "language":
+			return nil, fmt.Errorf("Can't clear %s", name)
+		}
+	}
+	return &patch, nil
+}
+
+// Merge overlays the fields set in other onto c.  Deleted is not merged.
+func (c *RecordCorrection) Merge(other *RecordCorrection) {
+	if other.Type != nil {
+		c.Type = other.Type
+	}
+	if other.Text != nil {
+		c.Text = other.Text
+	}
+	if other.Subject != nil {
+		c.Subject = other.Subject
+	}
+	if other.Index != nil {
+		c.Index = other.Index
+		c.NoIndex = false
+	}
+	if other.NoIndex {
+		c.Index = nil
+		c.NoIndex = true
+	}
+	if other.Keyphrases != nil {
+		c.Keyphrases = other.Keyphrases
+	}
+	if other.Language != nil {
+		c.Language = other.Language
+	}
+}
+
+// Apply returns a copy of record with the corrections applied.  Apply
+// returns nil if the record has been deleted.
+func (c *RecordCorrection) Apply(record *Record) *Record {
+	if c.Deleted {
+		return nil
+	}
+
+	r := *record
+	if c.Type != nil {
+		r.Type = *c.Type
+	}
+	if c.Text != nil {
+		r.Text = *c.Text
+		// The OCRed lines no longer match the text.
+		r.Lines = nil
+	}
+	if c.Subject != nil {
+		r.Subject = *c.Subject
+	}
+	if c.Index != nil {
+		index := *c.Index
+		r.Index = &index
+	}
+	if c.NoIndex {
+		r.Index = nil
+	}
+	if c.Keyphrases != nil {
+		r.Keyphrases = c.Keyphrases
+	}
+	if c.Language != nil {
+		r.Language = *c.Language
+	}
+	return &r
+}
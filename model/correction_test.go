@@ -0,0 +1,175 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrectionApply(t *testing.T) {
+	index := 3
+	record := &Record{
+		Id:   1,
+		Type: RecordTypeMailer,
+		Text: "Offer 3 lihgts to the heavens.",
+		Keyphrases: map[KeyphraseType][]string{
+			KeyphraseTypeBlue: []string{"heavens"},
+		},
+		Index: &index,
+	}
+
+	var c RecordCorrection
+	err := json.Unmarshal([]byte(`{"text": "Offer 3 lights to the heavens.", "subject": "Lights"}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, &Record{
+		Id:         1,
+		Type:       RecordTypeMailer,
+		Text:       "Offer 3 lights to the heavens.",
+		Subject:    "Lights",
+		Keyphrases: record.Keyphrases,
+		Index:      &index,
+	}, c.Apply(record))
+
+	// Apply doesn't modify the original.
+	assert.Equal(t, "Offer 3 lihgts to the heavens.", record.Text)
+
+	var other RecordCorrection
+	err = json.Unmarshal([]byte(`{"keyphrases": {"green": ["lights"]}, "index": 4}`), &other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Merge(&other)
+
+	corrected := c.Apply(record)
+	assert.Equal(t, "Offer 3 lights to the heavens.", corrected.Text)
+	assert.Equal(t, 4, *corrected.Index)
+	assert.Equal(t, map[KeyphraseType][]string{
+		KeyphraseTypeGreen: []string{"lights"},
+	}, corrected.Keyphrases)
+
+	c.Deleted = true
+	assert.Nil(t, c.Apply(record))
+}
+
+func TestCorrectionApplyLines(t *testing.T) {
+	record := &Record{
+		Id:    1,
+		Text:  "Offer 3 lihgts",
+		Lines: []*Line{{Box: Box{0, 0, 10, 10}}},
+	}
+
+	c := &RecordCorrection{Subject: new(string)}
+	assert.Equal(t, record.Lines, c.Apply(record).Lines)
+
+	// Lines don't match corrected text.
+	text := "Offer 3 lights"
+	c.Text = &text
+	assert.Nil(t, c.Apply(record).Lines)
+	assert.NotNil(t, record.Lines)
+}
+
+func TestCorrectionFromRecord(t *testing.T) {
+	index := 3
+	ingested := &Record{
+		Id:   1,
+		Type: RecordTypeMailer,
+		Text: "Ingested",
+		Keyphrases: map[KeyphraseType][]string{
+			KeyphraseTypeBlue: []string{"Ingested"},
+		},
+		Index: &index,
+	}
+
+	replacement := &Record{
+		Id:   1,
+		Type: RecordTypeScanner,
+		Text: "Replaced",
+	}
+	c := CorrectionFromRecord(replacement)
+	assert.Equal(t, &Record{
+		Id:         1,
+		Type:       RecordTypeScanner,
+		Text:       "Replaced",
+		Keyphrases: map[KeyphraseType][]string{},
+	}, c.Apply(ingested))
+
+	replacement.Index = &index
+	replacement.Text = "Changed"
+	c = CorrectionFromRecord(replacement)
+	replacement.Text = "Changed again"
+	assert.Equal(t, "Changed", c.Apply(ingested).Text)
+	assert.Equal(t, 3, *c.Apply(ingested).Index)
+}
+
+func TestDecodePatch(t *testing.T) {
+	index := 3
+	record := &Record{
+		Id:      1,
+		Type:    RecordTypeMailer,
+		Text:    "Offer",
+		Subject: "Lights",
+		Keyphrases: map[KeyphraseType][]string{
+			KeyphraseTypeBlue: []string{"heavens"},
+		},
+		Index: &index,
+	}
+
+	c := &RecordCorrection{Id: 1}
+	patch, err := DecodePatch([]byte(`{"index": null, "subject": null, "keyphrases": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Merge(patch)
+	assert.Equal(t, &Record{
+		Id:         1,
+		Type:       RecordTypeMailer,
+		Text:       "Offer",
+		Keyphrases: map[KeyphraseType][]string{},
+	}, c.Apply(record))
+
+	// Fields that aren't in the patch are left alone.
+	patch, err = DecodePatch([]byte(`{"text": null}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Merge(patch)
+	corrected := c.Apply(record)
+	assert.Equal(t, "", corrected.Text)
+	assert.Nil(t, corrected.Index)
+
+	// Setting the index again replaces the removal.
+	patch, err = DecodePatch([]byte(`{"index": 4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Merge(patch)
+	assert.Equal(t, 4, *c.Apply(record).Index)
+
+	_, err = DecodePatch([]byte(`{"type": null}`))
+	assert.EqualError(t, err, "Can't clear type")
+	_, err = DecodePatch([]byte(`{"index": "x"}`))
+	assert.Error(t, err)
+}
+
+func TestCorrectionLanguage(t *testing.T) {
+	record := &Record{Id: 1, Text: "Offer", Language: LanguageJapanese}
+
+	c := CorrectionFromRecord(&Record{Id: 1, Text: "Offer 3"})
+	assert.Equal(t, LanguageJapanese, c.Apply(record).Language)
+
+	c = CorrectionFromRecord(&Record{Id: 1, Text: "Offer 3", Language: LanguageEnglish})
+	assert.Equal(t, LanguageEnglish, c.Apply(record).Language)
+
+	var patch RecordCorrection
+	err := json.Unmarshal([]byte(`{"language": "ja"}`), &patch)
+	assert.NoError(t, err)
+	c.Merge(&patch)
+	assert.Equal(t, LanguageJapanese, c.Apply(record).Language)
+
+	err = json.Unmarshal([]byte(`{"language": "fr"}`), &patch)
+	assert.Error(t, err)
+}
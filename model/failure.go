@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// IngestFailure records why an image couldn't be ingested.
+type IngestFailure struct {
+	// ImageId is the Id of the image's ImageMetadata.
+	ImageId  int    `storm:"id" json:"image_id"`
+	FileName string `json:"file_name"`
+	Hash     string `storm:"index" json:"hash"`
+
+	// Stage is the step of ingestion that failed.  e.g. "classify" or "ocr".
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+
+	// Type and Confidence are the classifier's best match, if the image got
+	// that far.  Otherwise Type is RecordTypeUnknown.
+	Type       RecordType `json:"type"`
+	Confidence float64    `json:"confidence"`
+
+	// Language is the game build the image is from.  Retries use it.
+	Language Language `json:"language,omitempty"`
+
+	FailedAt time.Time `json:"failed_at"`
+}
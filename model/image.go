@@ -2,6 +2,26 @@ package model
 
 import "time"
 
+// CaptureSource is where the time an image was captured came from.
+type CaptureSource string
+
+const (
+	// CaptureSourceFileName is a timestamp in the file name, like Steam's.
+	CaptureSourceFileName CaptureSource = "file_name"
+
+	// CaptureSourcePNG is a PNG tIME chunk or "Creation Time" text chunk.
+	CaptureSourcePNG CaptureSource = "png"
+
+	// CaptureSourceEXIF is the EXIF DateTimeOriginal or DateTime tag.
+	CaptureSourceEXIF CaptureSource = "exif"
+
+	// CaptureSourceUpload is a time given by the uploader.
+	CaptureSourceUpload CaptureSource = "upload"
+
+	// CaptureSourceModTime is the file's modification time.
+	CaptureSourceModTime CaptureSource = "mod_time"
+)
+
 // ImageMetadata stores metadata bout an image.
 //
 // More than one ImageMetadata may exist for a single Image record.
@@ -11,4 +31,21 @@ type ImageMetadata struct {
 	CapturedAt time.Time `storm:"index"`
 	FileName   string    `storm:"index,unique"`
 	Record     int       `storm:"index"`
+
+	// CaptureSource is where CapturedAt came from.  Images imported before
+	// it was recorded all had Steam file names and are migrated to
+	// CaptureSourceFileName.
+	CaptureSource CaptureSource
+
+	// PerceptualHash is a hash of the image that changes little when the
+	// image does, unlike Hash.
+	PerceptualHash string `storm:"index"`
+}
+
+// SimilarImage is an image found by its perceptual hash.
+type SimilarImage struct {
+	ImageMetadata
+
+	// Distance is the Hamming distance between the perceptual hashes.
+	Distance int
 }
@@ -0,0 +1,45 @@
+package model
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// Language is the language of the game build a record was captured from.
+type Language string
+
+const (
+	// LanguageEnglish is the English build.  Records from before languages
+	// were tracked have no language and are English.
+	LanguageEnglish Language = "en"
+
+	// LanguageJapanese is the Japanese build.
+	LanguageJapanese Language = "ja"
+)
+
+// OrDefault returns l, or LanguageEnglish if l isn't set.
+func (l Language) OrDefault() Language {
+	if l == "" {
+		return LanguageEnglish
+	}
+	return l
+}
+
+func (l *Language) UnmarshalText(text []byte) error {
+	switch Language(text) {
+	case "", LanguageEnglish, LanguageJapanese:
+		*l = Language(text)
+		return nil
+	}
+
+	return fmt.Errorf("Unknown Language %s", string(text))
+}
+
+// IsUnspacedScript returns true if r is from a script that is written
+// without spaces between words, like Japanese.  CJK punctuation and full
+// width forms, which Japanese text is written with, count too.
+func IsUnspacedScript(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		(r >= 0x3000 && r <= 0x303f) || // CJK punctuation.
+		(r >= 0xff00 && r <= 0xffef) // Full and half width forms.
+}
@@ -1,6 +1,11 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+)
 
 // KeyphraseType enumerates the types of keyphrase.
 type KeyphraseType int
@@ -41,6 +46,56 @@ type Record struct {
 	Subject    string                     `json:"subject,omitempty"`
 	Index      *int                       `json:"index,omitempty"`
 	Keyphrases map[KeyphraseType][]string `json:"keyphrases"`
+	Language   Language                   `json:"language,omitempty"`
+
+	// Translations are the Ids of records with the same text in other
+	// languages.
+	Translations []int `json:"translations,omitempty"`
+
+	// Lines is the OCR output that Text was built from.  It is optional as
+	// records ingested by older versions don't have it.
+	Lines []*Line `json:"lines,omitempty"`
+}
+
+// Box is a rectangle in screenshot (native game) coordinates stored as
+// [x0, y0, x1, y1].
+type Box [4]int
+
+// BoxFromRect returns the Box for r.
+func BoxFromRect(r image.Rectangle) Box {
+	return Box{r.Min.X, r.Min.Y, r.Max.X, r.Max.Y}
+}
+
+// Rect returns b as an image.Rectangle.
+func (b Box) Rect() image.Rectangle {
+	return image.Rect(b[0], b[1], b[2], b[3])
+}
+
+// Word is a single OCRed word.
+type Word struct {
+	Text string `json:"text"`
+	Box  Box    `json:"box"`
+
+	// Confidence is tesseract's confidence in the word from 0 to 100.
+	Confidence float64       `json:"confidence"`
+	Keyphrase  KeyphraseType `json:"keyphrase"`
+}
+
+// Line is a line of OCRed words.
+type Line struct {
+	Box   Box     `json:"box"`
+	Words []*Word `json:"words"`
+}
+
+// Color returns the color text of type t is drawn in by the game.
+func (t KeyphraseType) Color() color.RGBA {
+	switch t {
+	case KeyphraseTypeBlue:
+		return color.RGBA{100, 182, 227, 255}
+	case KeyphraseTypeGreen:
+		return color.RGBA{96, 229, 147, 255}
+	}
+	return color.RGBA{230, 232, 236, 255}
 }
 
 func (t KeyphraseType) MarshalText() ([]byte, error) {
@@ -72,35 +127,55 @@ func (t *KeyphraseType) UnmarshalText(text []byte) error {
 	return fmt.Errorf("Unknown KeyphraseType %s", string(text))
 }
 
+var recordTypeMutex sync.RWMutex
+
+var recordTypeNames = map[RecordType]string{
+	RecordTypeTent:    "tent",
+	RecordTypeMailer:  "mailer",
+	RecordTypeScanner: "scanner",
+	RecordTypeUnknown: "unknown",
+}
+
+// RegisterRecordType registers a RecordType defined outside of this package
+// under name.  RecordTypes are stored in the database so t must not change
+// between runs.
+func RegisterRecordType(t RecordType, name string) error {
+	recordTypeMutex.Lock()
+	defer recordTypeMutex.Unlock()
+
+	if existing, ok := recordTypeNames[t]; ok {
+		return fmt.Errorf("RecordType %d already registered as %s", t, existing)
+	}
+	for _, existing := range recordTypeNames {
+		if existing == name {
+			return fmt.Errorf("RecordType %s already registered", name)
+		}
+	}
+
+	recordTypeNames[t] = name
+	return nil
+}
+
 func (t RecordType) MarshalText() ([]byte, error) {
-	switch t {
-	case RecordTypeTent:
-		return []byte("tent"), nil
-	case RecordTypeMailer:
-		return []byte("mailer"), nil
-	case RecordTypeScanner:
-		return []byte("scanner"), nil
-	case RecordTypeUnknown:
-		return []byte("unknown"), nil
+	recordTypeMutex.RLock()
+	defer recordTypeMutex.RUnlock()
+
+	if name, ok := recordTypeNames[t]; ok {
+		return []byte(name), nil
 	}
 
 	return nil, fmt.Errorf("Unknown RecordType %v", t)
 }
 
 func (t *RecordType) UnmarshalText(text []byte) error {
-	switch string(text) {
-	case "tent":
-		*t = RecordTypeTent
-		return nil
-	case "mailer":
-		*t = RecordTypeMailer
-		return nil
-	case "scanner":
-		*t = RecordTypeScanner
-		return nil
-	case "unknown":
-		*t = RecordTypeUnknown
-		return nil
+	recordTypeMutex.RLock()
+	defer recordTypeMutex.RUnlock()
+
+	for recordType, name := range recordTypeNames {
+		if name == string(text) {
+			*t = recordType
+			return nil
+		}
 	}
 
 	return fmt.Errorf("Unknown RecordType %s", string(text))
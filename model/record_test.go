@@ -1,6 +1,8 @@
 package model
 
 import (
+	"encoding/json"
+	"image"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -82,3 +84,62 @@ func TestRecordType(t *testing.T) {
 	}
 
 }
+
+func TestRegisterRecordType(t *testing.T) {
+	custom := RecordType(1000)
+	err := RegisterRecordType(custom, "custom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		delete(recordTypeNames, custom)
+	}()
+
+	enc, err := custom.MarshalText()
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, "custom", string(enc))
+
+	var val RecordType
+	err = (&val).UnmarshalText([]byte("custom"))
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, custom, val)
+
+	err = RegisterRecordType(custom, "other")
+	if err == nil {
+		t.Error("Expected error.")
+	}
+
+	err = RegisterRecordType(RecordType(1001), "tent")
+	if err == nil {
+		t.Error("Expected error.")
+	}
+}
+
+func TestBox(t *testing.T) {
+	r := image.Rect(1, 2, 3, 4)
+	b := BoxFromRect(r)
+	assert.Equal(t, Box{1, 2, 3, 4}, b)
+	assert.Equal(t, r, b.Rect())
+
+	enc, err := json.Marshal(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "[1,2,3,4]", string(enc))
+}
+
+func TestLanguage(t *testing.T) {
+	assert.Equal(t, LanguageEnglish, Language("").OrDefault())
+	assert.Equal(t, LanguageJapanese, LanguageJapanese.OrDefault())
+
+	var record Record
+	err := json.Unmarshal([]byte(`{"language": "ja", "translations": [3]}`), &record)
+	assert.NoError(t, err)
+	assert.Equal(t, LanguageJapanese, record.Language)
+	assert.Equal(t, []int{3}, record.Translations)
+
+	err = json.Unmarshal([]byte(`{"language": "jp"}`), &record)
+	assert.Error(t, err)
+}
@@ -0,0 +1,271 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/asdine/storm"
+	"github.com/go-zoo/bone"
+	"github.com/konkers/lacodex/model"
+)
+
+func (l *LaCodex) getCorrection(id int) (*model.RecordCorrection, error) {
+	var c model.RecordCorrection
+	err := l.corrections.One("Id", id, &c)
+	if err == storm.ErrNotFound {
+		return &model.RecordCorrection{Id: id}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// getRecord returns the record with id with its corrections applied.
+// Returns storm.ErrNotFound if the record doesn't exist or was deleted.
+func (l *LaCodex) getRecord(id int) (*model.Record, error) {
+	var record model.Record
+	err := l.records.One("Id", id, &record)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := l.getCorrection(id)
+	if err != nil {
+		return nil, err
+	}
+
+	corrected := c.Apply(&record)
+	if corrected == nil {
+		return nil, storm.ErrNotFound
+	}
+	return corrected, nil
+}
+
+// allRecords returns all records that haven't been deleted with their
+// corrections applied.
+func (l *LaCodex) allRecords() ([]*model.Record, error) {
+	var records []*model.Record
+	err := l.records.All(&records)
+	if err != nil {
+		return nil, err
+	}
+
+	var corrections []*model.RecordCorrection
+	err = l.corrections.All(&corrections)
+	if err != nil {
+		return nil, err
+	}
+	correctionMap := map[int]*model.RecordCorrection{}
+	for _, c := range corrections {
+		correctionMap[c.Id] = c
+	}
+
+	corrected := []*model.Record{}
+	for _, record := range records {
+		if c, ok := correctionMap[record.Id]; ok {
+			record = c.Apply(record)
+		}
+		if record != nil {
+			corrected = append(corrected, record)
+		}
+	}
+	return corrected, nil
+}
+
+// withoutLines returns a copy of record without its OCR lines.  They are
+// large so are only served by GET /record/:id.
+func withoutLines(record *model.Record) *model.Record {
+	if record.Lines == nil {
+		return record
+	}
+	r := *record
+	r.Lines = nil
+	return &r
+}
+
+func (l *LaCodex) listRecords(w io.Writer) error {
+	records, err := l.allRecords()
+	if err != nil {
+		return err
+	}
+	for i, record := range records {
+		records[i] = withoutLines(record)
+	}
+	json.NewEncoder(w).Encode(records)
+	return nil
+}
+
+// saveCorrection stores c and brings the search index and clients up to date.
+// Returns the corrected record, or nil if it was deleted.
+func (l *LaCodex) saveCorrection(c *model.RecordCorrection) (*model.Record, error) {
+	var record model.Record
+	err := l.records.One("Id", c.Id, &record)
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.corrections.Save(c)
+	if err != nil {
+		return nil, err
+	}
+
+	corrected := c.Apply(&record)
+	if corrected == nil {
+		l.search.Remove(c.Id)
+	} else {
+		l.search.Update(corrected)
+	}
+	l.publishUpdate()
+
+	return corrected, nil
+}
+
+func recordId(r *http.Request) (int, error) {
+	s := bone.GetValue(r, "id")
+	id, err := strconv.Atoi(s)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("Bad record id %s", s)
+	}
+	return id, nil
+}
+
+func writeRecord(w http.ResponseWriter, record *model.Record) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(record)
+}
+
+func recordError(w http.ResponseWriter, id int, err error) {
+	if err == storm.ErrNotFound {
+		httpError(w, http.StatusNotFound, "Record %d not found", id)
+	} else {
+		httpError(w, http.StatusInternalServerError, "Can't update record %d: %v", id, err)
+	}
+}
+
+// recordGetHandler serves GET /record/:id
+func (l *LaCodex) recordGetHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := recordId(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	record, err := l.getRecord(id)
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+	writeRecord(w, record)
+}
+
+// recordPutHandler serves PUT /record/:id
+//
+// The body is a complete record that replaces the ingested one.  Putting a
+// deleted record restores it.
+func (l *LaCodex) recordPutHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := recordId(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	var record model.Record
+	err = json.NewDecoder(r.Body).Decode(&record)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Can't decode record: %v", err)
+		return
+	}
+	record.Id = id
+
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	corrected, err := l.saveCorrection(model.CorrectionFromRecord(&record))
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+	writeRecord(w, corrected)
+}
+
+// recordPatchHandler serves PATCH /record/:id
+//
+// The body contains only the fields to change.  Fields set to null are
+// cleared.
+func (l *LaCodex) recordPatchHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := recordId(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Can't read record: %v", err)
+		return
+	}
+	patch, err := model.DecodePatch(body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "Can't decode record: %v", err)
+		return
+	}
+
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	c, err := l.getCorrection(id)
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+	if c.Deleted {
+		recordError(w, id, storm.ErrNotFound)
+		return
+	}
+	c.Merge(patch)
+
+	corrected, err := l.saveCorrection(c)
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+	writeRecord(w, corrected)
+}
+
+// recordDeleteHandler serves DELETE /record/:id
+//
+// The ingested record is kept so that re-ingesting its screenshot doesn't
+// bring it back.
+func (l *LaCodex) recordDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := recordId(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	c, err := l.getCorrection(id)
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+	if c.Deleted {
+		recordError(w, id, storm.ErrNotFound)
+		return
+	}
+	c.Deleted = true
+
+	_, err = l.saveCorrection(c)
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
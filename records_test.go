@@ -0,0 +1,210 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func (tlc *testLC) RecordRequest(t *testing.T, method string, id string, body string) (int, *model.Record) {
+	url := fmt.Sprintf("http://%s/record/%s", tlc.l.config.ListenAddr, id)
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record model.Record
+	err = json.Unmarshal(b, &record)
+	assert.NoError(t, err, "Can't decode json: %s", string(b))
+	return resp.StatusCode, &record
+}
+
+func TestRecordEdit(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	status, record := tlc.RecordRequest(t, "GET", "1", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Offer 3 lights to the heavens.\nOK\ni", record.Text)
+
+	status, record = tlc.RecordRequest(t, "PATCH", "1",
+		`{"text": "Offer 3 lights to the heavens.", "keyphrases": {"blue": ["heavens"]}}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, &model.Record{
+		Id:   1,
+		Type: model.RecordTypeScanner,
+		Text: "Offer 3 lights to the heavens.",
+		Keyphrases: map[model.KeyphraseType][]string{
+			model.KeyphraseTypeBlue: []string{"heavens"},
+		},
+		Language: model.LanguageEnglish,
+	}, record)
+
+	// A second patch keeps the first one's changes.
+	status, record = tlc.RecordRequest(t, "PATCH", "1", `{"subject": "Lights"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Offer 3 lights to the heavens.", record.Text)
+	assert.Equal(t, "Lights", record.Subject)
+
+	// Edits show up in listings and search.
+	assert.Equal(t, []*model.Record{record}, tlc.GetRecords(t))
+	assert.Len(t, tlc.Search(t, "q=heavens&color=blue"), 1)
+
+	// The ingested record is untouched.
+	var ingested model.Record
+	err := tlc.l.records.One("Id", 1, &ingested)
+	assert.NoError(t, err)
+	assert.Equal(t, "Offer 3 lights to the heavens.\nOK\ni", ingested.Text)
+
+	// Put replaces everything.
+	status, record = tlc.RecordRequest(t, "PUT", "1", `{"type": "tent", "text": "Replaced"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, &model.Record{
+		Id:         1,
+		Type:       model.RecordTypeTent,
+		Text:       "Replaced",
+		Keyphrases: map[model.KeyphraseType][]string{},
+		Language:   model.LanguageEnglish,
+	}, record)
+	assert.Len(t, tlc.Search(t, "q=heavens"), 0)
+
+	status, _ = tlc.RecordRequest(t, "DELETE", "1", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Empty(t, tlc.GetRecords(t))
+	assert.Len(t, tlc.Search(t, "q=replaced"), 0)
+
+	status, _ = tlc.RecordRequest(t, "GET", "1", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = tlc.RecordRequest(t, "PATCH", "1", `{"text": "nope"}`)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = tlc.RecordRequest(t, "DELETE", "1", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	// Putting a deleted record restores it.
+	status, _ = tlc.RecordRequest(t, "PUT", "1", `{"type": "scanner", "text": "Restored"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Len(t, tlc.GetRecords(t), 1)
+}
+
+func TestRecordEditIndex(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	index := 3
+	err := tlc.l.records.Save(&model.Record{
+		Type:       model.RecordTypeMailer,
+		Text:       "Offer 3 lights to the heavens.",
+		Keyphrases: map[model.KeyphraseType][]string{},
+		Index:      &index,
+	})
+	assert.NoError(t, err)
+
+	// Put without an index removes the ingested one.
+	status, record := tlc.RecordRequest(t, "PUT", "1", `{"type": "mailer", "text": "Offer"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, record.Index)
+
+	status, record = tlc.RecordRequest(t, "PATCH", "1", `{"index": 4}`)
+	assert.Equal(t, http.StatusOK, status)
+	if assert.NotNil(t, record.Index) {
+		assert.Equal(t, 4, *record.Index)
+	}
+
+	// Patching with null clears a field.
+	status, record = tlc.RecordRequest(t, "PATCH", "1", `{"index": null, "text": null}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, record.Index)
+	assert.Equal(t, "", record.Text)
+	assert.Equal(t, model.RecordTypeMailer, record.Type)
+
+	status, _ = tlc.RecordRequest(t, "PATCH", "1", `{"type": null}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestRecordEditErrors(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	tests := []struct {
+		method string
+		id     string
+		body   string
+		status int
+	}{
+		{"GET", "x", "", http.StatusBadRequest},
+		{"GET", "0", "", http.StatusBadRequest},
+		{"GET", "1", "", http.StatusNotFound},
+		{"PUT", "x", `{}`, http.StatusBadRequest},
+		{"PUT", "1", `{`, http.StatusBadRequest},
+		{"PUT", "1", `{"type": "nope"}`, http.StatusBadRequest},
+		{"PUT", "1", `{}`, http.StatusNotFound},
+		{"PATCH", "x", `{}`, http.StatusBadRequest},
+		{"PATCH", "1", `{`, http.StatusBadRequest},
+		{"PATCH", "1", `{}`, http.StatusNotFound},
+		{"DELETE", "x", "", http.StatusBadRequest},
+		{"DELETE", "1", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		status, _ := tlc.RecordRequest(t, test.method, test.id, test.body)
+		assert.Equal(t, test.status, status, "%s %s %s", test.method, test.id, test.body)
+	}
+}
+
+func TestRecordLines(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	record := &model.Record{
+		Type: model.RecordTypeScanner,
+		Text: "Offer 3 lights",
+		Lines: []*model.Line{
+			{
+				Box: model.Box{18, 34, 200, 50},
+				Words: []*model.Word{
+					{Text: "Offer", Box: model.Box{18, 34, 60, 50}, Confidence: 91},
+					{Text: "3", Box: model.Box{65, 34, 75, 50}, Confidence: 42},
+					{Text: "lights", Box: model.Box{80, 34, 200, 50}, Confidence: 88,
+						Keyphrase: model.KeyphraseTypeBlue},
+				},
+			},
+		},
+	}
+	err := tlc.l.records.Save(record)
+	assert.NoError(t, err)
+	tlc.l.search.Update(record)
+
+	status, got := tlc.RecordRequest(t, "GET", "1", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, record.Lines, got.Lines)
+
+	// Listings leave them out.
+	records := tlc.GetRecords(t)
+	if assert.Len(t, records, 1) {
+		assert.Nil(t, records[0].Lines)
+	}
+	hits := tlc.Search(t, "q=lights")
+	if assert.Len(t, hits, 1) {
+		assert.Nil(t, hits[0].Record.Lines)
+	}
+}
@@ -0,0 +1,363 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/imageutil"
+	"github.com/konkers/lacodex/ingest"
+	"github.com/konkers/lacodex/model"
+)
+
+// ReprocessStatus describes what happened to an image when it was
+// reprocessed.
+type ReprocessStatus string
+
+const (
+	// ReprocessAdded means a record was created for an image that previously
+	// failed ingestion.
+	ReprocessAdded ReprocessStatus = "added"
+
+	// ReprocessChanged means an existing record was updated.
+	ReprocessChanged ReprocessStatus = "changed"
+
+	// ReprocessUnchanged means ingestion gave the same record as before.
+	ReprocessUnchanged ReprocessStatus = "unchanged"
+
+	// ReprocessSkipped means the image wasn't reprocessed because another
+	// image of its record was, or because its record changed while it was
+	// being ingested.
+	ReprocessSkipped ReprocessStatus = "skipped"
+
+	// ReprocessFailed means ingestion failed.  An existing record is left
+	// alone and an image without one stays in the failure log.
+	ReprocessFailed ReprocessStatus = "failed"
+
+	// ReprocessError means the image couldn't be reprocessed, e.g. because
+	// the record it points to is missing.  Nothing is changed.
+	ReprocessError ReprocessStatus = "error"
+)
+
+// ReprocessChange describes a change to a single record.
+type ReprocessChange struct {
+	FileName string          `json:"file_name"`
+	RecordId int             `json:"record_id"`
+	Status   ReprocessStatus `json:"status"`
+	Error    string          `json:"error,omitempty"`
+
+	// Line diff of the record text.  Lines are prefixed with "-" or "+".
+	TextDiff []string `json:"text_diff,omitempty"`
+
+	AddedKeyphrases   map[model.KeyphraseType][]string `json:"added_keyphrases,omitempty"`
+	RemovedKeyphrases map[model.KeyphraseType][]string `json:"removed_keyphrases,omitempty"`
+}
+
+// ReprocessReport is the result of Reprocess.  Every image is counted under
+// one status.  Changes lists the images that weren't unchanged or skipped.
+type ReprocessReport struct {
+	DryRun    bool               `json:"dry_run"`
+	Images    int                `json:"images"`
+	Added     int                `json:"added"`
+	Changed   int                `json:"changed"`
+	Unchanged int                `json:"unchanged"`
+	Skipped   int                `json:"skipped"`
+	Failed    int                `json:"failed"`
+	Errors    int                `json:"errors"`
+	Changes   []*ReprocessChange `json:"changes"`
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines returns a minimal line diff between a and b.  Unchanged lines are
+// omitted.
+func diffLines(a, b string) []string {
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// aLines[i:] and bLines[j:].
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			i++
+			j++
+		case j < len(bLines) && (i == len(aLines) || lcs[i][j+1] > lcs[i+1][j]):
+			diff = append(diff, "+"+bLines[j])
+			j++
+		default:
+			diff = append(diff, "-"+aLines[i])
+			i++
+		}
+	}
+	return diff
+}
+
+// keyphraseDiff returns the keyphrases in a that are not in b.
+func keyphraseDiff(a, b map[model.KeyphraseType][]string) map[model.KeyphraseType][]string {
+	diff := map[model.KeyphraseType][]string{}
+	for t, phrases := range a {
+		have := map[string]bool{}
+		for _, p := range b[t] {
+			have[p] = true
+		}
+		for _, p := range phrases {
+			if !have[p] {
+				diff[t] = append(diff[t], p)
+			}
+		}
+	}
+	if len(diff) == 0 {
+		return nil
+	}
+	return diff
+}
+
+func recordsEqual(a, b *model.Record) bool {
+	return a.Type == b.Type &&
+		a.Language.OrDefault() == b.Language.OrDefault() &&
+		a.Text == b.Text &&
+		a.Subject == b.Subject &&
+		reflect.DeepEqual(a.Index, b.Index) &&
+		reflect.DeepEqual(a.Keyphrases, b.Keyphrases)
+}
+
+// saveIngestedRecord saves a freshly ingested record and brings the search
+// index up to date with its corrected version.
+func (l *LaCodex) saveIngestedRecord(record *model.Record) error {
+	err := l.records.Save(record)
+	if err != nil {
+		return err
+	}
+
+	c, err := l.getCorrection(record.Id)
+	if err != nil {
+		return err
+	}
+	if corrected := c.Apply(record); corrected != nil {
+		l.search.Update(corrected)
+	}
+	return nil
+}
+
+// imageLanguage returns the language meta was first ingested as.
+func (l *LaCodex) imageLanguage(meta *model.ImageMetadata) (model.Language, error) {
+	if meta.Record == 0 {
+		return l.failureLanguage(meta.Id)
+	}
+	var record model.Record
+	err := l.records.One("Id", meta.Record, &record)
+	if err != nil {
+		return "", fmt.Errorf("Can't load record %d: %v", meta.Record, err)
+	}
+	return record.Language, nil
+}
+
+// reprocessImage re-ingests a single image and describes what happened to
+// it.  OCR is slow so recordMutex is only held while the change is applied.
+func (l *LaCodex) reprocessImage(meta *model.ImageMetadata, dryRun bool) (*ReprocessChange, error) {
+	img, err := l.idb.GetImage(meta.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Images are re-ingested as the language they were first ingested as.
+	lang, err := l.imageLanguage(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	record, ingestErr := ingest.IngestGameImage(imageutil.AsRGBA(img), lang)
+
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	change := &ReprocessChange{
+		FileName: meta.FileName,
+		RecordId: meta.Record,
+	}
+
+	// The image may have been retried, relinked or removed while it was
+	// being ingested.
+	current, err := l.idb.LookupFile(meta.FileName)
+	if err != nil {
+		return nil, err
+	}
+	if current.Record != meta.Record {
+		glog.Infof("reprocess %s: record changed during ingestion, skipping", meta.FileName)
+		change.Status = ReprocessSkipped
+		return change, nil
+	}
+
+	var old model.Record
+	if meta.Record != 0 {
+		err = l.records.One("Id", meta.Record, &old)
+		if err != nil {
+			return nil, fmt.Errorf("Can't load record %d: %v", meta.Record, err)
+		}
+	}
+
+	if ingestErr != nil {
+		change.Status = ReprocessFailed
+		change.Error = ingestErr.Error()
+		if meta.Record == 0 && !dryRun {
+			// Keep the failure log up to date with the latest error.
+			err = l.saveFailure(meta, lang, ingestErr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return change, nil
+	}
+
+	if meta.Record == 0 {
+		change.Status = ReprocessAdded
+		change.TextDiff = diffLines("", record.Text)
+		change.AddedKeyphrases = keyphraseDiff(record.Keyphrases, nil)
+		if dryRun {
+			return change, nil
+		}
+
+		err = l.saveIngestedRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		change.RecordId = record.Id
+		err = l.idb.LinkRecord(meta.FileName, record.Id)
+		if err != nil {
+			return nil, err
+		}
+		return change, l.clearFailure(meta.Id)
+	}
+
+	record.Id = old.Id
+	record.Translations = old.Translations
+	if recordsEqual(&old, record) {
+		change.Status = ReprocessUnchanged
+		// Quietly fill in lines for records ingested before they were
+		// stored.
+		if old.Lines == nil && record.Lines != nil && !dryRun {
+			return change, l.records.Save(record)
+		}
+		return change, nil
+	}
+
+	change.Status = ReprocessChanged
+	change.TextDiff = diffLines(old.Text, record.Text)
+	change.AddedKeyphrases = keyphraseDiff(record.Keyphrases, old.Keyphrases)
+	change.RemovedKeyphrases = keyphraseDiff(old.Keyphrases, record.Keyphrases)
+	if dryRun {
+		return change, nil
+	}
+
+	return change, l.saveIngestedRecord(record)
+}
+
+// Reprocess re-runs ingestion on every stored image and updates the records
+// they point to.  Manual corrections are preserved.  If dryRun is true, the
+// changes are reported but not saved.  Images that can't be reprocessed are
+// reported with ReprocessError and the rest are still processed.
+func (l *LaCodex) Reprocess(dryRun bool) (*ReprocessReport, error) {
+	metas, err := l.idb.ListImages()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReprocessReport{
+		DryRun:  dryRun,
+		Changes: []*ReprocessChange{},
+	}
+
+	// More than one image may point at the same record.  Only process
+	// the first.
+	seen := map[int]bool{}
+	updated := false
+	for _, meta := range metas {
+		report.Images++
+		if meta.Record != 0 {
+			if seen[meta.Record] {
+				report.Skipped++
+				continue
+			}
+			seen[meta.Record] = true
+		}
+
+		change, err := l.reprocessImage(meta, dryRun)
+		if err != nil {
+			glog.Errorf("reprocess %s: %v", meta.FileName, err)
+			change = &ReprocessChange{
+				FileName: meta.FileName,
+				RecordId: meta.Record,
+				Status:   ReprocessError,
+				Error:    err.Error(),
+			}
+		}
+
+		switch change.Status {
+		case ReprocessAdded:
+			report.Added++
+			updated = true
+		case ReprocessChanged:
+			report.Changed++
+			updated = true
+		case ReprocessUnchanged:
+			report.Unchanged++
+			continue
+		case ReprocessSkipped:
+			report.Skipped++
+			continue
+		case ReprocessFailed:
+			report.Failed++
+		case ReprocessError:
+			report.Errors++
+		}
+		glog.Infof("reprocess %s: %s", meta.FileName, change.Status)
+		report.Changes = append(report.Changes, change)
+	}
+
+	if !dryRun && updated {
+		l.publishUpdate()
+	}
+
+	return report, nil
+}
+
+// reprocessHandler serves POST /admin/reprocess[?dry_run]
+func (l *LaCodex) reprocessHandler(w http.ResponseWriter, r *http.Request) {
+	_, dryRun := r.URL.Query()["dry_run"]
+
+	report, err := l.Reprocess(dryRun)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, "Can't reprocess images: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
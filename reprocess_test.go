@@ -0,0 +1,203 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffLines(t *testing.T) {
+	assert.Nil(t, diffLines("a\nb\nc", "a\nb\nc"))
+	assert.Equal(t, []string{"-b", "+B", "+d"}, diffLines("a\nb\nc", "a\nB\nc\nd"))
+	assert.Equal(t, []string{"+a", "+b"}, diffLines("", "a\nb"))
+	assert.Equal(t, []string{"-a", "-b"}, diffLines("a\nb", ""))
+}
+
+func TestKeyphraseDiff(t *testing.T) {
+	a := map[model.KeyphraseType][]string{
+		model.KeyphraseTypeBlue:  []string{"Ankhs", "guardians"},
+		model.KeyphraseTypeGreen: []string{"Ankh Jewel"},
+	}
+	b := map[model.KeyphraseType][]string{
+		model.KeyphraseTypeBlue: []string{"Ankhs"},
+	}
+
+	assert.Equal(t, map[model.KeyphraseType][]string{
+		model.KeyphraseTypeBlue:  []string{"guardians"},
+		model.KeyphraseTypeGreen: []string{"Ankh Jewel"},
+	}, keyphraseDiff(a, b))
+	assert.Nil(t, keyphraseDiff(b, a))
+	assert.Nil(t, keyphraseDiff(nil, a))
+}
+
+func TestReprocess(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	report, err := tlc.l.Reprocess(false)
+	assert.NoError(t, err)
+	// The first image isn't a known screen.
+	if !assert.Len(t, report.Changes, 1) {
+		return
+	}
+	failed := report.Changes[0]
+	assert.Equal(t, ReprocessFailed, failed.Status)
+	assert.Equal(t, 0, failed.RecordId)
+	assert.Equal(t, &ReprocessReport{
+		Images:    2,
+		Unchanged: 1,
+		Failed:    1,
+		Changes:   []*ReprocessChange{failed},
+	}, report)
+
+	// Simulate a record ingested by an older, worse, version of ingest.
+	ingested := &model.Record{
+		Id:   1,
+		Type: model.RecordTypeScanner,
+		Text: "Offer 3 lihgts to the heavens.\nOK\ni",
+		Keyphrases: map[model.KeyphraseType][]string{
+			model.KeyphraseTypeBlue: []string{"lihgts"},
+		},
+	}
+	err = tlc.l.records.Save(ingested)
+	assert.NoError(t, err)
+
+	// And a manual correction.
+	status, _ := tlc.RecordRequest(t, "PATCH", "1", `{"subject": "Lights"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	expectedChange := &ReprocessChange{
+		FileName: "230700_20190519134140_1.png",
+		RecordId: 1,
+		Status:   ReprocessChanged,
+		TextDiff: []string{"-Offer 3 lihgts to the heavens.", "+Offer 3 lights to the heavens."},
+		RemovedKeyphrases: map[model.KeyphraseType][]string{
+			model.KeyphraseTypeBlue: []string{"lihgts"},
+		},
+	}
+
+	url := fmt.Sprintf("http://%s/admin/reprocess?dry_run", tlc.l.config.ListenAddr)
+	resp, err := http.Post(url, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report = &ReprocessReport{}
+	err = json.NewDecoder(resp.Body).Decode(report)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, &ReprocessReport{
+		DryRun:  true,
+		Images:  2,
+		Changed: 1,
+		Failed:  1,
+		Changes: []*ReprocessChange{failed, expectedChange},
+	}, report)
+
+	var record model.Record
+	err = tlc.l.records.One("Id", 1, &record)
+	assert.NoError(t, err)
+	assert.Equal(t, ingested, &record)
+
+	report, err = tlc.l.Reprocess(false)
+	assert.NoError(t, err)
+	assert.Equal(t, []*ReprocessChange{failed, expectedChange}, report.Changes)
+
+	status, corrected := tlc.RecordRequest(t, "GET", "1", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Offer 3 lights to the heavens.\nOK\ni", corrected.Text)
+	assert.Equal(t, "Lights", corrected.Subject)
+	assert.Len(t, tlc.Search(t, "q=lights"), 1)
+	assert.Len(t, tlc.Search(t, "q=lihgts"), 0)
+}
+
+func TestReprocessRelink(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	// Simulate an image that failed ingestion.
+	err := tlc.l.idb.LinkRecord("230700_20190519134140_1.png", 0)
+	assert.NoError(t, err)
+
+	report, err := tlc.l.Reprocess(false)
+	assert.NoError(t, err)
+	assert.Len(t, report.Changes, 1)
+	assert.Equal(t, ReprocessAdded, report.Changes[0].Status)
+	assert.Equal(t, 2, report.Changes[0].RecordId)
+
+	imgs := tlc.GetImages(t)
+	assert.Equal(t, 2, imgs[0].Record)
+}
+
+func TestReprocessMissingRecord(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+
+	// Point the first image at a record that doesn't exist.
+	err := tlc.l.idb.LinkRecord("230700_20190517185334_1.png", 99)
+	assert.NoError(t, err)
+
+	report, err := tlc.l.Reprocess(false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Images)
+	assert.Equal(t, 1, report.Unchanged)
+	assert.Equal(t, 1, report.Errors)
+	if !assert.Len(t, report.Changes, 1) {
+		return
+	}
+	assert.Equal(t, ReprocessError, report.Changes[0].Status)
+	assert.Equal(t, 99, report.Changes[0].RecordId)
+	assert.NotEmpty(t, report.Changes[0].Error)
+}
+
+func TestReprocessCounts(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+	tlc.PutImage(t, "testdata/screenshots/230700_20190517185334_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134140_1.png")
+	tlc.PutImage(t, "testdata/screenshots/230700_20190519134145_1.png")
+	tlc.PutImageAs(t, "testdata/screenshots/230700_20190519134140_1.png", "230700_20190520120000_1.png", nil)
+	tlc.PutImageAs(t, "testdata/screenshots/230700_20190519134140_1.png", "230700_20190520120005_1.png", nil)
+
+	// Record 1 was ingested badly and record 2 is a good copy of it.
+	var record model.Record
+	err := tlc.l.records.One("Id", 1, &record)
+	assert.NoError(t, err)
+	record.Id = 0
+	assert.NoError(t, tlc.l.records.Save(&record))
+	assert.NoError(t, tlc.l.idb.LinkRecord("230700_20190519134145_1.png", record.Id))
+	assert.NoError(t, tlc.l.records.UpdateField(&model.Record{Id: 1}, "Text", "Offer 3 lihgts"))
+	assert.NoError(t, tlc.l.idb.LinkRecord("230700_20190520120000_1.png", 99))
+
+	// The last image is skipped because record 1 was already reprocessed.
+	expected := &ReprocessReport{
+		DryRun:    true,
+		Images:    5,
+		Changed:   1,
+		Unchanged: 1,
+		Skipped:   1,
+		Failed:    1,
+		Errors:    1,
+	}
+	for _, dryRun := range []bool{true, false} {
+		report, err := tlc.l.Reprocess(dryRun)
+		assert.NoError(t, err)
+		expected.DryRun = dryRun
+		expected.Changes = report.Changes
+		assert.Equal(t, expected, report)
+
+		statuses := []ReprocessStatus{}
+		for _, change := range report.Changes {
+			statuses = append(statuses, change.Status)
+		}
+		assert.Equal(t, []ReprocessStatus{ReprocessFailed, ReprocessChanged, ReprocessError}, statuses)
+	}
+}
@@ -0,0 +1,177 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// field enumerates the parts of a record that are indexed.
+type field int
+
+const (
+	fieldText field = iota
+	fieldSubject
+	fieldBlue
+	fieldGreen
+	numFields
+
+	// fieldAny matches a clause against all fields.
+	fieldAny field = -1
+)
+
+// Matches in subjects and keyphrases are more interesting than in the body.
+var fieldWeights = [numFields]float64{1.0, 2.0, 3.0, 3.0}
+
+var fieldNames = map[string]field{
+	"text":    fieldText,
+	"subject": fieldSubject,
+	"blue":    fieldBlue,
+	"green":   fieldGreen,
+}
+
+// clause is a single term or phrase that a record must contain.
+type clause struct {
+	terms []string
+	field field
+}
+
+type token struct {
+	term string
+	pos  int
+
+	// Byte offsets of the token in the source string.
+	start int
+	end   int
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isCJKTerm returns true for Japanese characters.  Japanese isn't written
+// with spaces so each character is its own term and words are matched as
+// phrases.  Japanese punctuation separates terms like any other.
+func isCJKTerm(r rune) bool {
+	return isTermRune(r) && model.IsUnspacedScript(r)
+}
+
+// tokenize splits s into lower cased terms, starting at position pos.
+func tokenize(s string, pos int) []token {
+	var tokens []token
+	start := -1
+	for i, r := range s {
+		if isTermRune(r) && !model.IsUnspacedScript(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(s[start:i]), pos, start, i})
+			pos++
+			start = -1
+		}
+		if isCJKTerm(r) {
+			end := i + utf8.RuneLen(r)
+			tokens = append(tokens, token{strings.ToLower(s[i:end]), pos, i, end})
+			pos++
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(s[start:]), pos, start, len(s)})
+	}
+	return tokens
+}
+
+func terms(s string) []string {
+	var t []string
+	for _, tok := range tokenize(s, 0) {
+		t = append(t, tok.term)
+	}
+	return t
+}
+
+// parseQuery parses a query string into clauses.
+//
+// Bare words are matched anywhere.  "Quoted phrases" must appear in order.
+// Either can be prefixed with a field name (text:, subject:, blue: or
+// green:) to restrict where they match.  blue: and green: match keyphrases
+// of that colour.  An unterminated quote runs to the end of the query.
+func parseQuery(query string) []clause {
+	var clauses []clause
+	add := func(f field, s string) {
+		t := terms(s)
+		if len(t) == 0 {
+			return
+		}
+		clauses = append(clauses, clause{terms: t, field: f})
+	}
+
+	for query != "" {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		if query == "" {
+			break
+		}
+
+		f := fieldAny
+		if i := strings.IndexByte(query, ':'); i > 0 {
+			if named, ok := fieldNames[strings.ToLower(query[:i])]; ok {
+				f = named
+				query = query[i+1:]
+			}
+		}
+
+		if strings.HasPrefix(query, `"`) {
+			query = query[1:]
+			end := strings.IndexByte(query, '"')
+			if end < 0 {
+				end = len(query)
+			}
+			add(f, query[:end])
+			if end < len(query) {
+				end++
+			}
+			query = query[end:]
+			continue
+		}
+
+		end := strings.IndexFunc(query, unicode.IsSpace)
+		if end < 0 {
+			end = len(query)
+		}
+		// A single word may still tokenize to more than one term
+		// (e.g. "3-eyed"), in which case it is treated as a phrase.
+		add(f, query[:end])
+		query = query[end:]
+	}
+
+	return clauses
+}
+
+// matchesAt returns true if c matches tokens starting at tokens[i].
+func (c *clause) matchesAt(tokens []token, i int) bool {
+	if i+len(c.terms) > len(tokens) {
+		return false
+	}
+	for j, term := range c.terms {
+		t := tokens[i+j]
+		if t.term != term || t.pos != tokens[i].pos+j {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns the number of times c matches tokens.
+func (c *clause) matches(tokens []token) int {
+	n := 0
+	for i := range tokens {
+		if c.matchesAt(tokens, i) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,287 @@
+// Package search implements an in-memory full-text index over records.
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/konkers/lacodex/model"
+)
+
+// How many bytes of context to show on either side of the first match in a
+// snippet.
+const snippetContext = 60
+
+// Hit is a single search result.
+type Hit struct {
+	Record  *model.Record `json:"record"`
+	Score   float64       `json:"score"`
+	Snippet string        `json:"snippet"`
+}
+
+type document struct {
+	record *model.Record
+	fields [numFields][]token
+}
+
+// Index is a full-text index of records.  It is safe for concurrent use.
+type Index struct {
+	mutex sync.RWMutex
+	docs  map[int]*document
+
+	// Maps a term to the ids of the records containing it.
+	postings map[string]map[int]bool
+}
+
+// NewIndex returns an empty Index.
+func NewIndex() *Index {
+	return &Index{
+		docs:     map[int]*document{},
+		postings: map[string]map[int]bool{},
+	}
+}
+
+func keyphraseTokens(phrases []string) []token {
+	var tokens []token
+	pos := 0
+	for _, phrase := range phrases {
+		t := tokenize(phrase, pos)
+		tokens = append(tokens, t...)
+		// Leave a gap so phrases don't match across keyphrases.
+		pos += len(t) + 1
+	}
+	return tokens
+}
+
+func newDocument(record *model.Record) *document {
+	doc := &document{record: record}
+	doc.fields[fieldText] = tokenize(record.Text, 0)
+	doc.fields[fieldSubject] = tokenize(record.Subject, 0)
+	doc.fields[fieldBlue] = keyphraseTokens(record.Keyphrases[model.KeyphraseTypeBlue])
+	doc.fields[fieldGreen] = keyphraseTokens(record.Keyphrases[model.KeyphraseTypeGreen])
+	return doc
+}
+
+func (doc *document) terms() map[string]bool {
+	t := map[string]bool{}
+	for _, tokens := range doc.fields {
+		for _, tok := range tokens {
+			t[tok.term] = true
+		}
+	}
+	return t
+}
+
+func (idx *Index) remove(id int) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms() {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// Update adds record to the index, replacing any previous version of it.
+func (idx *Index) Update(record *model.Record) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.remove(record.Id)
+	doc := newDocument(record)
+	idx.docs[record.Id] = doc
+	for term := range doc.terms() {
+		if idx.postings[term] == nil {
+			idx.postings[term] = map[int]bool{}
+		}
+		idx.postings[term][record.Id] = true
+	}
+}
+
+// Remove removes the record with the given id from the index.
+func (idx *Index) Remove(id int) {
+	idx.mutex.Lock()
+	defer idx.mutex.Unlock()
+
+	idx.remove(id)
+}
+
+// Len returns the number of indexed records.
+func (idx *Index) Len() int {
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	return len(idx.docs)
+}
+
+func (idx *Index) idf(term string) float64 {
+	return math.Log(1.0 + float64(len(idx.docs))/float64(1+len(idx.postings[term])))
+}
+
+// candidates returns the ids of records containing every term in clauses.
+func (idx *Index) candidates(clauses []clause) []int {
+	smallest := idx.postings[clauses[0].terms[0]]
+	for _, c := range clauses {
+		for _, term := range c.terms {
+			if p := idx.postings[term]; len(p) < len(smallest) {
+				smallest = p
+			}
+		}
+	}
+
+	var ids []int
+L:
+	for id := range smallest {
+		for _, c := range clauses {
+			for _, term := range c.terms {
+				if !idx.postings[term][id] {
+					continue L
+				}
+			}
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// score returns the score of doc against clauses, or 0 if any clause
+// doesn't match.
+func (idx *Index) score(doc *document, clauses []clause) float64 {
+	score := 0.0
+	for _, c := range clauses {
+		idf := 0.0
+		for _, term := range c.terms {
+			idf += idx.idf(term)
+		}
+
+		clauseScore := 0.0
+		for f := field(0); f < numFields; f++ {
+			if c.field != fieldAny && c.field != f {
+				continue
+			}
+			n := c.matches(doc.fields[f])
+			if n > 0 {
+				clauseScore += fieldWeights[f] * (1.0 + math.Log(float64(n))) * idf
+			}
+		}
+		if clauseScore == 0 {
+			return 0
+		}
+		score += clauseScore
+	}
+	return score
+}
+
+// snippet returns an excerpt of s around the first match of clauses with all
+// matches highlighted with <em> tags.  The rest of the snippet is HTML
+// escaped.
+func snippet(s string, tokens []token, clauses []clause) string {
+	// Byte ranges of all matches, in token order.
+	type span struct{ start, end int }
+	var spans []span
+	for i := range tokens {
+		for _, c := range clauses {
+			if c.matchesAt(tokens, i) {
+				spans = append(spans, span{tokens[i].start, tokens[i+len(c.terms)-1].end})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return ""
+	}
+
+	start := spans[0].start - snippetContext
+	end := spans[0].end + snippetContext
+	prefix, suffix := "...", "..."
+	if start <= 0 {
+		start = 0
+		prefix = ""
+	}
+	if end >= len(s) {
+		end = len(s)
+		suffix = ""
+	}
+	// Don't cut words in half.
+	for _, tok := range tokens {
+		if tok.start < start && tok.end > start {
+			start = tok.start
+		}
+		if tok.start < end && tok.end > end {
+			end = tok.end
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(prefix)
+	pos := start
+	for _, sp := range spans {
+		if sp.start < pos || sp.end > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(s[pos:sp.start]))
+		b.WriteString("<em>")
+		b.WriteString(html.EscapeString(s[sp.start:sp.end]))
+		b.WriteString("</em>")
+		pos = sp.end
+	}
+	b.WriteString(html.EscapeString(s[pos:end]))
+	b.WriteString(suffix)
+	return b.String()
+}
+
+// Every clause is highlighted in the body regardless of which field it was
+// restricted to.  Keyphrases are part of the body text anyway.
+func (doc *document) snippet(clauses []clause) string {
+	s := snippet(doc.record.Text, doc.fields[fieldText], clauses)
+	if s == "" {
+		s = snippet(doc.record.Subject, doc.fields[fieldSubject], clauses)
+	}
+	return s
+}
+
+// Search returns the records that match query, best match first.  If limit
+// is greater than 0, at most limit hits are returned.
+//
+// See parseQuery for the query syntax.
+func (idx *Index) Search(query string, limit int) []*Hit {
+	clauses := parseQuery(query)
+	if len(clauses) == 0 {
+		return []*Hit{}
+	}
+
+	idx.mutex.RLock()
+	defer idx.mutex.RUnlock()
+
+	hits := []*Hit{}
+	for _, id := range idx.candidates(clauses) {
+		doc := idx.docs[id]
+		score := idx.score(doc, clauses)
+		if score == 0 {
+			continue
+		}
+		hits = append(hits, &Hit{
+			Record:  doc.record,
+			Score:   score,
+			Snippet: doc.snippet(clauses),
+		})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Record.Id < hits[j].Record.Id
+	})
+
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
@@ -0,0 +1,176 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+var testRecords = []*model.Record{
+	&model.Record{
+		Id:   1,
+		Type: model.RecordTypeScanner,
+		Text: "There are 8 Ankhs.\n8 Ankhs that protect the great spirits.\n" +
+			"Seek the red light; the Ankh Jewel.",
+		Keyphrases: map[model.KeyphraseType][]string{
+			model.KeyphraseTypeBlue:  []string{"Ankhs"},
+			model.KeyphraseTypeGreen: []string{"Ankh Jewel"},
+		},
+	},
+	&model.Record{
+		Id:         2,
+		Type:       model.RecordTypeScanner,
+		Text:       "Offer 3 lights to the heavens.",
+		Keyphrases: map[model.KeyphraseType][]string{},
+	},
+	&model.Record{
+		Id:      3,
+		Type:    model.RecordTypeMailer,
+		Index:   intPtr(4),
+		Subject: "Heavens & Earth",
+		Text:    "The jewel of the heavens lies beyond <the> light.",
+		Keyphrases: map[model.KeyphraseType][]string{
+			model.KeyphraseTypeBlue: []string{"jewel"},
+		},
+	},
+}
+
+func newTestIndex() *Index {
+	idx := NewIndex()
+	for _, r := range testRecords {
+		idx.Update(r)
+	}
+	return idx
+}
+
+func hitIds(hits []*Hit) []int {
+	ids := []int{}
+	for _, h := range hits {
+		ids = append(ids, h.Record.Id)
+	}
+	return ids
+}
+
+func TestParseQuery(t *testing.T) {
+	assert.Equal(t, []clause{
+		{[]string{"ankh"}, fieldAny},
+		{[]string{"ankh", "jewel"}, fieldAny},
+		{[]string{"jewel"}, fieldGreen},
+		{[]string{"great", "spirits"}, fieldBlue},
+		{[]string{"3", "eyed"}, fieldAny},
+		{[]string{"bogus", "foo"}, fieldAny},
+		{[]string{"unterminated", "quote"}, fieldSubject},
+	}, parseQuery(`  Ankh "ankh JEWEL" green:jewel blue:"great spirits" 3-eyed  bogus:foo `+
+		`subject:"unterminated quote`))
+
+	assert.Empty(t, parseQuery(`  "" blue: ...`))
+}
+
+func TestTokenize(t *testing.T) {
+	assert.Equal(t, []token{
+		{"the", 5, 0, 3},
+		{"ankh", 6, 4, 8},
+		{"jewel", 7, 9, 14},
+	}, tokenize("The Ankh-Jewel.", 5))
+
+	// Japanese characters are single terms.
+	assert.Equal(t, []token{
+		{"石", 0, 0, 3},
+		{"板", 1, 3, 6},
+		{"の", 2, 6, 9},
+		{"ankh", 3, 9, 13},
+		{"3", 4, 16, 17},
+		{"つ", 5, 17, 20},
+	}, tokenize("石板のAnkh。3つ", 0))
+
+	// So are full width letters.  Full width punctuation separates terms.
+	assert.Equal(t, []token{
+		{"ｅ", 0, 0, 3},
+		{"ｙ", 1, 3, 6},
+		{"ｅ", 2, 6, 9},
+		{"目", 3, 12, 15},
+	}, tokenize("ＥＹＥ！目", 0))
+}
+
+func TestSearch(t *testing.T) {
+	idx := newTestIndex()
+	assert.Equal(t, 3, idx.Len())
+
+	assert.Equal(t, []int{1}, hitIds(idx.Search("ankhs", 0)))
+	assert.Equal(t, []int{1, 3}, hitIds(idx.Search("jewel", 0)))
+	assert.Equal(t, []int{1}, hitIds(idx.Search("jewel", 1)))
+	assert.Equal(t, []int{3, 2}, hitIds(idx.Search("heavens", 0)))
+	assert.Equal(t, []int{}, hitIds(idx.Search("", 0)))
+	assert.Equal(t, []int{}, hitIds(idx.Search("nothing", 0)))
+
+	// Phrases must appear in order.
+	assert.Equal(t, []int{1}, hitIds(idx.Search(`"ankh jewel"`, 0)))
+	assert.Equal(t, []int{}, hitIds(idx.Search(`"jewel ankh"`, 0)))
+
+	// All clauses must match.
+	assert.Equal(t, []int{3}, hitIds(idx.Search(`jewel heavens`, 0)))
+
+	// Keyphrase colour filters.
+	assert.Equal(t, []int{1}, hitIds(idx.Search(`green:jewel`, 0)))
+	assert.Equal(t, []int{3}, hitIds(idx.Search(`blue:jewel`, 0)))
+	assert.Equal(t, []int{}, hitIds(idx.Search(`blue:"ankh jewel"`, 0)))
+	assert.Equal(t, []int{3}, hitIds(idx.Search(`subject:earth`, 0)))
+	assert.Equal(t, []int{}, hitIds(idx.Search(`text:earth`, 0)))
+}
+
+func TestSearchSnippet(t *testing.T) {
+	idx := newTestIndex()
+
+	hits := idx.Search(`"beyond the light"`, 0)
+	assert.Equal(t, []int{3}, hitIds(hits))
+	assert.Equal(t, "The jewel of the heavens lies <em>beyond &lt;the&gt; light</em>.",
+		hits[0].Snippet)
+
+	hits = idx.Search(`"ankh jewel"`, 0)
+	assert.Equal(t, "...Ankhs that protect the great spirits.\nSeek the red light; the <em>Ankh Jewel</em>.",
+		hits[0].Snippet)
+
+	hits = idx.Search(`earth`, 0)
+	assert.Equal(t, "Heavens &amp; <em>Earth</em>", hits[0].Snippet)
+}
+
+func TestUpdateRemove(t *testing.T) {
+	idx := newTestIndex()
+
+	idx.Update(&model.Record{Id: 2, Text: "Offer 3 candles to the heavens."})
+	assert.Equal(t, 3, idx.Len())
+	assert.Equal(t, []int{}, hitIds(idx.Search("lights", 0)))
+	assert.Equal(t, []int{2}, hitIds(idx.Search("candles", 0)))
+
+	idx.Remove(3)
+	idx.Remove(3)
+	assert.Equal(t, 2, idx.Len())
+	assert.Equal(t, []int{1}, hitIds(idx.Search("jewel", 0)))
+	_, ok := idx.postings["earth"]
+	assert.False(t, ok)
+}
+
+func TestSearchJapanese(t *testing.T) {
+	idx := newTestIndex()
+	idx.Update(&model.Record{
+		Id:       4,
+		Type:     model.RecordTypeMailer,
+		Index:    intPtr(4),
+		Text:     "天の宝石は光の彼方にある。",
+		Language: model.LanguageJapanese,
+		Keyphrases: map[model.KeyphraseType][]string{
+			model.KeyphraseTypeBlue: []string{"宝石"},
+		},
+	})
+
+	hits := idx.Search("宝石", 0)
+	assert.Equal(t, []int{4}, hitIds(hits))
+	assert.Equal(t, "天の<em>宝石</em>は光の彼方にある。", hits[0].Snippet)
+	assert.Equal(t, []int{4}, hitIds(idx.Search("blue:宝石 光", 0)))
+	assert.Equal(t, []int{}, hitIds(idx.Search("石宝", 0)))
+}
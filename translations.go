@@ -0,0 +1,416 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/asdine/storm"
+	"github.com/go-zoo/bone"
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/model"
+	"github.com/konkers/lacodex/search"
+)
+
+// addId returns ids with id added if it isn't already in it.
+func addId(ids []int, id int) []int {
+	for _, i := range ids {
+		if i == id {
+			return ids
+		}
+	}
+	return append(ids, id)
+}
+
+// removeId returns ids without id.
+func removeId(ids []int, id int) []int {
+	var out []int
+	for _, i := range ids {
+		if i != id {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// updateTranslations loads the ingested records with ids a and b, applies
+// update to their translations and saves them.
+func (l *LaCodex) updateTranslations(a, b int, update func([]int, int) []int) error {
+	var ra, rb model.Record
+	err := l.records.One("Id", a, &ra)
+	if err != nil {
+		return err
+	}
+	err = l.records.One("Id", b, &rb)
+	if err != nil {
+		return err
+	}
+
+	ra.Translations = update(ra.Translations, b)
+	rb.Translations = update(rb.Translations, a)
+
+	err = l.saveIngestedRecord(&ra)
+	if err != nil {
+		return err
+	}
+	return l.saveIngestedRecord(&rb)
+}
+
+// linkTranslations marks the records with ids a and b as translations of
+// each other.  Must be called with recordMutex held.
+func (l *LaCodex) linkTranslations(a, b int) error {
+	return l.updateTranslations(a, b, addId)
+}
+
+// unlinkTranslations undoes linkTranslations.  Must be called with
+// recordMutex held.
+func (l *LaCodex) unlinkTranslations(a, b int) error {
+	return l.updateTranslations(a, b, removeId)
+}
+
+// isTranslationCandidate returns true if c could be a translation of record
+// that hasn't been linked yet.  languages maps record Ids to their language.
+func isTranslationCandidate(record, c *model.Record, languages map[int]model.Language) bool {
+	lang := record.Language.OrDefault()
+	if c.Id == record.Id || c.Type != record.Type || languages[c.Id] == lang {
+		return false
+	}
+	if record.Index != nil && c.Index != nil && *c.Index != *record.Index {
+		return false
+	}
+	// Already linked to a translation in record's language.
+	for _, id := range c.Translations {
+		if languages[id] == lang {
+			return false
+		}
+	}
+	return true
+}
+
+// autoLinkTranslations links a newly ingested record to the record of the
+// same tablet in another language.  Only indexed records can be matched
+// reliably this way.  Others are left to be linked by hand from
+// translationCandidates.  Must be called with recordMutex held.
+func (l *LaCodex) autoLinkTranslations(record *model.Record) error {
+	if record.Index == nil {
+		return nil
+	}
+
+	var candidates []*model.Record
+	err := l.records.Find("Type", record.Type, &candidates)
+	if err != nil {
+		// Find returns storm.ErrNotFound if there are no candidates, but
+		// there is always at least record itself.
+		return err
+	}
+	languages := map[int]model.Language{}
+	for _, c := range candidates {
+		languages[c.Id] = c.Language.OrDefault()
+	}
+
+	for _, c := range candidates {
+		if c.Index == nil || !isTranslationCandidate(record, c, languages) {
+			continue
+		}
+		glog.Infof("record %d is a translation of %d", record.Id, c.Id)
+		return l.linkTranslations(record.Id, c.Id)
+	}
+	return nil
+}
+
+// TranslationCandidate is a record that may be a translation of another.
+type TranslationCandidate struct {
+	Record *model.Record `json:"record"`
+
+	// Score is how alike the two records look from 0.0 to 1.0.  See
+	// translationScore.
+	Score float64 `json:"score"`
+}
+
+// neutralTokens returns the numbers and Latin words in s, such as names,
+// which are written the same in every language.  Full width forms are
+// folded to ASCII.
+func neutralTokens(s string) map[string]bool {
+	tokens := map[string]bool{}
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			tokens[b.String()] = true
+			b.Reset()
+		}
+	}
+	for _, r := range s {
+		if r >= 0xff01 && r <= 0xff5e {
+			r -= 0xfee0
+		}
+		r = unicode.ToLower(r)
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// translationScore returns how likely a and b, which are in different
+// languages, are the same text.  Text can't be compared across languages so
+// it is a blend of how many of the numbers and Latin words of the record
+// with fewer of them are in the other and how close their line counts are.
+func translationScore(a, b *model.Record) float64 {
+	if a.Index != nil && b.Index != nil && *a.Index == *b.Index {
+		return 1.0
+	}
+
+	aTokens := neutralTokens(a.Subject + "\n" + a.Text)
+	bTokens := neutralTokens(b.Subject + "\n" + b.Text)
+	shared := 0
+	for t := range aTokens {
+		if bTokens[t] {
+			shared++
+		}
+	}
+	tokenScore := 0.0
+	if n := len(aTokens); n > 0 && len(bTokens) > 0 {
+		if len(bTokens) < n {
+			n = len(bTokens)
+		}
+		tokenScore = float64(shared) / float64(n)
+	}
+
+	aLines, bLines := len(splitLines(a.Text)), len(splitLines(b.Text))
+	lineScore := 1.0
+	if aLines != bLines {
+		min, max := aLines, bLines
+		if min > max {
+			min, max = max, min
+		}
+		lineScore = float64(min) / float64(max)
+	}
+
+	return (2*tokenScore + lineScore) / 3
+}
+
+// translationCandidates returns the records that could be translations of
+// record, most likely first.
+func (l *LaCodex) translationCandidates(record *model.Record) ([]*TranslationCandidate, error) {
+	records, err := l.allRecords()
+	if err != nil {
+		return nil, err
+	}
+	languages := map[int]model.Language{}
+	for _, r := range records {
+		languages[r.Id] = r.Language.OrDefault()
+	}
+
+	candidates := []*TranslationCandidate{}
+	for _, c := range records {
+		if !isTranslationCandidate(record, c, languages) {
+			continue
+		}
+		candidates = append(candidates, &TranslationCandidate{
+			Record: withoutLines(c),
+			Score:  translationScore(record, c),
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates, nil
+}
+
+// moveTranslations moves the translations of from, which is about to be
+// deleted, to to.  to is reloaded so that it is up to date.  Must be called
+// with recordMutex held.
+func (l *LaCodex) moveTranslations(from, to *model.Record) error {
+	if len(from.Translations) == 0 {
+		return nil
+	}
+
+	err := l.records.One("Id", to.Id, to)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range from.Translations {
+		var t model.Record
+		err := l.records.One("Id", id, &t)
+		if err != nil {
+			return err
+		}
+		t.Translations = removeId(t.Translations, from.Id)
+		if t.Id != to.Id && t.Language.OrDefault() != to.Language.OrDefault() {
+			t.Translations = addId(t.Translations, to.Id)
+			to.Translations = addId(to.Translations, t.Id)
+		}
+		err = l.saveIngestedRecord(&t)
+		if err != nil {
+			return err
+		}
+	}
+	return l.saveIngestedRecord(to)
+}
+
+// translation returns the translation of record in lang or nil if it
+// doesn't have one.
+func (l *LaCodex) translation(record *model.Record, lang model.Language) (*model.Record, error) {
+	for _, id := range record.Translations {
+		t, err := l.getRecord(id)
+		if err == storm.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t.Language.OrDefault() == lang {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// collapseTranslations returns hits with only the first of each set of
+// translations.  If lang is set, hits in other languages are replaced by
+// their translation in lang or dropped if they don't have one.
+func (l *LaCodex) collapseTranslations(hits []*search.Hit, lang model.Language) ([]*search.Hit, error) {
+	collapsed := []*search.Hit{}
+	seen := map[int]bool{}
+	for _, hit := range hits {
+		if lang != "" && hit.Record.Language.OrDefault() != lang {
+			t, err := l.translation(hit.Record, lang)
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				continue
+			}
+			// The snippet is of the other language's text.
+			hit = &search.Hit{Record: t, Score: hit.Score}
+		}
+
+		if seen[hit.Record.Id] {
+			continue
+		}
+		seen[hit.Record.Id] = true
+		for _, id := range hit.Record.Translations {
+			seen[id] = true
+		}
+		collapsed = append(collapsed, hit)
+	}
+	return collapsed, nil
+}
+
+// translationRecords returns the two records of a translations request.
+// Writes an error and returns nil if the request is bad.
+func (l *LaCodex) translationRecords(w http.ResponseWriter, r *http.Request) (*model.Record, *model.Record) {
+	id, err := recordId(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return nil, nil
+	}
+	s := bone.GetValue(r, "other")
+	other, err := strconv.Atoi(s)
+	if err != nil || other <= 0 {
+		httpError(w, http.StatusBadRequest, "Bad record id %s", s)
+		return nil, nil
+	}
+	if other == id {
+		httpError(w, http.StatusBadRequest, "Record %d can't be its own translation", id)
+		return nil, nil
+	}
+
+	a, err := l.getRecord(id)
+	if err != nil {
+		recordError(w, id, err)
+		return nil, nil
+	}
+	b, err := l.getRecord(other)
+	if err != nil {
+		recordError(w, other, err)
+		return nil, nil
+	}
+	return a, b
+}
+
+// translationPutHandler serves PUT /record/:id/translations/:other
+//
+// Links the two records as translations of each other and returns the
+// record.
+func (l *LaCodex) translationPutHandler(w http.ResponseWriter, r *http.Request) {
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	a, b := l.translationRecords(w, r)
+	if a == nil {
+		return
+	}
+	if a.Language.OrDefault() == b.Language.OrDefault() {
+		httpError(w, http.StatusBadRequest, "Records %d and %d are both %s",
+			a.Id, b.Id, a.Language.OrDefault())
+		return
+	}
+
+	err := l.linkTranslations(a.Id, b.Id)
+	if err != nil {
+		recordError(w, a.Id, err)
+		return
+	}
+	l.publishUpdate()
+
+	record, err := l.getRecord(a.Id)
+	if err != nil {
+		recordError(w, a.Id, err)
+		return
+	}
+	writeRecord(w, record)
+}
+
+// translationDeleteHandler serves DELETE /record/:id/translations/:other
+func (l *LaCodex) translationDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	l.recordMutex.Lock()
+	defer l.recordMutex.Unlock()
+
+	a, b := l.translationRecords(w, r)
+	if a == nil {
+		return
+	}
+
+	err := l.unlinkTranslations(a.Id, b.Id)
+	if err != nil {
+		recordError(w, a.Id, err)
+		return
+	}
+	l.publishUpdate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// translationCandidatesHandler serves
+// GET /record/:id/translations/candidates
+//
+// Returns the records that could be translations of the record but aren't
+// linked to one in its language, most likely first.
+func (l *LaCodex) translationCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := recordId(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	record, err := l.getRecord(id)
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+
+	candidates, err := l.translationCandidates(record)
+	if err != nil {
+		recordError(w, id, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candidates)
+}
@@ -0,0 +1,249 @@
+package lacodex
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/konkers/lacodex/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddRemoveId(t *testing.T) {
+	ids := addId(nil, 1)
+	ids = addId(ids, 2)
+	ids = addId(ids, 1)
+	assert.Equal(t, []int{1, 2}, ids)
+
+	assert.Equal(t, []int{2}, removeId(ids, 1))
+	assert.Equal(t, []int{1, 2}, removeId(ids, 3))
+	assert.Nil(t, removeId([]int{1}, 1))
+}
+
+// saveTestRecords saves records as if they were freshly ingested.
+func (tlc *testLC) saveTestRecords(t *testing.T, records ...*model.Record) {
+	tlc.l.recordMutex.Lock()
+	defer tlc.l.recordMutex.Unlock()
+
+	for _, record := range records {
+		_, err := tlc.l.saveNewRecord(record, "test.png")
+		assert.NoError(t, err)
+	}
+}
+
+func TestNeutralTokens(t *testing.T) {
+	assert.Equal(t, map[string]bool{"3": true, "lights": true, "la": true, "mulana": true},
+		neutralTokens("3 lights, La-Mulana."))
+	assert.Equal(t, map[string]bool{"3": true, "la": true, "mulana": true},
+		neutralTokens("ＬＡ－ＭＵＬＡＮＡに３つの光を"))
+	assert.Empty(t, neutralTokens("天の宝石。"))
+}
+
+func TestTranslationScore(t *testing.T) {
+	en := &model.Record{Text: "Offer 3 lights\nto La-Mulana."}
+	ja := &model.Record{Text: "La-Mulanaに\n3つの光を捧げよ。"}
+	other := &model.Record{Text: "天の宝石。"}
+
+	assert.InDelta(t, 1.0, translationScore(en, ja), 1e-9)
+	assert.InDelta(t, 0.5/3, translationScore(en, other), 1e-9)
+
+	one := 1
+	assert.Equal(t, 1.0, translationScore(&model.Record{Index: &one}, &model.Record{Index: &one}))
+}
+
+func (tlc *testLC) TranslationCandidates(t *testing.T, id int) []*TranslationCandidate {
+	url := fmt.Sprintf("http://%s/record/%d/translations/candidates", tlc.l.config.ListenAddr, id)
+	var candidates []*TranslationCandidate
+	err := json.Unmarshal([]byte(testGet(t, url)), &candidates)
+	assert.NoError(t, err)
+	return candidates
+}
+
+func TestTranslationCandidates(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	tlc.saveTestRecords(t,
+		&model.Record{
+			Type:     model.RecordTypeScanner,
+			Text:     "Offer 3 lights\nto La-Mulana.",
+			Language: model.LanguageEnglish,
+		},
+		&model.Record{
+			Type:     model.RecordTypeScanner,
+			Text:     "天の宝石。",
+			Language: model.LanguageJapanese,
+		},
+		&model.Record{
+			Type:     model.RecordTypeScanner,
+			Text:     "La-Mulanaに\n3つの光を捧げよ。",
+			Language: model.LanguageJapanese,
+		},
+		// Other types and languages aren't candidates.
+		&model.Record{
+			Type:     model.RecordTypeTent,
+			Text:     "La-Mulanaに3つの光を。",
+			Language: model.LanguageJapanese,
+		},
+		&model.Record{
+			Type:     model.RecordTypeScanner,
+			Text:     "Offer 3 lights.",
+			Language: model.LanguageEnglish,
+		},
+	)
+
+	// Unindexed records aren't linked automatically.
+	assert.Nil(t, tlc.translations(t, 1))
+
+	candidates := tlc.TranslationCandidates(t, 1)
+	if assert.Len(t, candidates, 2) {
+		assert.Equal(t, 3, candidates[0].Record.Id)
+		assert.Equal(t, 2, candidates[1].Record.Id)
+		assert.True(t, candidates[0].Score > candidates[1].Score)
+	}
+
+	// Records already linked to a translation in the language drop out.
+	status, _ := tlc.RecordRequest(t, "PUT", "5/translations/3", "")
+	assert.Equal(t, http.StatusOK, status)
+	candidates = tlc.TranslationCandidates(t, 1)
+	if assert.Len(t, candidates, 1) {
+		assert.Equal(t, 2, candidates[0].Record.Id)
+	}
+
+	testBadGet(t, fmt.Sprintf("http://%s/record/9/translations/candidates", tlc.l.config.ListenAddr))
+}
+
+func (tlc *testLC) translations(t *testing.T, id int) []int {
+	var record model.Record
+	err := tlc.l.records.One("Id", id, &record)
+	assert.NoError(t, err)
+	return record.Translations
+}
+
+func TestTranslations(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	one := 1
+	two := 2
+	tlc.saveTestRecords(t,
+		&model.Record{
+			Type:     model.RecordTypeMailer,
+			Index:    &one,
+			Text:     "The jewel of the heavens.",
+			Language: model.LanguageEnglish,
+		},
+		&model.Record{
+			Type:     model.RecordTypeMailer,
+			Index:    &two,
+			Text:     "Another jewel.",
+			Language: model.LanguageEnglish,
+		},
+		&model.Record{
+			Type:     model.RecordTypeMailer,
+			Index:    &one,
+			Text:     "天の宝石。",
+			Language: model.LanguageJapanese,
+		},
+		// Records without an index aren't linked automatically.
+		&model.Record{
+			Type:     model.RecordTypeScanner,
+			Text:     "光の宝石。",
+			Language: model.LanguageJapanese,
+		},
+	)
+
+	// The same tablet in two languages is linked rather than merged.
+	assert.Equal(t, []int{3}, tlc.translations(t, 1))
+	assert.Nil(t, tlc.translations(t, 2))
+	assert.Equal(t, []int{1}, tlc.translations(t, 3))
+	assert.Nil(t, tlc.translations(t, 4))
+
+	// Only the best matching of a set of translations is returned.
+	hits := tlc.Search(t, "q=jewel")
+	if assert.Len(t, hits, 2) {
+		assert.Equal(t, 1, hits[0].Record.Id)
+		assert.Equal(t, 2, hits[1].Record.Id)
+	}
+
+	// Matches are swapped for their translation in lang.
+	hits = tlc.Search(t, "q=heavens&lang=ja")
+	if assert.Len(t, hits, 1) {
+		assert.Equal(t, 3, hits[0].Record.Id)
+		assert.Equal(t, "", hits[0].Snippet)
+	}
+	assert.Len(t, tlc.Search(t, "q=another&lang=ja"), 0)
+	assert.Len(t, tlc.Search(t, "q=宝石&lang=ja"), 2)
+
+	status, record := tlc.RecordRequest(t, "PUT", "4/translations/2", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []int{2}, record.Translations)
+	assert.Equal(t, []int{4}, tlc.translations(t, 2))
+	assert.Len(t, tlc.Search(t, "q=another&lang=ja"), 1)
+
+	status, _ = tlc.RecordRequest(t, "DELETE", "1/translations/3", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	assert.Nil(t, tlc.translations(t, 1))
+	assert.Nil(t, tlc.translations(t, 3))
+	assert.Len(t, tlc.Search(t, "q=heavens&lang=ja"), 0)
+
+	base := fmt.Sprintf("http://%s/record/search?q=jewel", tlc.l.config.ListenAddr)
+	testBadGet(t, base+"&lang=fr")
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"1/translations/2", http.StatusBadRequest},
+		{"1/translations/1", http.StatusBadRequest},
+		{"1/translations/x", http.StatusBadRequest},
+		{"1/translations/9", http.StatusNotFound},
+		{"9/translations/1", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		status, _ := tlc.RecordRequest(t, "PUT", test.id, "")
+		assert.Equal(t, test.status, status, test.id)
+	}
+}
+
+func TestMergeTranslations(t *testing.T) {
+	tlc := newTestLC(t)
+	defer tlc.Shutdown()
+
+	one := 1
+	tlc.saveTestRecords(t,
+		&model.Record{
+			Type:     model.RecordTypeMailer,
+			Index:    &one,
+			Text:     "The jewel of the heavens.",
+			Language: model.LanguageEnglish,
+		},
+		&model.Record{
+			Type:     model.RecordTypeMailer,
+			Index:    &one,
+			Text:     "天の宝石。",
+			Language: model.LanguageJapanese,
+		},
+	)
+
+	// Simulate a duplicate from before records were deduplicated on
+	// upload that got linked instead of the first record.
+	dup := &model.Record{
+		Type:  model.RecordTypeMailer,
+		Index: &one,
+		Text:  "The jewel of the heavens",
+	}
+	err := tlc.l.records.Save(dup)
+	assert.NoError(t, err)
+	err = tlc.l.unlinkTranslations(1, 2)
+	assert.NoError(t, err)
+	err = tlc.l.linkTranslations(dup.Id, 2)
+	assert.NoError(t, err)
+
+	merged, err := tlc.l.MergeDuplicates()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, merged)
+	assert.Equal(t, []int{2}, tlc.translations(t, 1))
+	assert.Equal(t, []int{1}, tlc.translations(t, 2))
+}
@@ -0,0 +1,163 @@
+package lacodex
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/konkers/lacodex/imagedb"
+)
+
+// How often screenshot directories are re-scanned for new files.
+var watchInterval = 5 * time.Second
+
+type watcher struct {
+	l    *LaCodex
+	dirs []string
+
+	// Files that failed to load, keyed by path.  We only retry them once
+	// their modification time changes so that a bad file doesn't spam the
+	// logs on every scan.
+	failed map[string]time.Time
+
+	// Files that are queued for ingestion, keyed by path.
+	pending map[string]pendingFile
+}
+
+type pendingFile struct {
+	jobId   int
+	modTime time.Time
+}
+
+func newWatcher(l *LaCodex, dirs []string) *watcher {
+	return &watcher{
+		l:       l,
+		dirs:    dirs,
+		failed:  map[string]time.Time{},
+		pending: map[string]pendingFile{},
+	}
+}
+
+// loadImage returns the decoded image in fileName along with the file's
+// contents.
+func loadImage(fileName string) (image.Image, []byte, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Can't decode %s: %v", fileName, err)
+	}
+
+	return img, data, nil
+}
+
+// loadScreenshot loads the screenshot at path and works out when it was
+// captured.
+func (w *watcher) loadScreenshot(path string, f os.FileInfo) (image.Image, *imagedb.Capture, error) {
+	img, data, err := loadImage(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	capture, err := w.l.idb.CaptureTime(&imagedb.CaptureInfo{
+		FileName: f.Name(),
+		Data:     data,
+		ModTime:  f.ModTime(),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, capture, nil
+}
+
+func (w *watcher) scanDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !w.l.idb.IsScreenshotName(f.Name()) {
+			continue
+		}
+
+		if meta, _ := w.l.idb.LookupFile(f.Name()); meta != nil {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		if _, ok := w.pending[path]; ok {
+			continue
+		}
+		if modTime, ok := w.failed[path]; ok && modTime.Equal(f.ModTime()) {
+			continue
+		}
+
+		img, capture, err := w.loadScreenshot(path, f)
+		if err != nil {
+			glog.Warningf("Can't add %s: %v", path, err)
+			w.failed[path] = f.ModTime()
+			continue
+		}
+		delete(w.failed, path)
+
+		// Waits for room in the queue so that a large backlog of
+		// screenshots isn't all loaded at once.
+		job := w.l.jobs.addWait(img, f.Name(), w.l.config.Language, capture)
+		if job == nil {
+			// Shutting down.
+			return nil
+		}
+		w.pending[path] = pendingFile{jobId: job.Id, modTime: f.ModTime()}
+	}
+
+	return nil
+}
+
+// checkPending moves files whose jobs have finished out of pending.
+func (w *watcher) checkPending() {
+	for path, p := range w.pending {
+		job := w.l.jobs.get(p.jobId)
+		if job != nil && (job.Status == JobQueued || job.Status == JobRunning) {
+			continue
+		}
+		if job != nil && job.Status == JobFailed {
+			w.failed[path] = p.modTime
+		}
+		delete(w.pending, path)
+	}
+}
+
+func (w *watcher) scan() {
+	w.checkPending()
+
+	for _, dir := range w.dirs {
+		err := w.scanDir(dir)
+		warnIfError(err, "Can't scan screenshot directory %s", dir)
+	}
+}
+
+// run does an initial scan of all directories then rescans them every
+// watchInterval until shutdown is closed.
+func (w *watcher) run(shutdown chan struct{}) {
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		w.scan()
+
+		select {
+		case <-ticker.C:
+		case <-shutdown:
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package lacodex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func copyTestFile(t *testing.T, src string, dest string) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(dest, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitForImages(t *testing.T, tlc *testLC, n int) {
+	for i := 0; i < 100; i++ {
+		if len(tlc.GetImages(t)) >= n {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d images", n)
+}
+
+func TestWatcher(t *testing.T) {
+	watchInterval = 50 * time.Millisecond
+	defer func() { watchInterval = 5 * time.Second }()
+
+	dir, err := ioutil.TempDir("", "lacodex-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Present before startup, picked up by the initial scan.
+	copyTestFile(t, "testdata/screenshots/230700_20190519134140_1.png",
+		filepath.Join(dir, "230700_20190519134140_1.png"))
+
+	// Files that don't look like screenshots are ignored.
+	copyTestFile(t, "testdata/screenshots/230700_20190519134145_1.png",
+		filepath.Join(dir, "not-a-screenshot.png"))
+	os.Mkdir(filepath.Join(dir, "thumbnails"), 0755)
+
+	// Undecodable screenshots are skipped.
+	copyTestFile(t, "testdata/bad_images/undecodable.png",
+		filepath.Join(dir, "230700_20190517185334_1.png"))
+
+	tlc := newTestLCWithConfig(t, &Config{
+		ScreenshotDirs: []string{dir, filepath.Join(dir, "does-not-exist")},
+	})
+	defer tlc.Shutdown()
+
+	waitForImages(t, tlc, 1)
+
+	// Added after startup.
+	copyTestFile(t, "testdata/screenshots/230700_20190519134145_1.png",
+		filepath.Join(dir, "230700_20190519134145_1.png"))
+	waitForImages(t, tlc, 2)
+
+	imgs := tlc.GetImages(t)
+	assert.Len(t, imgs, 2)
+	assert.Equal(t, "230700_20190519134140_1.png", imgs[0].FileName)
+	assert.Equal(t, "230700_20190519134145_1.png", imgs[1].FileName)
+}
@@ -37,8 +37,9 @@ var upgrader = websocket.Upgrader{
 type WsQueryFunc func(w io.Writer) error
 
 type wsHandler struct {
-	ps *pubsub.PubSub
-	f  WsQueryFunc
+	ps    *pubsub.PubSub
+	topic string
+	f     WsQueryFunc
 }
 
 func (h *wsHandler) reader(ws *websocket.Conn) {
@@ -64,7 +65,7 @@ func (h *wsHandler) writer(ws *websocket.Conn, closeC chan struct{}) {
 		pingTicker.Stop()
 		ws.Close()
 	}()
-	updateC := h.ps.Sub("update")
+	updateC := h.ps.Sub(h.topic)
 	exitC := h.ps.Sub("exit")
 
 	defer func() {
@@ -138,10 +139,18 @@ func (h *wsHandler) handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// WsHandler serves the result of q.  With ?async the result is sent over a
+// websocket each time "update" is published.
 func WsHandler(ps *pubsub.PubSub, q WsQueryFunc) http.HandlerFunc {
+	return WsTopicHandler(ps, "update", q)
+}
+
+// WsTopicHandler is WsHandler for a different pubsub topic.
+func WsTopicHandler(ps *pubsub.PubSub, topic string, q WsQueryFunc) http.HandlerFunc {
 	h := &wsHandler{
-		ps: ps,
-		f:  q,
+		ps:    ps,
+		topic: topic,
+		f:     q,
 	}
 	return http.HandlerFunc(h.handler)
 }